@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/namsral/flag"
+)
+
+var flagAdminAddress string
+
+func addAdminFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagAdminAddress, "web.admin-listen-address", "127.0.0.1:9043", "The address to listen on for admin requests (/-/reload, /-/invalidate-cache, /debug/pprof), empty to disable.")
+}
+
+// newAdminMux creates the handler for the operational endpoints, which are served separately from /metrics
+func newAdminMux(set *collectorSet) *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/-/reload", protect(postOnly(func(w http.ResponseWriter, r *http.Request) {
+		err := set.load()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reload failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "reloaded")
+	})))
+
+	mux.Handle("/-/invalidate-cache", protect(postOnly(func(w http.ResponseWriter, r *http.Request) {
+		err := set.invalidate()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalidation failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "cache invalidated")
+	})))
+
+	mux.Handle("/debug/pprof/", protect(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", protect(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", protect(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", protect(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", protect(http.HandlerFunc(pprof.Trace)))
+
+	return mux
+}
+
+func postOnly(handler http.HandlerFunc) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	})
+}
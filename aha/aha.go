@@ -0,0 +1,106 @@
+// Package aha parses the device list of the AVM Home Automation HTTP interface (AHA-HTTP), which reports
+// more details of the smart home devices than TR-064, e.g. the window open detection of thermostats.
+package aha
+
+import (
+	"encoding/xml"
+)
+
+// Path of the AHA-HTTP interface, the command is passed as switchcmd with the sid of a lua session
+const Path = "/webservices/homeautoswitch.lua"
+
+// DeviceListInfosCommand returns the XML device list with the state of all smart home devices
+const DeviceListInfosCommand = "getdevicelistinfos"
+
+// raw temperature values of the thermostat switching the valve permanently
+const (
+	temperatureOff = 253
+	temperatureOn  = 254
+)
+
+// DeviceList is the result of getdevicelistinfos
+type DeviceList struct {
+	XMLName xml.Name `xml:"devicelist"`
+	Devices []Device `xml:"device"`
+}
+
+// Device is a smart home device, thermostats (HKR) report their state in Thermostat
+type Device struct {
+	// Identifier is the AIN of the device
+	Identifier  string      `xml:"identifier,attr"`
+	ProductName string      `xml:"productname,attr"`
+	Name        string      `xml:"name"`
+	Present     int         `xml:"present"`
+	Battery     *int        `xml:"battery"`
+	BatteryLow  *int        `xml:"batterylow"`
+	Thermostat  *Thermostat `xml:"hkr"`
+}
+
+// Thermostat is the state of a radiator controller (FRITZ!DECT 301, Comet DECT). Temperatures are
+// in steps of 0.5 °C, 253 means off and 254 on.
+type Thermostat struct {
+	Measured         int  `xml:"tist"`
+	Target           int  `xml:"tsoll"`
+	Reduced          int  `xml:"absenk"`
+	Comfort          int  `xml:"komfort"`
+	ErrorCode        int  `xml:"errorcode"`
+	WindowOpenActive int  `xml:"windowopenactiv"`
+	BoostActive      int  `xml:"boostactive"`
+	HolidayActive    int  `xml:"holidayactive"`
+	SummerActive     int  `xml:"summeractive"`
+	Battery          *int `xml:"battery"`
+	BatteryLow       *int `xml:"batterylow"`
+}
+
+// Parse reads the device list of getdevicelistinfos
+func Parse(body []byte) (*DeviceList, error) {
+
+	var list DeviceList
+	err := xml.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Celsius converts a raw temperature of the thermostat, ok is false for the special values off and on
+func Celsius(raw int) (celsius float64, ok bool) {
+
+	if raw == temperatureOff || raw == temperatureOn {
+		return 0, false
+	}
+	return float64(raw) / 2, true
+}
+
+// ValveState describes how the thermostat drives the valve: closed (off), open (on) or temperature (regulating)
+func (t *Thermostat) ValveState() string {
+
+	switch t.Target {
+	case temperatureOff:
+		return "closed"
+	case temperatureOn:
+		return "open"
+	}
+	return "temperature"
+}
+
+// IsBatteryLow reports the low battery warning of the device, older firmware reports it in the hkr element
+func (d *Device) IsBatteryLow() bool {
+
+	if d.BatteryLow != nil {
+		return *d.BatteryLow != 0
+	}
+	return d.Thermostat != nil && d.Thermostat.BatteryLow != nil && *d.Thermostat.BatteryLow != 0
+}
+
+// BatteryPercent returns the battery charge of the device, if reported
+func (d *Device) BatteryPercent() (int, bool) {
+
+	if d.Battery != nil {
+		return *d.Battery, true
+	}
+	if d.Thermostat != nil && d.Thermostat.Battery != nil {
+		return *d.Thermostat.Battery, true
+	}
+	return 0, false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/namsral/flag"
+)
+
+var (
+	flagTargetAllow   string
+	flagTargetSchemes string
+)
+
+func addTargetAllowFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagTargetAllow, "target.allow", "", "Comma separated host names, IP addresses and CIDRs of the FRITZ!Boxes the exporter may connect to and send the credentials to (empty = any).")
+	fs.StringVar(&flagTargetSchemes, "target.schemes", "http,https", "Comma separated URL schemes allowed for the URLs of the FRITZ!Boxes.")
+}
+
+// targetAllowlist restricts the FRITZ!Boxes the exporter connects to, so it can't be made to send the
+// credentials to other hosts or to proxy requests into the LAN
+type targetAllowlist struct {
+	hosts   map[string]bool
+	nets    []*net.IPNet
+	schemes map[string]bool
+}
+
+// parseTargetAllowlist parses the comma separated hosts (names, IP addresses, CIDRs) and URL schemes,
+// no hosts allow any host and no schemes http and https
+func parseTargetAllowlist(hosts string, schemes string) (*targetAllowlist, error) {
+
+	allowlist := &targetAllowlist{hosts: map[string]bool{}, schemes: map[string]bool{}}
+	for _, scheme := range strings.Split(schemes, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" {
+			allowlist.schemes[scheme] = true
+		}
+	}
+	if len(allowlist.schemes) == 0 {
+		allowlist.schemes["http"] = true
+		allowlist.schemes["https"] = true
+	}
+
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if strings.Contains(host, "/") {
+			_, ipNet, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target CIDR %q: %v", host, err)
+			}
+			allowlist.nets = append(allowlist.nets, ipNet)
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			allowlist.nets = append(allowlist.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		allowlist.hosts[strings.ToLower(host)] = true
+	}
+	return allowlist, nil
+}
+
+// checkURL returns an error if scheme or host of the URL are not allowed
+func (a *targetAllowlist) checkURL(rawURL string) error {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	if !a.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("scheme of %s not allowed by -target.schemes", rawURL)
+	}
+	if len(a.hosts) == 0 && len(a.nets) == 0 {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if a.hosts[host] || a.containsIP(host) {
+		return nil
+	}
+	return fmt.Errorf("host of %s not allowed by -target.allow", rawURL)
+}
+
+// checkAddress returns an error if the IP address of a connection is outside the listed IP addresses and CIDRs,
+// so a host name resolving to another address (DNS rebinding) is refused. Without IP addresses and CIDRs
+// in the allowlist any address is allowed.
+func (a *targetAllowlist) checkAddress(address string) error {
+
+	if len(a.nets) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if a.containsIP(host) {
+		return nil
+	}
+	return fmt.Errorf("connection to %s not allowed by -target.allow", address)
+}
+
+func (a *targetAllowlist) containsIP(host string) bool {
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range a.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTarget checks the URLs of the target against the allowlist of the flags, before any request is sent to it
+func checkTarget(t target) error {
+
+	allowlist, err := parseTargetAllowlist(flagTargetAllow, flagTargetSchemes)
+	if err != nil {
+		return err
+	}
+	for _, u := range []string{t.upnpURL, t.luaURL} {
+		if u == "" {
+			continue
+		}
+		err = allowlist.checkURL(u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTargetAddress checks the address of each connection to a FRITZ!Box against the allowlist of the flags
+func checkTargetAddress(address string) error {
+
+	allowlist, err := parseTargetAllowlist(flagTargetAllow, flagTargetSchemes)
+	if err != nil {
+		return err
+	}
+	return allowlist.checkAddress(address)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/namsral/flag"
+
+	"github.com/aexel90/fritzbox_exporter/ssdp"
+)
+
+var (
+	flagAutoDiscover        bool
+	flagAutoDiscoverTimeout time.Duration
+)
+
+func addAutoDiscoverFlags(fs *flag.FlagSet) {
+
+	fs.BoolVar(&flagAutoDiscover, "auto-discover", false, "Find the FRITZ!Boxes on the LAN via SSDP instead of the gateway URL flags (discover lists them, serve collects all of them).")
+	fs.DurationVar(&flagAutoDiscoverTimeout, "auto-discover.timeout", 3*time.Second, "How long to wait for SSDP responses of the FRITZ!Boxes.")
+}
+
+// discoverTargets finds the FRITZ!Boxes on the LAN, the credentials are taken from the flags. Any host on the LAN
+// can answer the search, so the credentials are only used for responders proven to be a FRITZ!Box by their
+// description and passing the target allowlist.
+func discoverTargets() ([]target, error) {
+
+	devices, err := ssdp.Search(context.Background(), ssdp.FritzBoxTarget, flagAutoDiscoverTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("SSDP search failed: %v", err)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no FRITZ!Box found on the LAN within %s", flagAutoDiscoverTimeout)
+	}
+
+	targets := []target{}
+	for _, d := range devices {
+		t := target{upnpURL: d.BaseURL(), luaURL: "http://" + d.Host(), username: flagUsername, password: flagPassword}
+		err = checkDiscoveredDevice(d, t)
+		if err != nil {
+			fmt.Printf("ignoring %s at %s: %v\n", d.Server, d.Location, err)
+			continue
+		}
+		fmt.Printf("found %s at %s\n", d.Server, d.BaseURL())
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no FRITZ!Box found on the LAN within %s", flagAutoDiscoverTimeout)
+	}
+	return targets, nil
+}
+
+// checkDiscoveredDevice checks that the responder announced itself, passes the allowlist and is made by AVM
+func checkDiscoveredDevice(d ssdp.Device, t target) error {
+
+	ip := net.ParseIP(d.Host())
+	if ip == nil || !ip.Equal(net.ParseIP(d.Address)) {
+		return fmt.Errorf("location host %q doesn't match the responder %s", d.Host(), d.Address)
+	}
+	err := checkTarget(t)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(d.Server, "AVM") {
+		return fmt.Errorf("server %q is no AVM device", d.Server)
+	}
+	manufacturer, err := deviceManufacturer(d.Location)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(manufacturer, "AVM") {
+		return fmt.Errorf("manufacturer %q is not AVM", manufacturer)
+	}
+	return nil
+}
+
+// deviceManufacturer reads the manufacturer from the device description (tr64desc.xml), which needs no credentials
+func deviceManufacturer(location string) (string, error) {
+
+	client := &http.Client{Timeout: flagAutoDiscoverTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("device description: %s", resp.Status)
+	}
+
+	var root struct {
+		Device struct {
+			Manufacturer string `xml:"manufacturer"`
+		} `xml:"device"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&root)
+	if err != nil {
+		return "", fmt.Errorf("device description: %v", err)
+	}
+	return root.Device.Manufacturer, nil
+}
+
+// listDiscoveredTargets prints the FRITZ!Boxes found on the LAN with their gateway URLs
+func listDiscoveredTargets() error {
+
+	targets, err := discoverTargets()
+	if err != nil {
+		return &exitError{exitFatal, err}
+	}
+	for _, t := range targets {
+		fmt.Printf("  -gateway-upnp-url %s -gateway-lua-url %s\n", t.upnpURL, t.luaURL)
+	}
+	return nil
+}
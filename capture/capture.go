@@ -0,0 +1,191 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// redactedSID replaces session ids, it is no valid session of a box but accepted by the lua exporter on replay
+const redactedSID = "ffffffffffffffff"
+
+const redacted = "REDACTED"
+
+// volatileParams are dropped from the request keys, they differ on every login
+var volatileParams = []string{"sid", "response", "username", "password"}
+
+var (
+	secretNames = `(?i:[\w-]*(?:password|passwd|passphrase|psk|secret|presharedkey|wepkey)[\w-]*)`
+	xmlSecret   = regexp.MustCompile(`<(` + secretNames + `)>[^<]*</`)
+	jsonSecret  = regexp.MustCompile(`"(` + secretNames + `)"(\s*:\s*)"[^"]*"`)
+	xmlSID      = regexp.MustCompile(`<SID>[0-9a-fA-F]+</SID>`)
+	jsonSID     = regexp.MustCompile(`"sid"(\s*:\s*)"[0-9a-fA-F]+"`)
+	unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// Response is a stored response of the box
+type Response struct {
+	Request     string `json:"request"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body"`
+}
+
+// Recorder stores the responses of the wrapped transport in a directory with the secrets
+// (passwords, keys, session ids) redacted, one file per request
+type Recorder struct {
+	Next http.RoundTripper
+	Dir  string
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return resp, nil
+	}
+
+	// the auth challenges are answered by the retried request of the same key
+	file := filepath.Join(r.Dir, fileName(key))
+	if resp.StatusCode == http.StatusUnauthorized {
+		if _, err := os.Stat(file); err == nil {
+			return resp, nil
+		}
+	}
+	data, err := json.MarshalIndent(&Response{Request: key, Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: Redact(string(body))}, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(r.Dir, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(file, data, 0644)
+	}
+	if err != nil {
+		fmt.Println("Error: recording response: ", err)
+	}
+	return resp, nil
+}
+
+// Replayer answers the requests with the responses stored by a Recorder, without connecting to the box
+type Replayer struct {
+	Dir string
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(r.Dir, fileName(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no recorded response for %s", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recorded Response
+	err = json.Unmarshal(data, &recorded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded response for %s: %v", key, err)
+	}
+
+	header := http.Header{}
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Redact replaces passwords, keys and session ids in XML and JSON responses
+func Redact(body string) string {
+
+	body = xmlSID.ReplaceAllString(body, "<SID>"+redactedSID+"</SID>")
+	body = jsonSID.ReplaceAllString(body, `"sid"${1}"`+redactedSID+`"`)
+	body = xmlSecret.ReplaceAllString(body, "<${1}>"+redacted+"</")
+	return jsonSecret.ReplaceAllString(body, `"${1}"${2}"`+redacted+`"`)
+}
+
+// requestKey identifies the request by method, path, parameters, SOAP action and body without the session
+// and login parameters, and restores the body for the transport
+func requestKey(req *http.Request) (string, error) {
+
+	query := req.URL.Query()
+	for _, param := range volatileParams {
+		query.Del(param)
+	}
+	key := req.Method + " " + req.URL.Path
+	if len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	if action := req.Header.Get("SOAPAction"); action != "" {
+		key += " " + strings.Trim(action, `"`)
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return key, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err == nil {
+			for _, param := range volatileParams {
+				form.Del(param)
+			}
+			return key + " " + form.Encode(), nil
+		}
+	}
+	return key + " " + string(body), nil
+}
+
+// fileName is the readable path of the request with a hash of the key
+func fileName(key string) string {
+
+	name := strings.SplitN(key, " ", 3)[1]
+	name = strings.Trim(unsafeChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > 60 {
+		name = name[:60]
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%x.json", name, sum[:8])
+}
@@ -0,0 +1,99 @@
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const soapFaultXML = `<?xml version="1.0"?>` +
+	`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+	`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>` +
+	`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>501</errorCode><errorDescription>Injected Failure</errorDescription></UPnPError></detail>` +
+	`</s:Fault></s:Body></s:Envelope>`
+
+// failure kinds which can be injected
+var failures = map[string]func(req *http.Request) (*http.Response, error){
+	"timeout": func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("injected failure: %w", context.DeadlineExceeded)
+	},
+	"error": func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("injected failure: connection refused")
+	},
+	"soapfault": func(req *http.Request) (*http.Response, error) {
+		return response(req, http.StatusInternalServerError, soapFaultXML), nil
+	},
+	"unauthorized": func(req *http.Request) (*http.Response, error) {
+		return response(req, http.StatusUnauthorized, ""), nil
+	},
+}
+
+// Failure is a failure kind with the probability of its injection per request
+type Failure struct {
+	Kind        string
+	Probability float64
+}
+
+// ParseFailures parses a failure specification like "timeout:0.05,soapfault:0.02"
+func ParseFailures(spec string) ([]Failure, error) {
+
+	var result []Failure
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid failure '%s', expected kind:probability", part)
+		}
+		if _, ok := failures[kv[0]]; !ok {
+			return nil, fmt.Errorf("unknown failure kind '%s'", kv[0])
+		}
+		probability, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || probability < 0 || probability > 1 {
+			return nil, fmt.Errorf("invalid probability '%s' for failure '%s'", kv[1], kv[0])
+		}
+		result = append(result, Failure{Kind: kv[0], Probability: probability})
+	}
+	return result, nil
+}
+
+// Transport injects failures randomly into the requests of the wrapped transport
+type Transport struct {
+	Next     http.RoundTripper
+	Failures []Failure
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	r := rand.Float64()
+	for _, failure := range t.Failures {
+		if r < failure.Probability {
+			return failures[failure.Kind](req)
+		}
+		r -= failure.Probability
+	}
+	return t.Next.RoundTrip(req)
+}
+
+func response(req *http.Request, statusCode int, body string) *http.Response {
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{`text/xml; charset="utf-8"`}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		Request:    req,
+	}
+}
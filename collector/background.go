@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshot holds the metrics of the last background collection
+type snapshot struct {
+	mutex   sync.RWMutex
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// RunBackground collects the metrics every collect interval until ctx is done.
+// Collect then serves the last snapshot instead of querying the box on every scrape.
+func (collector *Collector) RunBackground(ctx context.Context) {
+
+	if collector.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(collector.interval)
+	defer ticker.Stop()
+
+	for {
+		collector.refreshSnapshot(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (collector *Collector) refreshSnapshot(ctx context.Context) {
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	metrics := []prometheus.Metric{}
+
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+
+	collector.collectMetrics(ctx, ch)
+	close(ch)
+	<-done
+
+	collector.snapshot.mutex.Lock()
+	defer collector.snapshot.mutex.Unlock()
+	collector.snapshot.metrics = metrics
+	collector.snapshot.time = time.Now()
+}
+
+func (collector *Collector) collectSnapshot(ch chan<- prometheus.Metric) {
+
+	collector.snapshot.mutex.RLock()
+	defer collector.snapshot.mutex.RUnlock()
+
+	if collector.snapshot.time.IsZero() {
+		return
+	}
+	for _, m := range collector.snapshot.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(collector.descs.lastCollection, prometheus.GaugeValue, float64(collector.snapshot.time.UnixNano())/1e9)
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// resultCache remembers when the results of the metrics with cache TTL were collected, metrics
+// within their TTL keep the results of the last collection
+type resultCache struct {
+	collected map[*metric.Metric]time.Time
+	// cached are the metrics served from the cache in the current round
+	cached map[*metric.Metric]bool
+}
+
+// due returns the metrics to collect in this round and marks the others as cached
+func (c *resultCache) due(metrics []*metric.Metric, now time.Time) []*metric.Metric {
+
+	c.cached = make(map[*metric.Metric]bool)
+	due := make([]*metric.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if collected, ok := c.collected[m]; ok && now.Sub(collected) < m.CacheDuration {
+			c.cached[m] = true
+			continue
+		}
+		due = append(due, m)
+	}
+	return due
+}
+
+func (c *resultCache) isCached(m *metric.Metric) bool {
+	return c.cached[m]
+}
+
+// refreshed records a successful collection of the metric
+func (c *resultCache) refreshed(m *metric.Metric, now time.Time) {
+
+	if m.CacheDuration <= 0 {
+		return
+	}
+	if c.collected == nil {
+		c.collected = make(map[*metric.Metric]time.Time)
+	}
+	c.collected[m] = now
+}
+
+// collect exports the age of the served results of the metrics with cache TTL, the oldest result
+// if several definitions share a metric name
+func (c *resultCache) collect(ch chan<- prometheus.Metric, descs *descs, metrics []*metric.Metric, now time.Time) {
+
+	ages := map[string]float64{}
+	for _, m := range metrics {
+		if collected, ok := c.collected[m]; ok {
+			ages[m.PromDesc.FqName] = math.Max(ages[m.PromDesc.FqName], now.Sub(collected).Seconds())
+		}
+	}
+	for name, age := range ages {
+		ch <- prometheus.MustNewConstMetric(descs.resultAge, prometheus.GaugeValue, age, name)
+	}
+}
@@ -1,65 +1,225 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
 
+	"github.com/aexel90/fritzbox_exporter/expr"
 	"github.com/aexel90/fritzbox_exporter/lua"
 	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/timing"
 	"github.com/aexel90/fritzbox_exporter/upnp"
 )
 
+var (
+	collectionDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fritzbox_exporter_collection_degraded",
+		Help: "1 if high cost metrics were skipped during the last collection, since the gateway was under load.",
+	}, []string{"gateway"})
+
+	stageDuration = newStageDuration(false)
+
+	outOfBoundsSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fritzbox_exporter_out_of_bounds_samples_total",
+		Help: "Samples dropped since their value was outside the min/max bounds of the metric.",
+	}, []string{"gateway", "metric"})
+
+	precisionLossSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fritzbox_exporter_precision_loss_samples_total",
+		Help: "Samples beyond 2^53, which float64 can't represent exactly (see splitWords).",
+	}, []string{"gateway", "metric"})
+
+	requestDuration = newRequestDuration(false)
+
+	requestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fritzbox_exporter_requests_in_flight",
+		Help: "HTTP requests to the FRITZ!Box currently in flight.",
+	}, []string{"gateway", "collector"})
+)
+
+func init() {
+	prometheus.MustRegister(collectionDegraded)
+	prometheus.MustRegister(stageDuration)
+	prometheus.MustRegister(outOfBoundsSamples)
+	prometheus.MustRegister(precisionLossSamples)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(requestsInFlight)
+}
+
+func newStageDuration(native bool) *prometheus.HistogramVec {
+
+	return prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+		Name: "fritzbox_exporter_stage_duration_seconds",
+		Help: "Time spent per collection in each stage of the pipeline (discovery, auth, fetch, parse, map).",
+	}, native), []string{"gateway", "stage"})
+}
+
+func newRequestDuration(native bool) *prometheus.HistogramVec {
+
+	return prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+		Name: "fritzbox_exporter_request_duration_seconds",
+		Help: "Duration of the HTTP requests to the FRITZ!Box by upnp service or lua page and status code (error if failed).",
+	}, native), []string{"gateway", "collector", "service_or_page", "code"})
+}
+
+// histogramOpts adds the classic buckets and, if native, the sparse buckets of a native histogram
+func histogramOpts(opts prometheus.HistogramOpts, native bool) prometheus.HistogramOpts {
+
+	opts.Buckets = prometheus.DefBuckets
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return opts
+}
+
+// EnableNativeHistograms replaces the duration histograms by histograms exposing native (sparse) buckets
+// in addition to the classic buckets. It has to be called before the first collection.
+func EnableNativeHistograms() {
+
+	prometheus.Unregister(stageDuration)
+	stageDuration = newStageDuration(true)
+	prometheus.MustRegister(stageDuration)
+
+	prometheus.Unregister(requestDuration)
+	requestDuration = newRequestDuration(true)
+	prometheus.MustRegister(requestDuration)
+}
+
 // Collector instance
 type Collector struct {
 	metrics           []*metric.Metric
 	labelValueRenames []*metric.LabelRename
-	exporter          interface{}
+	exporter          Exporter
+	exporterType      string
 	gateway           string
+	mutex             sync.Mutex
+	utilization       *wanUtilization
+	hosts             *hostInventory
+	info              *deviceInfo
+	logins            *loginEvents
+	externalIP        *externalIP
+	reconnects        *wanReconnects
+	portMappings      *portMappings
+	wlanClients       *wlanClients
+	firmware          *firmwareUpdate
+	thermostats       *thermostats
+	serviceInventory  bool
+	unsupported       []string
+	interval          time.Duration
+	snapshot          snapshot
+	stages            *timing.Stages
+	rates             map[string]rateSample
+	descs             *descs
+	resultErrors      map[*metric.Metric]error
+	cache             resultCache
+	requests          *requestCounter
+	roundLog          bool
+	deviceUp          bool
+	afterCollect      func([]Sample)
+	counters          *CounterStore
+	filter            *seriesFilter
 }
 
 // NewUpnpCollector initialization
-func NewUpnpCollector(metricsFile *metric.MetricsFile, URL string, username string, password string, gateway string) (*Collector, error) {
+func NewUpnpCollector(metricsFile *metric.MetricsFile, URL string, username string, password string, gateway string, opts ...Option) (*Collector, error) {
 
-	initDescAndType(metricsFile.Metrics)
-	err := initLabelRenames(metricsFile.LabelRenames)
-	if err != nil {
-		return nil, err
-	}
+	o := newOptions(opts)
 
+	stages := &timing.Stages{}
+	client, requests := countRequests(o.httpClient, gateway, "upnp")
 	upnpExporter := upnp.Exporter{
-		BaseURL:  URL,
-		Username: username,
-		Password: password,
+		BaseURL:          URL,
+		Username:         username,
+		Password:         password,
+		LatencyThreshold: o.latencyThreshold,
+		Client:           client,
+		Stages:           stages,
+
+		DiscoveryInterval:  o.discoveryInterval,
+		DiscoveryCacheFile: o.discoveryCacheFile,
+	}
+	collector, err := newCollector(metricsFile, &upnpExporter, "upnp", gateway, o, stages, requests)
+	if err != nil {
+		return nil, err
 	}
 	err = upnpExporter.LoadServices()
 	if err != nil {
 		return nil, err
 	}
-
-	return &Collector{metricsFile.Metrics, metricsFile.LabelRenames, &upnpExporter, gateway}, nil
+	collector.metrics, collector.unsupported = disableUnsupported(collector.metrics, upnpExporter.Services)
+	collector.info = &deviceInfo{}
+	err = collector.info.update(context.Background(), &upnpExporter)
+	if err != nil {
+		fmt.Println("Error: reading device info: ", err)
+	}
+	if o.wanUtilization {
+		collector.utilization = &wanUtilization{}
+	}
+	if o.hosts {
+		collector.hosts = newHostInventory()
+	}
+	if o.loginEvents {
+		collector.logins = &loginEvents{}
+	}
+	if o.externalIP {
+		collector.externalIP = newExternalIP()
+	}
+	if o.wanReconnects {
+		collector.reconnects = &wanReconnects{}
+	}
+	if o.portMappings {
+		collector.portMappings = &portMappings{entries: o.portMappingItems}
+	}
+	if o.firmwareUpdate {
+		collector.firmware = &firmwareUpdate{info: collector.info}
+	}
+	if o.wlanClients {
+		collector.wlanClients = &wlanClients{resolveNames: o.wlanClientNames}
+	}
+	collector.serviceInventory = o.serviceInventory
+	return collector, nil
 }
 
 // NewLuaCollector initialization
-func NewLuaCollector(metricsFile *metric.MetricsFile, URL string, username string, password string, gateway string) (*Collector, error) {
+func NewLuaCollector(metricsFile *metric.MetricsFile, URL string, username string, password string, gateway string, opts ...Option) (*Collector, error) {
 
-	initDescAndType(metricsFile.Metrics)
-	err := initLabelRenames(metricsFile.LabelRenames)
-	if err != nil {
-		return nil, err
-	}
+	o := newOptions(opts)
 
+	stages := &timing.Stages{}
+	client, requests := countRequests(o.httpClient, gateway, "lua")
 	luaExporter := lua.Exporter{
 		BaseURL:  URL,
 		Username: username,
 		Password: password,
+		Client:   client,
+		Stages:   stages,
 	}
 
-	return &Collector{metricsFile.Metrics, metricsFile.LabelRenames, &luaExporter, gateway}, nil
+	collector, err := newCollector(metricsFile, &luaExporter, "lua", gateway, o, stages, requests)
+	if err != nil {
+		return nil, err
+	}
+	if o.thermostats {
+		collector.thermostats = &thermostats{}
+	}
+	return collector, nil
 }
 
 // Describe for prometheus
@@ -68,14 +228,128 @@ func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range collector.metrics {
 		ch <- metric.Desc
 	}
+	if collector.info != nil {
+		ch <- collector.descs.deviceInfo
+	}
+	if collector.utilization != nil {
+		ch <- collector.descs.wanUtilization
+	}
+	if collector.hosts != nil {
+		ch <- collector.descs.hostActive
+		ch <- collector.descs.hostsActive
+	}
+	if collector.logins != nil {
+		ch <- collector.descs.loginFailures
+		ch <- collector.descs.sessions
+	}
+	if collector.externalIP != nil {
+		ch <- collector.descs.externalIPInfo
+		ch <- collector.descs.externalIPChanges
+	}
+	if collector.reconnects != nil {
+		ch <- collector.descs.wanUptime
+		ch <- collector.descs.wanReconnects
+	}
+	if collector.portMappings != nil {
+		ch <- collector.descs.portMappings
+		if collector.portMappings.entries {
+			ch <- collector.descs.portMapping
+		}
+	}
+	if collector.firmware != nil {
+		ch <- collector.descs.firmwareUpdate
+	}
+	if collector.wlanClients != nil {
+		ch <- collector.descs.wlanClientAssociated
+		ch <- collector.descs.wlanClientSpeed
+		ch <- collector.descs.wlanClientSignal
+	}
+	if collector.serviceInventory {
+		ch <- collector.descs.serviceInfo
+	}
+	if collector.thermostats != nil {
+		ch <- collector.descs.thermostatTemperature
+		ch <- collector.descs.thermostatTarget
+		ch <- collector.descs.thermostatValve
+		ch <- collector.descs.thermostatWindowOpen
+		ch <- collector.descs.thermostatHoliday
+		ch <- collector.descs.thermostatBatteryLow
+		ch <- collector.descs.thermostatBattery
+	}
+	if collector.interval > 0 {
+		ch <- collector.descs.lastCollection
+	}
+	for _, m := range collector.metrics {
+		if m.CacheDuration > 0 {
+			ch <- collector.descs.resultAge
+			break
+		}
+	}
+	if collector.deviceUp {
+		ch <- collector.descs.deviceUp
+	}
+	ch <- collector.descs.scrapeSuccess
+	if len(collector.unsupported) > 0 {
+		ch <- collector.descs.unsupported
+	}
+	if _, ok := collector.exporter.(*lua.Exporter); ok {
+		ch <- collector.descs.luaPageUp
+		ch <- collector.descs.luaPageLastSuccess
+	}
 }
 
 // Collect for prometheus
 func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
 
-	err := collector.collect()
+	if collector.interval > 0 {
+		collector.collectSnapshot(ch)
+		return
+	}
+	collector.collectMetrics(context.Background(), ch)
+}
+
+func (collector *Collector) collectMetrics(ctx context.Context, ch chan<- prometheus.Metric) {
+	collector.collectGroups(ctx, ch, nil)
+}
+
+// collectGroups collects the metrics of the groups, all metrics if groups is nil. The built-in metrics
+// belong to the group of the metric definitions they complement.
+func (collector *Collector) collectGroups(ctx context.Context, ch chan<- prometheus.Metric, groups map[string]bool) {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	if collector.filter != nil {
+		filtered, finish := collector.filter.wrap(ch)
+		defer finish()
+		ch = filtered
+	}
+
+	selected := func(group string) bool {
+		return groups == nil || groups[group]
+	}
+	if groups != nil {
+		metrics := collector.metrics
+		collector.metrics = metricsOfGroups(metrics, groups)
+		defer func() {
+			collector.metrics = metrics
+		}()
+	}
+
+	start := time.Now()
+	errs := 0
+	err := collector.collect(ctx)
 	if err != nil {
 		fmt.Println("Error: ", err)
+		errs++
+	}
+	if collector.deviceUp {
+		ch <- prometheus.MustNewConstMetric(collector.descs.deviceUp, prometheus.GaugeValue, boolToFloat(err == nil))
+	}
+	ch <- prometheus.MustNewConstMetric(collector.descs.scrapeSuccess, prometheus.GaugeValue, boolToFloat(err == nil))
+	collectUnsupported(ch, collector.descs, collector.unsupported)
+	if luaExporter, ok := collector.exporter.(*lua.Exporter); ok {
+		collectPageStatus(ch, collector.descs, luaExporter)
 	}
 
 	collector.addGatewayGeneric()
@@ -84,71 +358,251 @@ func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
 	if err != nil {
 		fmt.Println("Error: ", err)
 	}
+	collector.observeStages()
+	defer collector.logRound(start, errs)
+	collector.runAfterCollect()
 
 	for _, m := range collector.metrics {
 		for _, promResult := range m.PromResult {
+			if !promResult.Created.IsZero() {
+				ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(promResult.PromDesc, promResult.PromValueType, promResult.Value, promResult.Created, promResult.LabelValues...)
+				continue
+			}
 			ch <- prometheus.MustNewConstMetric(promResult.PromDesc, promResult.PromValueType, promResult.Value, promResult.LabelValues...)
 		}
 	}
+	collector.cache.collect(ch, collector.descs, collector.metrics, time.Now())
+
+	if collector.info != nil && selected("device") {
+		err = collector.info.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.info.collect(ch, collector.descs)
+	}
+
+	if collector.firmware != nil && selected("device") {
+		err = collector.firmware.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.firmware.collect(ch, collector.descs)
+	}
+
+	if collector.utilization != nil && selected("wan") {
+		err = collector.utilization.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.utilization.collect(ch, collector.descs)
+	}
+
+	if collector.hosts != nil && selected("hosts") {
+		err = collector.hosts.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.hosts.collect(ch, collector.descs)
+	}
+
+	if collector.logins != nil && selected("device") {
+		err = collector.logins.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.logins.collect(ch, collector.descs)
+	}
+
+	if collector.externalIP != nil && selected("wan") {
+		err = collector.externalIP.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.externalIP.collect(ch, collector.descs)
+	}
+
+	if collector.reconnects != nil && selected("wan") {
+		err = collector.reconnects.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.reconnects.collect(ch, collector.descs)
+	}
+
+	if collector.portMappings != nil && selected("wan") {
+		err = collector.portMappings.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.portMappings.collect(ch, collector.descs)
+	}
+
+	if collector.wlanClients != nil && selected("wlan") {
+		err = collector.wlanClients.update(ctx, collector.exporter.(*upnp.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.wlanClients.collect(ch, collector.descs)
+	}
+
+	if collector.serviceInventory && selected("device") {
+		collectServiceInventory(ch, collector.descs, collector.exporter.(*upnp.Exporter))
+	}
+
+	if collector.thermostats != nil && selected("smarthome") {
+		err = collector.thermostats.update(ctx, collector.exporter.(*lua.Exporter))
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+		collector.thermostats.collect(ch, collector.descs)
+	}
+}
+
+// collectServiceInventory exports an info series per discovered upnp service
+func collectServiceInventory(ch chan<- prometheus.Metric, descs *descs, upnpExporter *upnp.Exporter) {
+
+	for _, service := range upnpExporter.Services {
+		ch <- prometheus.MustNewConstMetric(descs.serviceInfo, prometheus.GaugeValue, 1, service.ServiceType, service.ServiceID)
+	}
 }
 
 //Test collector metrics
-func (collector *Collector) Test(resultFile string) {
+func (collector *Collector) Test(resultFile string, out io.Writer) *TestReport {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
 
-	err := collector.collect()
+	report := &TestReport{Gateway: collector.gateway, Exporter: collector.ExporterType()}
+
+	err := collector.collect(context.Background())
 	if err != nil {
-		fmt.Println("Error: ", err)
+		fmt.Fprintln(out, "Error: ", err)
+		report.Error = err.Error()
 	}
 
 	collector.addGatewayGeneric()
 
 	err = collector.getResult()
 	if err != nil {
-		fmt.Println("Error: ", err)
+		fmt.Fprintln(out, "Error: ", err)
+		report.ResultError = err.Error()
+	}
+
+	report.Stages = make(map[string]float64)
+	for stage, d := range collector.observeStages() {
+		report.Stages[stage] = d.Seconds()
 	}
 
-	collector.printResult()
+	collector.printResult(out)
+	fmt.Fprintf(out, "Stages: %s\n", formatStages(report.Stages))
+
+	now := time.Now()
+	for _, m := range collector.metrics {
+		metricReport := MetricReport{Name: m.PromDesc.FqName, Results: len(m.PromResult), Values: collector.samples(m, now)}
+		if err, ok := collector.resultErrors[m]; ok {
+			metricReport.Error = err.Error()
+		}
+		report.Metrics = append(report.Metrics, metricReport)
+	}
 
 	if resultFile != "" {
 
 		jsonString, err := json.MarshalIndent(collector.metrics, "", "\t")
 		if err != nil {
-			fmt.Println("Error: ", err)
+			fmt.Fprintln(out, "Error: ", err)
 		}
 
 		err = ioutil.WriteFile(resultFile, jsonString, 0644)
 		if err != nil {
-			fmt.Printf("Failed writing JSON file '%s': %s\n", resultFile, err.Error())
+			fmt.Fprintf(out, "Failed writing JSON file '%s': %s\n", resultFile, err.Error())
 		}
 	}
+	return report
 }
 
-func (collector *Collector) printResult() {
+func (collector *Collector) printResult(out io.Writer) {
 
 	for _, m := range collector.metrics {
-		fmt.Printf("Metric: %v\n", m.PromDesc.FqName)
-		fmt.Printf(" - Exporter Result: %v\n", m.MetricResult)
+		fmt.Fprintf(out, "Metric: %v\n", m.PromDesc.FqName)
+		fmt.Fprintf(out, " - Exporter Result: %v\n", m.MetricResult)
 
 		for _, promResult := range m.PromResult {
 
-			fmt.Printf("   - prom desc: %v\n", promResult.PromDesc)
-			fmt.Printf("     - prom metric type: %v\n", promResult.PromValueType)
-			fmt.Printf("     - prom metric value: %v\n", uint64(promResult.Value))
-			fmt.Printf("     - prom label values: %v\n", promResult.LabelValues)
+			fmt.Fprintf(out, "   - prom desc: %v\n", promResult.PromDesc)
+			fmt.Fprintf(out, "     - prom metric type: %v\n", promResult.PromValueType)
+			fmt.Fprintf(out, "     - prom metric value: %s\n", strconv.FormatFloat(promResult.Value, 'f', -1, 64))
+			if math.Abs(promResult.Value) > maxExactFloat {
+				fmt.Fprintf(out, "     - warning: value beyond 2^53 is not exact, consider splitWords\n")
+			}
+			fmt.Fprintf(out, "     - prom label values: %v\n", promResult.LabelValues)
 		}
 	}
 }
 
-func (collector *Collector) collect() error {
+// observeStages observes the stage durations of the last collection and returns them
+func (collector *Collector) observeStages() map[string]time.Duration {
+
+	durations := collector.stages.Take()
+	for stage, d := range durations {
+		stageDuration.WithLabelValues(collector.gateway, stage).Observe(d.Seconds())
+	}
+	return durations
+}
+
+// ExporterType returns the type of the exporter (lua, upnp or the type of a custom exporter)
+func (collector *Collector) ExporterType() string {
+	return collector.exporterType
+}
+
+// Gateway returns the gateway label of the collector
+func (collector *Collector) Gateway() string {
+	return collector.gateway
+}
+
+// MetricCount returns the number of metric definitions of the collector
+func (collector *Collector) MetricCount() int {
+	return len(collector.metrics)
+}
+
+// Login to the gateway, if the exporter requires a session
+func (collector *Collector) Login(ctx context.Context) error {
+
+	if sessionExporter, ok := collector.exporter.(SessionExporter); ok {
+		return sessionExporter.Login(ctx)
+	}
+	return nil
+}
+
+// Invalidate drops the session and reloads the service descriptions of the gateway
+func (collector *Collector) Invalidate() error {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	if collector.info != nil {
+		collector.info.updated = time.Time{}
+	}
+	if collector.firmware != nil {
+		collector.firmware.updated = time.Time{}
+	}
+	if invalidatingExporter, ok := collector.exporter.(InvalidatingExporter); ok {
+		return invalidatingExporter.Invalidate()
+	}
+	return nil
+}
+
+func (collector *Collector) collect(ctx context.Context) error {
+
+	metrics := collector.cache.due(collector.metrics, time.Now())
 
 	var err error
-	switch collector.exporter.(type) {
-	case *lua.Exporter:
-		luaExporter := collector.exporter.(*lua.Exporter)
-		err = luaExporter.Collect(collector.metrics)
-	case *upnp.Exporter:
-		upnpExporter := collector.exporter.(*upnp.Exporter)
-		err = upnpExporter.Collect(collector.metrics)
+	if contextExporter, ok := collector.exporter.(ContextExporter); ok {
+		err = contextExporter.CollectWithContext(ctx, metrics)
+	} else {
+		err = collector.exporter.Collect(metrics)
+	}
+	if upnpExporter, ok := collector.exporter.(*upnp.Exporter); ok && upnpExporter.LatencyThreshold > 0 {
+		collectionDegraded.WithLabelValues(collector.gateway).Set(boolToFloat(upnpExporter.Degraded))
 	}
 	if err != nil {
 		return err
@@ -156,26 +610,235 @@ func (collector *Collector) collect() error {
 	return nil
 }
 
-func (collector *Collector) getResult() (err error) {
+func (collector *Collector) getResult() error {
+
+	now := time.Now()
+	defer collector.stages.Since(timing.Map, now)
 
+	// a failing metric must not affect the others
+	var firstErr error
+	collector.resultErrors = make(map[*metric.Metric]error)
 	for _, m := range collector.metrics {
-		m.PromResult = nil
-		for _, metricResult := range m.MetricResult {
+		if collector.cache.isCached(m) {
+			continue
+		}
+		// failed requests of the exporter keep the partial results, e.g. the indices of a list that succeeded
+		if m.Err != nil && len(m.MetricResult) == 0 {
+			m.PromResult = nil
+			collector.resultErrors[m] = m.Err
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", m.PromDesc.FqName, m.Err)
+			}
+			continue
+		}
+		err := collector.getMetricResult(m, now)
+		if err != nil {
+			m.PromResult = nil
+			collector.resultErrors[m] = err
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", m.PromDesc.FqName, err)
+			}
+			continue
+		}
+		if m.Err != nil {
+			collector.resultErrors[m] = m.Err
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", m.PromDesc.FqName, m.Err)
+			}
+			continue
+		}
+		collector.cache.refreshed(m, now)
+	}
+	err := collector.counters.Save()
+	if err != nil {
+		fmt.Println("Error: writing counter state: ", err)
+	}
+	return firstErr
+}
+
+func (collector *Collector) getMetricResult(m *metric.Metric, now time.Time) error {
 
-			labelValues, err := getLabelValues(m.PromDesc.VarLabels, metricResult, collector.gateway, collector.labelValueRenames)
+	m.PromResult = nil
+	for _, metricResult := range dedupResults(m.MetricResult, m.DedupKey) {
+
+		labelValues, err := getLabelValues(m, metricResult, collector.labelValueRenames)
+		var missing *MissingLabelError
+		if errors.As(err, &missing) && m.MissingLabel == metric.MissingLabelSkip {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if m.StateSet {
+			m.PromResult = append(m.PromResult, getStateSetResults(m, metricResult, labelValues)...)
+			continue
+		}
+		if m.SplitWords {
+			results, err := getSplitWordsResults(m, metricResult, labelValues)
 			if err != nil {
 				return err
 			}
-			resultValue, err := getResultValue(m.ResultKey, metricResult, m.OkValue)
+			m.PromResult = append(m.PromResult, results...)
+			continue
+		}
+
+		var resultValue float64
+		if m.Aggregate == "count" {
+			resultValue = 1
+		} else if m.TransformExpr != nil {
+			resultValue, err = getTransformedValue(m, metricResult)
 			if err != nil {
 				return err
 			}
+		} else {
+			resultValue, err = getResultValue(m, metricResult)
+			if err != nil {
+				return err
+			}
+		}
 
-			result := metric.PrometheusResult{PromDesc: m.Desc, PromValueType: m.Type, Value: resultValue, LabelValues: labelValues}
-			m.PromResult = append(m.PromResult, &result)
+		if m.Aggregate != "" {
+			if existing := findPromResult(m.PromResult, labelValues); existing != nil {
+				existing.Value += resultValue
+				continue
+			}
 		}
+
+		result := metric.PrometheusResult{PromDesc: m.Desc, PromValueType: m.Type, Value: resultValue, LabelValues: labelValues}
+		m.PromResult = append(m.PromResult, &result)
+	}
+
+	if m.Derive == "rate" {
+		m.PromResult = collector.deriveRates(m, now)
+	}
+	if m.Monotonic {
+		collector.adjustMonotonic(m)
+	}
+	if m.Extend32BitCounter {
+		collector.extendCounters(m)
+	}
+	for _, promResult := range m.PromResult {
+		if math.Abs(promResult.Value) > maxExactFloat {
+			precisionLossSamples.WithLabelValues(collector.gateway, m.PromDesc.FqName).Inc()
+		}
+		if m.Precision != nil {
+			scale := math.Pow(10, float64(*m.Precision))
+			promResult.Value = math.Round(promResult.Value*scale) / scale
+		}
+	}
+	if m.Min != nil || m.Max != nil {
+		m.PromResult = collector.dropOutOfBounds(m)
+	}
+	return nil
+}
+
+// Units returns the units of the metrics by metric name, for the names ending with their unit
+// as required by OpenMetrics
+func (collector *Collector) Units() map[string]string {
+
+	units := map[string]string{}
+	for _, m := range collector.metrics {
+		if m.Unit != "" && strings.HasSuffix(strings.TrimSuffix(m.PromDesc.FqName, "_total"), "_"+m.Unit) {
+			units[m.PromDesc.FqName] = m.Unit
+		}
+	}
+	return units
+}
+
+// collectPageStatus exports the status of each lua page requested so far
+func collectPageStatus(ch chan<- prometheus.Metric, descs *descs, luaExporter *lua.Exporter) {
+
+	for page, status := range luaExporter.Pages {
+		ch <- prometheus.MustNewConstMetric(descs.luaPageUp, prometheus.GaugeValue, boolToFloat(status.Up), page)
+		if !status.LastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(descs.luaPageLastSuccess, prometheus.GaugeValue, float64(status.LastSuccess.UnixNano())/1e9, page)
+		}
+	}
+}
+
+// maxExactFloat is 2^53, the largest integer up to which float64 represents every integer exactly
+const maxExactFloat = 1 << 53
+
+// getSplitWordsResults splits an integer result into its high and low 32 bit words
+func getSplitWordsResults(m *metric.Metric, result map[string]interface{}, labelValues []string) ([]*metric.PrometheusResult, error) {
+
+	var value uint64
+	switch tval := result[resultKey(m)].(type) {
+	case uint64:
+		value = tval
+	case int64:
+		if tval < 0 {
+			return nil, fmt.Errorf("[getSplitWordsResults] %s is negative: %d", resultKey(m), tval)
+		}
+		value = uint64(tval)
+	case string:
+		parsed, err := strconv.ParseUint(tval, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("[getSplitWordsResults] %s is no integer: %q", resultKey(m), tval)
+		}
+		value = parsed
+	default:
+		return nil, fmt.Errorf("[getSplitWordsResults] %s is no integer: %T", resultKey(m), tval)
+	}
+
+	high := append(append([]string{}, labelValues...), "high")
+	low := append(append([]string{}, labelValues...), "low")
+	return []*metric.PrometheusResult{
+		{PromDesc: m.Desc, PromValueType: m.Type, Value: float64(value >> 32), LabelValues: high},
+		{PromDesc: m.Desc, PromValueType: m.Type, Value: float64(value & 0xffffffff), LabelValues: low},
+	}, nil
+}
+
+// dropOutOfBounds drops the results outside the min/max bounds of the metric and counts them
+func (collector *Collector) dropOutOfBounds(m *metric.Metric) []*metric.PrometheusResult {
+
+	results := []*metric.PrometheusResult{}
+	for _, promResult := range m.PromResult {
+		if (m.Min != nil && promResult.Value < *m.Min) || (m.Max != nil && promResult.Value > *m.Max) {
+			outOfBoundsSamples.WithLabelValues(collector.gateway, m.PromDesc.FqName).Inc()
+			continue
+		}
+		results = append(results, promResult)
+	}
+	return results
+}
+
+// dedupResults drops rows with a value of the key already seen in a previous row,
+// e.g. hosts listed twice since the host table changed during the iteration
+func dedupResults(results []map[string]interface{}, key string) []map[string]interface{} {
+
+	if key == "" {
+		return results
+	}
+
+	seen := make(map[string]bool)
+	unique := []map[string]interface{}{}
+	for _, result := range results {
+		value := fmt.Sprintf("%v", result[key])
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		unique = append(unique, result)
+	}
+	return unique
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
+}
+
+func findPromResult(promResults []*metric.PrometheusResult, labelValues []string) *metric.PrometheusResult {
 
+	for _, promResult := range promResults {
+		if strings.Join(promResult.LabelValues, "\xff") == strings.Join(labelValues, "\xff") {
+			return promResult
+		}
+	}
 	return nil
 }
 
@@ -183,25 +846,125 @@ func (collector *Collector) addGatewayGeneric() {
 
 	for _, metric := range collector.metrics {
 		for _, metricResult := range metric.MetricResult {
-			metricResult["gateway"] = collector.gateway
+			// failed actions leave an empty result
+			if metricResult != nil {
+				metricResult["gateway"] = collector.gateway
+			}
 		}
 	}
 }
 
-func initDescAndType(metrics []*metric.Metric) {
+// copyMetrics copies the metric definitions, so a metrics file can be used by collectors of several gateways
+func copyMetrics(metrics []*metric.Metric) []*metric.Metric {
+
+	copies := make([]*metric.Metric, len(metrics))
+	for i, m := range metrics {
+		c := *m
+		copies[i] = &c
+	}
+	return copies
+}
+
+// initDescAndType creates the descriptors with the gateway as constant label, so collectors
+// of several gateways can be registered together. The extra labels of the metrics file become
+// fixed labels of every metric, fixed labels of the metric itself take precedence.
+func initDescAndType(metrics []*metric.Metric, namingConventions bool, gateway string, extraLabels map[string]string) {
 
 	for _, metric := range metrics {
 
-		labels := make([]string, len(metric.PromDesc.VarLabels))
-		for i, l := range metric.PromDesc.VarLabels {
-			labels[i] = strings.ToLower(l)
+		if namingConventions {
+			metric.PromDesc.FqName = metric.ConventionalName()
+		}
+
+		if len(extraLabels) > 0 {
+			fixedLabels := make(map[string]string, len(extraLabels)+len(metric.PromDesc.FixedLabels))
+			for name, value := range extraLabels {
+				fixedLabels[name] = value
+			}
+			for name, value := range metric.PromDesc.FixedLabels {
+				fixedLabels[name] = value
+			}
+			metric.PromDesc.FixedLabels = fixedLabels
+		}
+
+		constLabels := prometheus.Labels{"gateway": gateway}
+		for name, value := range metric.PromDesc.FixedLabels {
+			constLabels[name] = value
 		}
 
-		metric.Desc = prometheus.NewDesc(metric.PromDesc.FqName, metric.PromDesc.Help, labels, metric.PromDesc.FixedLabels)
+		metric.Desc = prometheus.NewDesc(metric.PromDesc.FqName, metric.PromDesc.Help, metric.VarLabelNames(), constLabels)
 		metric.Type = getValueType(metric.PromType)
 	}
 }
 
+func initTransforms(metrics []*metric.Metric) error {
+
+	for _, m := range metrics {
+		if m.Transform == "" {
+			continue
+		}
+		transform, err := expr.Parse(m.Transform)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.PromDesc.FqName, err)
+		}
+		m.TransformExpr = transform
+	}
+	return nil
+}
+
+func initCacheTTLs(metrics []*metric.Metric) error {
+
+	for _, m := range metrics {
+		if m.CacheTTL == "" {
+			continue
+		}
+		ttl, err := time.ParseDuration(m.CacheTTL)
+		if err != nil {
+			return fmt.Errorf("%s: invalid cacheTTL: %v", m.PromDesc.FqName, err)
+		}
+		m.CacheDuration = ttl
+	}
+	return nil
+}
+
+func initLabelTemplates(metrics []*metric.Metric) error {
+
+	for _, m := range metrics {
+		for label, source := range m.Labels {
+			if !metric.IsLabelTemplate(source) {
+				continue
+			}
+			tmpl, err := template.New(label).Parse(source)
+			if err != nil {
+				return fmt.Errorf("%s: %v", m.PromDesc.FqName, err)
+			}
+			if m.LabelTemplates == nil {
+				m.LabelTemplates = map[string]*template.Template{}
+			}
+			m.LabelTemplates[label] = tmpl
+		}
+	}
+	return nil
+}
+
+// getTransformedValue evaluates the transform expression of the metric, with "value" referring to the result key
+func getTransformedValue(m *metric.Metric, result map[string]interface{}) (float64, error) {
+
+	key := resultKey(m)
+
+	value, err := m.TransformExpr.Eval(func(name string) (interface{}, bool) {
+		if name == "value" {
+			name = key
+		}
+		v, ok := result[name]
+		return v, ok
+	})
+	if err != nil {
+		return 0, fmt.Errorf("[getTransformedValue] %s in %v: %v", m.Transform, result, err)
+	}
+	return value, nil
+}
+
 func initLabelRenames(labelRenames []*metric.LabelRename) error {
 
 	for _, rename := range labelRenames {
@@ -215,11 +978,9 @@ func initLabelRenames(labelRenames []*metric.LabelRename) error {
 	return nil
 }
 
-func getResultValue(key string, result map[string]interface{}, okValue string) (float64, error) {
+func getResultValue(m *metric.Metric, result map[string]interface{}) (float64, error) {
 
-	if key == "" {
-		key = "result"
-	}
+	key := resultKey(m)
 
 	value := result[key]
 	var floatValue float64
@@ -229,6 +990,8 @@ func getResultValue(key string, result map[string]interface{}, okValue string) (
 		floatValue = tval
 	case int:
 		floatValue = float64(tval)
+	case int64:
+		floatValue = float64(tval)
 	case uint64:
 		floatValue = float64(tval)
 	case bool:
@@ -238,7 +1001,11 @@ func getResultValue(key string, result map[string]interface{}, okValue string) (
 			floatValue = 0
 		}
 	case string:
-		if tval == okValue {
+		if mapped, ok := m.ValueMap[tval]; ok {
+			floatValue = mapped
+		} else if m.ListSeparator != "" {
+			floatValue = float64(len(splitList(tval, m.ListSeparator)))
+		} else if tval == m.OkValue {
 			floatValue = 1
 		} else {
 			floatValue = 0
@@ -250,17 +1017,84 @@ func getResultValue(key string, result map[string]interface{}, okValue string) (
 	return floatValue, nil
 }
 
-func getLabelValues(labelNames []string, result map[string]interface{}, gateway string, labelRenames []*metric.LabelRename) ([]string, error) {
+func resultKey(m *metric.Metric) string {
+
+	if m.ResultKey == "" {
+		return "result"
+	}
+	return m.ResultKey
+}
+
+// getStateSetResults creates one result per state of the value map, with value 1 for the current state
+func getStateSetResults(m *metric.Metric, result map[string]interface{}, labelValues []string) []*metric.PrometheusResult {
+
+	current := fmt.Sprintf("%v", result[resultKey(m)])
+
+	states := []string{}
+	for state := range m.ValueMap {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	var promResults []*metric.PrometheusResult
+	for _, state := range states {
+		stateLabelValues := append(append([]string{}, labelValues...), strings.ToLower(state))
+		promResults = append(promResults, &metric.PrometheusResult{PromDesc: m.Desc, PromValueType: m.Type, Value: boolToFloat(state == current), LabelValues: stateLabelValues})
+	}
+	return promResults
+}
+
+func splitList(list string, separator string) []string {
+
+	var elements []string
+	for _, element := range strings.Split(list, separator) {
+		if strings.TrimSpace(element) != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// getLabelValues reads the values of the var labels from the result, by default from the result of the same name,
+// otherwise from the gjson path or template given in the labels of the metric
+func getLabelValues(m *metric.Metric, result map[string]interface{}, labelRenames []*metric.LabelRename) ([]string, error) {
 
+	var resultJSON []byte
 	labelValues := []string{}
-	for _, labelname := range labelNames {
+	for _, labelname := range m.PromDesc.VarLabels {
 
-		var labelValue string
+		// the gateway is a constant label of the descriptor
+		if strings.ToLower(labelname) == "gateway" {
+			continue
+		}
 
-		if labelname == "gateway" {
-			labelValue = gateway
+		var labelValue string
+		if tmpl, ok := m.LabelTemplates[labelname]; ok {
+			var buf strings.Builder
+			err := tmpl.Execute(&buf, result)
+			if err != nil {
+				return nil, fmt.Errorf("[getLabelValues] label %s: %v", labelname, err)
+			}
+			labelValue = buf.String()
+		} else if path, ok := m.Labels[labelname]; ok {
+			if resultJSON == nil {
+				var err error
+				resultJSON, err = json.Marshal(result)
+				if err != nil {
+					return nil, fmt.Errorf("[getLabelValues] label %s: %v", labelname, err)
+				}
+			}
+			value := gjson.GetBytes(resultJSON, path)
+			if !value.Exists() && m.MissingLabel != "" && m.MissingLabel != metric.MissingLabelEmpty {
+				return nil, &MissingLabelError{Label: labelname}
+			}
+			labelValue = value.String()
 		} else {
-			labelValue = fmt.Sprintf("%v", result[labelname])
+			value, ok := result[labelname]
+			if (!ok || value == nil) && m.MissingLabel != "" && m.MissingLabel != metric.MissingLabelEmpty {
+				return nil, &MissingLabelError{Label: labelname}
+			}
+			labelValue = labelString(value)
 		}
 
 		renameLabel(&labelValue, labelRenames)
@@ -270,6 +1104,29 @@ func getLabelValues(labelNames []string, result map[string]interface{}, gateway
 	return labelValues, nil
 }
 
+// MissingLabelError is a result without the value of a var label
+type MissingLabelError struct {
+	Label string
+}
+
+func (e *MissingLabelError) Error() string {
+	return fmt.Sprintf("[getLabelValues] no value for label %s", e.Label)
+}
+
+// labelString converts a result of any type to a label value, missing results are empty
+func labelString(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
 func getValueType(vt string) (valueType prometheus.ValueType) {
 
 	var valueTypes = map[string]prometheus.ValueType{
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// rateSample is the last value of a series of a metric with derive "rate"
+type rateSample struct {
+	value float64
+	time  time.Time
+}
+
+// deriveRates replaces the values of a metric with derive "rate" by their increase per second since the
+// last collection. Series seen for the first time or whose value decreased are dropped, unless a ui4 result
+// wrapped at 2^32.
+func (collector *Collector) deriveRates(m *metric.Metric, now time.Time) []*metric.PrometheusResult {
+
+	if collector.rates == nil {
+		collector.rates = make(map[string]rateSample)
+	}
+
+	wraps32 := m.ResultTypes[resultKey(m)] == "ui4"
+	results := []*metric.PrometheusResult{}
+	for _, promResult := range m.PromResult {
+		key := fmt.Sprintf("%p\xff%s", m, strings.Join(promResult.LabelValues, "\xff"))
+		last, ok := collector.rates[key]
+		collector.rates[key] = rateSample{value: promResult.Value, time: now}
+
+		elapsed := now.Sub(last.time).Seconds()
+		if !ok || elapsed <= 0 {
+			continue
+		}
+		delta, ok := counterDelta(last.value, promResult.Value, wraps32)
+		if !ok {
+			continue
+		}
+		promResult.Value = delta / elapsed
+		results = append(results, promResult)
+	}
+	return results
+}
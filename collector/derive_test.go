@@ -0,0 +1,30 @@
+package collector
+
+import "testing"
+
+func TestCounterDelta(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		last, current float64
+		wraps32       bool
+		want          float64
+		wantOK        bool
+	}{
+		{"increase", 100, 250, false, 150, true},
+		{"fractional increase", 0.5, 2.25, false, 1.75, true},
+		{"wrap of ui4", 1<<32 - 100, 50, true, 150, true},
+		{"reset of ui4", 1000, 10, true, 0, false},
+		{"last at 2^32 is no ui4 value", 1 << 32, 10, true, 0, false},
+		{"decrease of other types", 1<<32 - 100, 50, false, 0, false},
+		{"negative gauge", -1, -5, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := counterDelta(tt.last, tt.current, tt.wraps32)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("counterDelta(%v, %v, %v) = %v, %v, want %v, %v", tt.last, tt.current, tt.wraps32, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// descs are the descriptors of the metrics built into the collector. The gateway and the extra labels
+// of the metrics file are constant labels, so the collectors of several gateways can be registered together.
+type descs struct {
+	deviceInfo     *prometheus.Desc
+	wanUtilization *prometheus.Desc
+	hostActive     *prometheus.Desc
+	hostsActive    *prometheus.Desc
+	loginFailures  *prometheus.Desc
+	sessions       *prometheus.Desc
+	lastCollection *prometheus.Desc
+	deviceUp       *prometheus.Desc
+	resultAge      *prometheus.Desc
+	scrapeSuccess  *prometheus.Desc
+	unsupported    *prometheus.Desc
+
+	luaPageUp          *prometheus.Desc
+	luaPageLastSuccess *prometheus.Desc
+
+	externalIPInfo    *prometheus.Desc
+	externalIPChanges *prometheus.Desc
+	serviceInfo       *prometheus.Desc
+	firmwareUpdate    *prometheus.Desc
+	wanUptime         *prometheus.Desc
+	wanReconnects     *prometheus.Desc
+	portMappings      *prometheus.Desc
+	portMapping       *prometheus.Desc
+
+	wlanClientAssociated *prometheus.Desc
+	wlanClientSpeed      *prometheus.Desc
+	wlanClientSignal     *prometheus.Desc
+
+	thermostatTemperature *prometheus.Desc
+	thermostatTarget      *prometheus.Desc
+	thermostatValve       *prometheus.Desc
+	thermostatWindowOpen  *prometheus.Desc
+	thermostatHoliday     *prometheus.Desc
+	thermostatBatteryLow  *prometheus.Desc
+	thermostatBattery     *prometheus.Desc
+}
+
+func newDescs(gateway string, exporter string, extraLabels map[string]string) *descs {
+
+	constLabels := prometheus.Labels{"gateway": gateway}
+	for name, value := range extraLabels {
+		constLabels[name] = value
+	}
+	// the lua and the upnp collector of a gateway collect in the background independently
+	exporterLabels := prometheus.Labels{"exporter": exporter}
+	for name, value := range constLabels {
+		exporterLabels[name] = value
+	}
+	return &descs{
+		deviceInfo:     prometheus.NewDesc("fritzbox_info", "Model and firmware of the FRITZ!Box (constant 1).", []string{"model", "firmware", "serial"}, constLabels),
+		wanUtilization: prometheus.NewDesc("fritzbox_wan_utilization_ratio", "WAN link utilization (current byte rate / link capacity).", []string{"direction"}, constLabels),
+		hostActive:     prometheus.NewDesc("fritzbox_host_active", "Is the host currently active (1 = online).", []string{"mac", "ip", "name", "interface"}, constLabels),
+		hostsActive:    prometheus.NewDesc("fritzbox_hosts_active", "Number of currently active hosts per interface.", []string{"interface"}, constLabels),
+		loginFailures:  prometheus.NewDesc("fritzbox_login_failures_total", "Failed logins to the FRITZ!Box found in the event log.", nil, constLabels),
+		sessions:       prometheus.NewDesc("fritzbox_sessions_active", "Estimated active user interface sessions (successful logins within the session lifetime).", nil, constLabels),
+		lastCollection: prometheus.NewDesc("fritzbox_exporter_last_collection_timestamp_seconds", "Time of the last background collection, the served values are as old as this timestamp.", nil, exporterLabels),
+		deviceUp:       prometheus.NewDesc("fritzbox_device_up", "1 if the last collection from the FRITZ!Box succeeded.", nil, constLabels),
+		scrapeSuccess:  prometheus.NewDesc("fritzbox_scrape_success", "0 if all metrics of the last collection failed, failures of single metrics are counted in fritzbox_exporter_collect_errors.", nil, exporterLabels),
+		unsupported:    prometheus.NewDesc("fritzbox_metric_unsupported", "Metric definition disabled at startup, since the FRITZ!Box lacks its upnp service or action (constant 1).", []string{"fqname"}, constLabels),
+		resultAge:      prometheus.NewDesc("fritzbox_exporter_cached_result_age_seconds", "Age of the served results of metrics with cache TTL.", []string{"metric"}, exporterLabels),
+
+		luaPageUp:          prometheus.NewDesc("fritzbox_lua_page_up", "1 if the last request of the lua page succeeded.", []string{"page"}, constLabels),
+		luaPageLastSuccess: prometheus.NewDesc("fritzbox_lua_page_last_success_timestamp_seconds", "Time of the last successful request of the lua page.", []string{"page"}, constLabels),
+
+		externalIPInfo:    prometheus.NewDesc("fritzbox_external_ip_info", "Current external IPv4 and IPv6 address of the FRITZ!Box (constant 1).", []string{"ipv4", "ipv6"}, constLabels),
+		externalIPChanges: prometheus.NewDesc("fritzbox_external_ip_changes_total", "Number of changes of the external address since the start of the exporter.", []string{"family"}, constLabels),
+		wanUptime:         prometheus.NewDesc("fritzbox_wan_connection_uptime_seconds", "Uptime of the WAN connection (PPP session on DSL boxes).", nil, constLabels),
+		wanReconnects:     prometheus.NewDesc("fritzbox_wan_reconnects_total", "Number of reconnects of the WAN connection (lower uptime or new external address) since the start of the exporter.", nil, constLabels),
+		portMappings:      prometheus.NewDesc("fritzbox_port_mappings", "Number of enabled port mappings (port forwardings opened via UPnP) of the WAN connection.", nil, constLabels),
+		portMapping:       prometheus.NewDesc("fritzbox_port_mapping_enabled", "Port mapping of the WAN connection (1 = enabled).", []string{"protocol", "external_port", "internal_client", "internal_port", "description"}, constLabels),
+		serviceInfo:       prometheus.NewDesc("fritzbox_upnp_service_info", "Upnp service discovered on the FRITZ!Box (constant 1).", []string{"service_type", "service_id"}, constLabels),
+		firmwareUpdate:    prometheus.NewDesc("fritzbox_firmware_update_available", "1 if AVM offers a firmware update for the FRITZ!Box, labeled by the current and the new version and the URL of its release notes.", []string{"current_version", "new_version", "info_url"}, constLabels),
+
+		wlanClientAssociated: prometheus.NewDesc("fritzbox_wlan_client_associated", "Is the client authenticated to the WLAN (1 = associated).", []string{"wlan", "mac", "name"}, constLabels),
+		wlanClientSpeed:      prometheus.NewDesc("fritzbox_wlan_client_speed_mbps", "Current link speed of the WLAN client in Mbit/s.", []string{"wlan", "mac", "name"}, constLabels),
+		wlanClientSignal:     prometheus.NewDesc("fritzbox_wlan_client_signal_strength", "Signal strength of the WLAN client as reported by the FRITZ!Box.", []string{"wlan", "mac", "name"}, constLabels),
+
+		thermostatTemperature: prometheus.NewDesc("fritzbox_thermostat_temperature_celsius", "Temperature measured by the thermostat.", []string{"ain", "name"}, constLabels),
+		thermostatTarget:      prometheus.NewDesc("fritzbox_thermostat_target_temperature_celsius", "Target temperature of the thermostat (missing while the valve is switched permanently closed or open).", []string{"ain", "name"}, constLabels),
+		thermostatValve:       prometheus.NewDesc("fritzbox_thermostat_valve_state", "Valve state of the thermostat: closed (off), open (on) or regulating to the target temperature (1 = current state).", []string{"ain", "name", "state"}, constLabels),
+		thermostatWindowOpen:  prometheus.NewDesc("fritzbox_thermostat_window_open", "1 if the thermostat detected an open window.", []string{"ain", "name"}, constLabels),
+		thermostatHoliday:     prometheus.NewDesc("fritzbox_thermostat_holiday_active", "1 if the holiday mode of the thermostat is active.", []string{"ain", "name"}, constLabels),
+		thermostatBatteryLow:  prometheus.NewDesc("fritzbox_thermostat_battery_low", "1 if the battery of the thermostat is low.", []string{"ain", "name"}, constLabels),
+		thermostatBattery:     prometheus.NewDesc("fritzbox_thermostat_battery_percent", "Battery charge of the thermostat.", []string{"ain", "name"}, constLabels),
+	}
+}
@@ -0,0 +1,245 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/internal/fritzfake"
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+func upnpTestMetrics() *metric.MetricsFile {
+
+	return &metric.MetricsFile{Metrics: []*metric.Metric{
+		{
+			Service:   "urn:dslforum-org:service:DeviceInfo:1",
+			Action:    "GetInfo",
+			ResultKey: "UpTime",
+			PromDesc:  metric.PromDesc{FqName: "gateway_uptime_seconds", Help: "uptime", VarLabels: []string{"gateway"}},
+			PromType:  "GaugeValue",
+		},
+		{
+			Service:   "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1",
+			Action:    "GetAddonInfos",
+			ResultKey: "TotalBytesSent",
+			PromDesc:  metric.PromDesc{FqName: "gateway_wan_bytes_sent", Help: "bytes sent on gateway WAN interface", VarLabels: []string{"gateway"}},
+			PromType:  "CounterValue",
+		},
+	}}
+}
+
+func luaTestMetrics() *metric.MetricsFile {
+
+	return &metric.MetricsFile{Metrics: []*metric.Metric{
+		{
+			Page:       "energy",
+			ResultPath: "data.drain",
+			ResultKey:  "actPerc",
+			PromDesc:   metric.PromDesc{FqName: "gateway_data_energy_consumption", Help: "percentage of energy consumed", VarLabels: []string{"gateway", "name"}},
+			PromType:   "GaugeValue",
+		},
+	}}
+}
+
+// sampleValues returns the values of the samples by name and the value of the label
+func sampleValues(samples []Sample, label string) map[string]float64 {
+
+	values := map[string]float64{}
+	for _, s := range samples {
+		values[s.Name+"/"+s.Labels[label]] = s.Value
+	}
+	return values
+}
+
+func TestUpnpCollectorEndToEnd(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	c, err := NewUpnpCollector(upnpTestMetrics(), box.URL, "exporter", "secret", "fake.fritz.box", WithRoundLog(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.CollectOnce(context.Background())
+	if err != nil {
+		t.Fatalf("CollectOnce() error = %v", err)
+	}
+
+	values := sampleValues(samples, "gateway")
+	want := map[string]float64{
+		"gateway_uptime_seconds/fake.fritz.box": 86400,
+		"gateway_wan_bytes_sent/fake.fritz.box": 123456789,
+	}
+	for key, value := range want {
+		if got, ok := values[key]; !ok || got != value {
+			t.Errorf("sample %s = %v, want %v", key, got, value)
+		}
+	}
+
+	stats := box.Stats()
+	if stats.Challenges == 0 || stats.Authorized == 0 {
+		t.Errorf("TR-064 requests = %+v, want a digest challenge and authorized requests", stats)
+	}
+}
+
+func TestUpnpCollectorWrongPassword(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	c, err := NewUpnpCollector(upnpTestMetrics(), box.URL, "exporter", "wrong", "fake.fritz.box", WithRoundLog(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.CollectOnce(context.Background())
+	if err == nil {
+		t.Fatal("CollectOnce() succeeded with a wrong password")
+	}
+
+	// the IGD actions don't require authentication
+	values := sampleValues(samples, "gateway")
+	if _, ok := values["gateway_uptime_seconds/fake.fritz.box"]; ok {
+		t.Error("sample of the digest protected action collected with a wrong password")
+	}
+	if _, ok := values["gateway_wan_bytes_sent/fake.fritz.box"]; !ok {
+		t.Error("sample of the unprotected action missing")
+	}
+	if stats := box.Stats(); stats.Authorized != 0 {
+		t.Errorf("%d TR-064 requests authorized with a wrong password", stats.Authorized)
+	}
+}
+
+func TestLuaCollectorEndToEnd(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	c, err := NewLuaCollector(luaTestMetrics(), box.URL, "exporter", "secret", "fake.fritz.box", WithRoundLog(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 2; round++ {
+		samples, err := c.CollectOnce(context.Background())
+		if err != nil {
+			t.Fatalf("CollectOnce() error = %v", err)
+		}
+		values := sampleValues(samples, "name")
+		want := map[string]float64{
+			"gateway_data_energy_consumption/gesamtsystem": 42,
+			"gateway_data_energy_consumption/wlan":         17,
+		}
+		for key, value := range want {
+			if got, ok := values[key]; !ok || got != value {
+				t.Errorf("round %d: sample %s = %v, want %v", round, key, got, value)
+			}
+		}
+	}
+
+	// the session of the first login is reused by the second round
+	stats := box.Stats()
+	if stats.Logins != 1 || stats.Pages != 2 {
+		t.Errorf("lua requests = %+v, want 1 login and 2 pages", stats)
+	}
+}
+
+func TestLuaCollectorWrongPassword(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	c, err := NewLuaCollector(luaTestMetrics(), box.URL, "exporter", "wrong", "fake.fritz.box", WithRoundLog(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.CollectOnce(context.Background())
+	if err == nil {
+		t.Fatal("CollectOnce() succeeded with a wrong password")
+	}
+	if len(samples) > 0 {
+		t.Errorf("%d samples collected with a wrong password", len(samples))
+	}
+	if stats := box.Stats(); stats.Logins != 0 || stats.Pages != 0 {
+		t.Errorf("lua requests = %+v, want no session", stats)
+	}
+}
+
+func TestWLANClientsEndToEnd(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	c, err := NewUpnpCollector(&metric.MetricsFile{}, box.URL, "exporter", "secret", "fake.fritz.box", WithRoundLog(false), WithWLANClients(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	err = registry.Register(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	clients := map[string]bool{}
+	for _, family := range families {
+		if family.GetName() != "fritzbox_wlan_client_associated" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			clients[labels["wlan"]+"/"+labels["mac"]] = m.GetGauge().GetValue() == 1
+		}
+	}
+	want := map[string]bool{"1/AA:BB:CC:00:00:01": true, "2/AA:BB:CC:00:00:02": true, "2/AA:BB:CC:00:00:03": false}
+	if !reflect.DeepEqual(clients, want) {
+		t.Errorf("associated WLAN clients = %v, want %v", clients, want)
+	}
+}
+
+func TestDSLInfoEndToEnd(t *testing.T) {
+
+	box := fritzfake.New(t, "exporter", "secret")
+
+	data, err := os.ReadFile("../packs/dsl-upnp.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack metric.MetricsFile
+	err = json.Unmarshal(data, &pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsFile := &metric.MetricsFile{}
+	for _, m := range pack.Metrics {
+		if m.Action == "X_AVM-DE_GetDSLInfo" {
+			metricsFile.Metrics = append(metricsFile.Metrics, m)
+		}
+	}
+
+	c, err := NewUpnpCollector(metricsFile, box.URL, "exporter", "secret", "fake.fritz.box", WithRoundLog(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.CollectOnce(context.Background())
+	if err != nil {
+		t.Fatalf("CollectOnce() error = %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, s := range samples {
+		values[s.Name+"/"+s.Labels["direction"]+s.Labels["modulation"]+"/"+s.Labels["profile"]] = s.Value
+	}
+	want := map[string]float64{
+		"gateway_dsl_power_dbm/Downstream/": 14.5,
+		"gateway_dsl_power_dbm/Upstream/":   7.2,
+		"gateway_dsl_line_info/vdsl2/17a":   1,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("DSL info samples = %v, want %v", values, want)
+	}
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/aexel90/fritzbox_exporter/fixture"
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/timing"
+)
+
+// Exporter fills the MetricResult of the metrics from a device. The lua and upnp exporters implement it,
+// custom exporters (e.g. a mock for tests) are plugged in with NewCollector.
+type Exporter interface {
+	Collect(metrics []*metric.Metric) error
+}
+
+// ContextExporter is an exporter whose collections abort as soon as ctx is done
+type ContextExporter interface {
+	Exporter
+	CollectWithContext(ctx context.Context, metrics []*metric.Metric) error
+}
+
+// SessionExporter is an exporter which requires a session, Login creates it if none exists yet
+type SessionExporter interface {
+	Exporter
+	Login(ctx context.Context) error
+}
+
+// InvalidatingExporter is an exporter with state of the device (sessions, service descriptions) which can be dropped
+type InvalidatingExporter interface {
+	Exporter
+	Invalidate() error
+}
+
+// NewCollector creates a collector of the metrics for a custom exporter, exporterType is its exporter label (e.g. ssh)
+func NewCollector(metricsFile *metric.MetricsFile, exporter Exporter, exporterType string, gateway string, opts ...Option) (*Collector, error) {
+	return newCollector(metricsFile, exporter, exporterType, gateway, newOptions(opts), &timing.Stages{}, nil)
+}
+
+// newCollector initializes the metrics of the file and creates the collector for the exporter
+func newCollector(metricsFile *metric.MetricsFile, exporter Exporter, exporterType string, gateway string, o *options, stages *timing.Stages, requests *requestCounter) (*Collector, error) {
+
+	metrics := copyMetrics(metricsFile.Metrics)
+	initDescAndType(metrics, o.namingConventions, gateway, metricsFile.ExtraLabels)
+	err := initLabelRenames(metricsFile.LabelRenames)
+	if err != nil {
+		return nil, err
+	}
+	err = initTransforms(metrics)
+	if err != nil {
+		return nil, err
+	}
+	err = initCacheTTLs(metrics)
+	if err != nil {
+		return nil, err
+	}
+	err = initCounters(metrics)
+	if err != nil {
+		return nil, err
+	}
+	err = initLabelTemplates(metrics)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newSeriesFilter(gateway, o.seriesLimit, o.labelAllow, o.labelDeny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collector{metrics: metrics, labelValueRenames: metricsFile.LabelRenames, exporter: exporter, exporterType: exporterType, gateway: gateway, interval: o.collectInterval, stages: stages,
+		descs: newDescs(gateway, exporterType, metricsFile.ExtraLabels), requests: requests, roundLog: o.roundLog, deviceUp: o.deviceUp, afterCollect: o.afterCollect, counters: o.counterStore(), filter: filter}, nil
+}
+
+// Record collects the metrics once and adds their results to the recording, see fixture.Exporter
+func (collector *Collector) Record(ctx context.Context, recording *fixture.Recording) error {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	err := collector.collect(ctx)
+	recording.Add(collector.metrics)
+	return err
+}
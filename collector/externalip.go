@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const wanIPConnectionService = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// externalIP remembers the external addresses of the box to count their changes, e.g. for dyndns alerting
+type externalIP struct {
+	ipv4    string
+	ipv6    string
+	changes map[string]float64
+	updated bool
+}
+
+func newExternalIP() *externalIP {
+	return &externalIP{changes: map[string]float64{"ipv4": 0, "ipv6": 0}}
+}
+
+func (e *externalIP) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	result, err := exporter.Call(ctx, wanIPConnectionService, "GetExternalIPAddress")
+	if err != nil {
+		return err
+	}
+	ipv4, ok := result["ExternalIPAddress"].(string)
+	if !ok {
+		return fmt.Errorf("GetExternalIPAddress has no result ExternalIPAddress")
+	}
+	e.ipv4 = e.track("ipv4", e.ipv4, ipv4)
+
+	// boxes without IPv6 connection don't offer the action
+	result, err = exporter.Call(ctx, wanIPConnectionService, "X_AVM_DE_GetExternalIPv6Address")
+	if err == nil {
+		ipv6, _ := result["ExternalIPv6Address"].(string)
+		e.ipv6 = e.track("ipv6", e.ipv6, ipv6)
+	}
+
+	e.updated = true
+	return nil
+}
+
+// track counts a change of the address and returns the address to remember. Addresses are empty
+// (or 0.0.0.0) while the connection is down, so only changes between two valid addresses are counted.
+func (e *externalIP) track(family string, previous string, current string) string {
+
+	if current == "" || current == "0.0.0.0" || current == "::" {
+		return previous
+	}
+	if e.updated && previous != "" && previous != current {
+		e.changes[family]++
+	}
+	return current
+}
+
+func (e *externalIP) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if !e.updated {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(descs.externalIPInfo, prometheus.GaugeValue, 1, e.ipv4, e.ipv6)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		ch <- prometheus.MustNewConstMetric(descs.externalIPChanges, prometheus.CounterValue, e.changes[family], family)
+	}
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const userInterfaceService = "urn:dslforum-org:service:UserInterface:1"
+
+// firmwareUpdate holds the result of UserInterface:1#GetInfo, the firmware update offered by AVM for the box
+type firmwareUpdate struct {
+	info       *deviceInfo
+	available  bool
+	newVersion string
+	infoURL    string
+	updated    time.Time
+}
+
+// update asks the box for a firmware update, if it was not asked within the refresh interval of the device info
+func (f *firmwareUpdate) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	if !f.updated.IsZero() && time.Since(f.updated) < deviceInfoRefreshInterval {
+		return nil
+	}
+
+	result, err := exporter.Call(ctx, userInterfaceService, "GetInfo")
+	if err != nil {
+		return err
+	}
+
+	available, ok := result["UpgradeAvailable"].(bool)
+	if !ok {
+		return fmt.Errorf("GetInfo of %s has no result UpgradeAvailable", userInterfaceService)
+	}
+	f.available = available
+	f.newVersion, _ = result["X_AVM-DE_Version"].(string)
+	f.infoURL, _ = result["X_AVM-DE_InfoURL"].(string)
+	f.updated = time.Now()
+	return nil
+}
+
+func (f *firmwareUpdate) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if f.updated.IsZero() {
+		return
+	}
+	current := ""
+	if f.info != nil {
+		current = f.info.firmware
+	}
+	newVersion, infoURL := f.newVersion, f.infoURL
+	if !f.available {
+		newVersion, infoURL = "", ""
+	}
+	ch <- prometheus.MustNewConstMetric(descs.firmwareUpdate, prometheus.GaugeValue, boolToFloat(f.available), current, newVersion, infoURL)
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// groupCollector collects the metrics of some groups of a collector, e.g. for a frequent scrape of the
+// cheap groups next to an infrequent scrape of the expensive ones
+type groupCollector struct {
+	collector *Collector
+	groups    map[string]bool
+}
+
+// Groups returns the groups of the metric definitions of the collector
+func (collector *Collector) Groups() []string {
+
+	seen := map[string]bool{}
+	groups := []string{}
+	for _, m := range collector.metrics {
+		if m.Group != "" && !seen[m.Group] {
+			seen[m.Group] = true
+			groups = append(groups, m.Group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Select returns a prometheus collector serving the metrics of the groups only. Metrics without group
+// are served by unfiltered scrapes only.
+func (collector *Collector) Select(groups []string) prometheus.Collector {
+
+	selected := map[string]bool{}
+	for _, group := range groups {
+		selected[group] = true
+	}
+	return &groupCollector{collector: collector, groups: selected}
+}
+
+// Describe for prometheus
+func (g *groupCollector) Describe(ch chan<- *prometheus.Desc) {
+	g.collector.Describe(ch)
+}
+
+// Collect for prometheus, in background mode from the last snapshot
+func (g *groupCollector) Collect(ch chan<- prometheus.Metric) {
+
+	if g.collector.interval <= 0 {
+		g.collector.collectGroups(context.Background(), ch, g.groups)
+		return
+	}
+
+	excluded := map[*prometheus.Desc]bool{}
+	for _, m := range g.collector.metrics {
+		if !g.groups[m.Group] {
+			excluded[m.Desc] = true
+		}
+	}
+	for desc, group := range g.collector.descs.groups() {
+		if !g.groups[group] {
+			excluded[desc] = true
+		}
+	}
+	filtered := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range filtered {
+			if !excluded[m.Desc()] {
+				ch <- m
+			}
+		}
+		close(done)
+	}()
+	g.collector.collectSnapshot(filtered)
+	close(filtered)
+	<-done
+}
+
+// groups maps the descriptors of the built-in metrics to the group of the metric definitions they complement
+func (d *descs) groups() map[*prometheus.Desc]string {
+
+	return map[*prometheus.Desc]string{
+		d.deviceInfo:            "device",
+		d.wanUtilization:        "wan",
+		d.hostActive:            "hosts",
+		d.hostsActive:           "hosts",
+		d.loginFailures:         "device",
+		d.sessions:              "device",
+		d.externalIPInfo:        "wan",
+		d.externalIPChanges:     "wan",
+		d.wanUptime:             "wan",
+		d.wanReconnects:         "wan",
+		d.portMappings:          "wan",
+		d.portMapping:           "wan",
+		d.serviceInfo:           "device",
+		d.firmwareUpdate:        "device",
+		d.wlanClientAssociated:  "wlan",
+		d.wlanClientSpeed:       "wlan",
+		d.wlanClientSignal:      "wlan",
+		d.thermostatTemperature: "smarthome",
+		d.thermostatTarget:      "smarthome",
+		d.thermostatValve:       "smarthome",
+		d.thermostatWindowOpen:  "smarthome",
+		d.thermostatHoliday:     "smarthome",
+		d.thermostatBatteryLow:  "smarthome",
+		d.thermostatBattery:     "smarthome",
+	}
+}
+
+func metricsOfGroups(metrics []*metric.Metric, groups map[string]bool) []*metric.Metric {
+
+	selected := []*metric.Metric{}
+	for _, m := range metrics {
+		if groups[m.Group] {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
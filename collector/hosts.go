@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// hostInventory iterates the host table of the box via Hosts:1#GetGenericHostEntry
+type hostInventory struct {
+	metric  *metric.Metric
+	results []map[string]interface{}
+}
+
+func newHostInventory() *hostInventory {
+
+	return &hostInventory{
+		metric: &metric.Metric{
+			Service: "urn:dslforum-org:service:Hosts:1",
+			Action:  "GetGenericHostEntry",
+			ActionArgument: &metric.ActionArg{
+				Name:           "NewIndex",
+				IsIndex:        true,
+				ProviderAction: "GetHostNumberOfEntries",
+				Value:          "HostNumberOfEntries",
+			},
+		},
+	}
+}
+
+func (h *hostInventory) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	// the host table is expensive to iterate, so skip it as well while the box is under load
+	if exporter.Degraded {
+		h.results = nil
+		return nil
+	}
+
+	// hosts failing to be read are skipped, the others are still exported
+	results, err := exporter.Request(ctx, h.metric)
+	if len(results) == 0 {
+		h.results = nil
+		return err
+	}
+	h.results = dedupResults(results, "MACAddress")
+	return err
+}
+
+func (h *hostInventory) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	activeHosts := make(map[string]float64)
+
+	for _, result := range h.results {
+		if result == nil {
+			continue
+		}
+
+		active, _ := result["Active"].(bool)
+		value := boolToFloat(active)
+		mac := hostField(result, "MACAddress")
+		iface := hostField(result, "InterfaceType")
+
+		ch <- prometheus.MustNewConstMetric(descs.hostActive, prometheus.GaugeValue, value,
+			mac, hostField(result, "IPAddress"), hostField(result, "HostName"), iface)
+
+		activeHosts[iface] += value
+	}
+
+	for iface, count := range activeHosts {
+		ch <- prometheus.MustNewConstMetric(descs.hostsActive, prometheus.GaugeValue, count, iface)
+	}
+}
+
+func hostField(result map[string]interface{}, key string) string {
+
+	value, ok := result[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return strings.ToLower(fmt.Sprintf("%v", value))
+}
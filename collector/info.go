@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const deviceInfoService = "urn:dslforum-org:service:DeviceInfo:1"
+
+// deviceInfoRefreshInterval is the interval in which model and firmware are read again, e.g. to detect firmware updates
+const deviceInfoRefreshInterval = time.Hour
+
+// deviceInfo holds the result of DeviceInfo:1#GetInfo
+type deviceInfo struct {
+	model    string
+	firmware string
+	serial   string
+	updated  time.Time
+}
+
+// update reads the device info, if it was not read within the refresh interval
+func (d *deviceInfo) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	if !d.updated.IsZero() && time.Since(d.updated) < deviceInfoRefreshInterval {
+		return nil
+	}
+
+	result, err := exporter.Call(ctx, deviceInfoService, "GetInfo")
+	if err != nil {
+		return err
+	}
+
+	model, ok := result["ModelName"].(string)
+	if !ok {
+		return fmt.Errorf("GetInfo has no result ModelName")
+	}
+	d.model = model
+	d.firmware, _ = result["SoftwareVersion"].(string)
+	d.serial, _ = result["SerialNumber"].(string)
+	d.updated = time.Now()
+	return nil
+}
+
+func (d *deviceInfo) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if d.updated.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(descs.deviceInfo, prometheus.GaugeValue, 1, d.model, d.firmware, d.serial)
+}
@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+func TestLabelString(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"missing", nil, ""},
+		{"string", "LAN", "LAN"},
+		{"bytes", []byte("LAN"), "LAN"},
+		{"bool", true, "true"},
+		{"uint64", uint64(18446744073709551615), "18446744073709551615"},
+		{"int64", int64(-1), "-1"},
+		{"float", 1.5, "1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := labelString(tt.value)
+			if got != tt.want {
+				t.Errorf("labelString(%#v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLabelValues(t *testing.T) {
+
+	result := map[string]interface{}{
+		"bool":    true,
+		"uint64":  uint64(42),
+		"int64":   int64(-1),
+		"float":   0.25,
+		"nil":     nil,
+		"details": map[string]interface{}{"name": "Laptop"},
+	}
+
+	tests := []struct {
+		label string
+		path  string
+		want  string
+		// missing is set for results without a value, which the skip and error policies reject
+		missing bool
+	}{
+		{label: "bool", want: "true"},
+		{label: "uint64", want: "42"},
+		{label: "int64", want: "-1"},
+		{label: "float", want: "0.25"},
+		{label: "nil", missing: true},
+		{label: "absent", missing: true},
+		{label: "name", path: "details.name", want: "laptop"},
+		{label: "model", path: "details.model", missing: true},
+	}
+	policies := []string{"", metric.MissingLabelEmpty, metric.MissingLabelSkip, metric.MissingLabelError}
+
+	for _, policy := range policies {
+		for _, tt := range tests {
+			t.Run(policy+"/"+tt.label, func(t *testing.T) {
+
+				m := &metric.Metric{MissingLabel: policy}
+				m.PromDesc.VarLabels = []string{"gateway", tt.label}
+				if tt.path != "" {
+					m.Labels = map[string]string{tt.label: tt.path}
+				}
+
+				got, err := getLabelValues(m, result, nil)
+				rejected := tt.missing && (policy == metric.MissingLabelSkip || policy == metric.MissingLabelError)
+				if rejected {
+					var missing *MissingLabelError
+					if !errors.As(err, &missing) || missing.Label != tt.label {
+						t.Fatalf("getLabelValues() error = %v, want MissingLabelError for %s", err, tt.label)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("getLabelValues() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, []string{tt.want}) {
+					t.Errorf("getLabelValues() = %q, want %q", got, []string{tt.want})
+				}
+			})
+		}
+	}
+}
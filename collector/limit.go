@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	droppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fritzbox_metrics_dropped_total",
+		Help: "Series not exported since the metric exceeded the series limit or a label matched the label allowlist/denylist.",
+	}, []string{"gateway", "metric", "reason"})
+
+	descName = regexp.MustCompile(`fqName: "([^"]*)"`)
+)
+
+func init() {
+	prometheus.MustRegister(droppedSeries)
+}
+
+// seriesFilter drops series of a collection by their label values and limits the series per metric,
+// protecting prometheus from unbounded tables like hosts or WLAN clients
+type seriesFilter struct {
+	gateway string
+	limit   int
+	allow   map[string][]*regexp.Regexp
+	deny    map[string][]*regexp.Regexp
+}
+
+func newSeriesFilter(gateway string, limit int, allow string, deny string) (*seriesFilter, error) {
+
+	if limit <= 0 && allow == "" && deny == "" {
+		return nil, nil
+	}
+	allowMatchers, err := parseLabelMatchers(allow)
+	if err != nil {
+		return nil, fmt.Errorf("label allowlist: %v", err)
+	}
+	denyMatchers, err := parseLabelMatchers(deny)
+	if err != nil {
+		return nil, fmt.Errorf("label denylist: %v", err)
+	}
+	return &seriesFilter{gateway: gateway, limit: limit, allow: allowMatchers, deny: denyMatchers}, nil
+}
+
+// parseLabelMatchers parses a comma separated list of label=regex matchers, the regular expressions
+// are anchored and several matchers of a label are alternatives
+func parseLabelMatchers(s string) (map[string][]*regexp.Regexp, error) {
+
+	matchers := make(map[string][]*regexp.Regexp)
+	if s == "" {
+		return matchers, nil
+	}
+	for _, matcher := range strings.Split(s, ",") {
+		name, expr, ok := strings.Cut(strings.TrimSpace(matcher), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid matcher %q, expected label=regex", matcher)
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %v", matcher, err)
+		}
+		matchers[name] = append(matchers[name], re)
+	}
+	return matchers, nil
+}
+
+// wrap returns the channel to collect into instead of ch, finish has to be called after the collection
+func (f *seriesFilter) wrap(ch chan<- prometheus.Metric) (filtered chan<- prometheus.Metric, finish func()) {
+
+	in := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		series := make(map[*prometheus.Desc]int)
+		for m := range in {
+			if f.denied(m) {
+				f.drop(m.Desc(), "filter")
+				continue
+			}
+			if f.limit > 0 {
+				series[m.Desc()]++
+				if series[m.Desc()] > f.limit {
+					f.drop(m.Desc(), "limit")
+					continue
+				}
+			}
+			ch <- m
+		}
+		close(done)
+	}()
+	return in, func() {
+		close(in)
+		<-done
+	}
+}
+
+func (f *seriesFilter) denied(m prometheus.Metric) bool {
+
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return false
+	}
+	var written dto.Metric
+	if m.Write(&written) != nil {
+		return false
+	}
+	labels := make(map[string]string, len(written.GetLabel()))
+	for _, label := range written.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return f.deniedLabels(labels)
+}
+
+func (f *seriesFilter) deniedLabels(labels map[string]string) bool {
+
+	for name, value := range labels {
+		if allow, ok := f.allow[name]; ok && !matchesAny(allow, value) {
+			return true
+		}
+		if matchesAny(f.deny[name], value) {
+			return true
+		}
+	}
+	return false
+}
+
+// samples filters the samples of a metric definition for CollectOnce, like the series of a scrape
+func (f *seriesFilter) samples(samples []Sample) []Sample {
+
+	filtered := make([]Sample, 0, len(samples))
+	for _, s := range samples {
+		if f.deniedLabels(s.Labels) {
+			f.dropName(s.Name, "filter")
+			continue
+		}
+		if f.limit > 0 && len(filtered) >= f.limit {
+			f.dropName(s.Name, "limit")
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
+
+func (f *seriesFilter) drop(desc *prometheus.Desc, reason string) {
+
+	// the fully qualified name of a desc is only available from its string representation
+	name := ""
+	if match := descName.FindStringSubmatch(desc.String()); match != nil {
+		name = match[1]
+	}
+	f.dropName(name, reason)
+}
+
+func (f *seriesFilter) dropName(name string, reason string) {
+	droppedSeries.WithLabelValues(f.gateway, name, reason).Inc()
+}
+
+func matchesAny(res []*regexp.Regexp, value string) bool {
+
+	for _, re := range res {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
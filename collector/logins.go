@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// sessionLifetime is the inactivity timeout of a session of the FRITZ!Box user interface
+const sessionLifetime = 20 * time.Minute
+
+const deviceLogTimeLayout = "02.01.06 15:04:05"
+
+var (
+	loginLine       = regexp.MustCompile(`(?i)(anmeldung|login|logon)`)
+	loginFailedLine = regexp.MustCompile(`(?i)(fehlgeschlagen|failed|ungültig|invalid)`)
+)
+
+// loginEvents evaluates the event log of the box (DeviceInfo:1#GetDeviceLog) for logins of other clients
+type loginEvents struct {
+	failures float64
+	sessions float64
+	seen     map[string]bool
+}
+
+func (l *loginEvents) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	result, err := exporter.Call(ctx, deviceInfoService, "GetDeviceLog")
+	if err != nil {
+		return err
+	}
+	deviceLog, ok := result["DeviceLog"].(string)
+	if !ok {
+		return fmt.Errorf("GetDeviceLog has no result DeviceLog")
+	}
+
+	seen := make(map[string]bool)
+	sessions := 0.0
+	for _, line := range strings.Split(deviceLog, "\n") {
+		line = strings.TrimSpace(line)
+		if !loginLine.MatchString(line) {
+			continue
+		}
+		seen[line] = true
+
+		if loginFailedLine.MatchString(line) {
+			// the log contains the most recent entries only, so count lines not seen during the last update
+			if !l.seen[line] {
+				l.failures++
+			}
+			continue
+		}
+		if isRecentLogEntry(line, time.Now()) {
+			sessions++
+		}
+	}
+	l.seen = seen
+	l.sessions = sessions
+	return nil
+}
+
+// isRecentLogEntry checks whether the log entry was written within the session lifetime
+func isRecentLogEntry(line string, now time.Time) bool {
+
+	if len(line) < len(deviceLogTimeLayout) {
+		return false
+	}
+	timestamp, err := time.ParseInLocation(deviceLogTimeLayout, line[:len(deviceLogTimeLayout)], time.Local)
+	if err != nil {
+		return false
+	}
+	return now.Sub(timestamp) < sessionLifetime
+}
+
+func (l *loginEvents) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if l.seen == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(descs.loginFailures, prometheus.CounterValue, l.failures)
+	ch <- prometheus.MustNewConstMetric(descs.sessions, prometheus.GaugeValue, l.sessions)
+}
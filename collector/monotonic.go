@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// counterState is the last raw value of a series of a monotonic metric and the offset added to it
+type counterState struct {
+	Gateway     string   `json:"gateway"`
+	Metric      string   `json:"metric"`
+	LabelValues []string `json:"labelValues"`
+	Last        float64  `json:"last"`
+	Offset      float64  `json:"offset"`
+	// Created is the time the series was seen first, the start of the accumulated counter
+	Created time.Time `json:"created"`
+}
+
+// CounterStore accumulates the counters of monotonic metrics across resets and wraps of the box counters.
+// With a file the state is persisted, so the counters survive restarts of the exporter.
+// A store can be shared by several collectors.
+type CounterStore struct {
+	file    string
+	mutex   sync.Mutex
+	states  map[string]*counterState
+	changed bool
+}
+
+// NewCounterStore creates a store, loading the state from the file if it exists (file is optional)
+func NewCounterStore(file string) (*CounterStore, error) {
+
+	store := &CounterStore{file: file, states: make(map[string]*counterState)}
+	if file == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading counter state file: %v", err)
+	}
+
+	var states []*counterState
+	err = json.Unmarshal(data, &states)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing counter state file: %v", err)
+	}
+	for _, state := range states {
+		store.states[counterKey(state.Gateway, state.Metric, state.LabelValues)] = state
+	}
+	return store, nil
+}
+
+func counterKey(gateway string, metricName string, labelValues []string) string {
+	return gateway + "\xff" + metricName + "\xff" + strings.Join(labelValues, "\xff")
+}
+
+// decreasePolicy updates the state of a series whose raw value decreased below the last one
+type decreasePolicy func(state *counterState, value float64)
+
+// accumulateResets continues the counter from the last value after a reset (e.g. reboot of the box)
+func accumulateResets(state *counterState, value float64) {
+	state.Offset += state.Last
+}
+
+// restartCounter restarts the counter after a reset, like the counter of the box
+func restartCounter(state *counterState, value float64) {
+	state.Offset = 0
+	state.Created = time.Now()
+}
+
+// accumulateWraps adds 2^32 if a 32 bit counter wrapped, other decreases are handled by the reset policy
+func accumulateWraps(reset decreasePolicy) decreasePolicy {
+	return func(state *counterState, value float64) {
+		if wrapped32(state.Last, value) {
+			state.Offset += 1 << 32
+			return
+		}
+		reset(state, value)
+	}
+}
+
+// update returns the accumulated value of the series for the raw value read from the box and its start time,
+// decreases of the raw value are handled by the policy
+func (store *CounterStore) update(gateway string, metricName string, labelValues []string, value float64, decreased decreasePolicy) (float64, time.Time) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := counterKey(gateway, metricName, labelValues)
+	state, ok := store.states[key]
+	if !ok {
+		state = &counterState{Gateway: gateway, Metric: metricName, LabelValues: labelValues, Created: time.Now()}
+		store.states[key] = state
+	} else if value < state.Last {
+		decreased(state, value)
+	}
+	if value != state.Last || !ok {
+		store.changed = true
+	}
+	state.Last = value
+	return state.Offset + value, state.Created
+}
+
+// wrapped32 reports if a decreasing counter wrapped at 2^32 instead of being reset
+func wrapped32(last float64, value float64) bool {
+	return last < 1<<32 && last-value > 1<<31
+}
+
+// Save writes the state to the file, if it changed since the last save
+func (store *CounterStore) Save() error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.file == "" || !store.changed {
+		return nil
+	}
+
+	states := make([]*counterState, 0, len(store.states))
+	for _, state := range store.states {
+		states = append(states, state)
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+
+	// replace the file atomically, so a crash can't leave a truncated state
+	tmpFile := store.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, store.file)
+	if err != nil {
+		return err
+	}
+	store.changed = false
+	return nil
+}
+
+// adjustMonotonic replaces the values of a monotonic metric by their never decreasing values.
+// Only 32 bit counters (ui4 results) can wrap at 2^32, a decrease of other counters is a reset.
+func (collector *Collector) adjustMonotonic(m *metric.Metric) {
+
+	policy := accumulateResets
+	if m.ResultTypes[resultKey(m)] == "ui4" {
+		policy = accumulateWraps(accumulateResets)
+	}
+	collector.accumulate(m, policy)
+}
+
+// extendCounters replaces the values of a 32 bit counter metric by their 64 bit values,
+// unlike adjustMonotonic a reset (e.g. reboot of the box) restarts the counter
+func (collector *Collector) extendCounters(m *metric.Metric) {
+	collector.accumulate(m, accumulateWraps(restartCounter))
+}
+
+func (collector *Collector) accumulate(m *metric.Metric, decreased decreasePolicy) {
+
+	name := monotonicName(m)
+	for _, promResult := range m.PromResult {
+		promResult.Value, promResult.Created = collector.counters.update(collector.gateway, name, promResult.LabelValues, promResult.Value, decreased)
+	}
+}
+
+// initCounters rejects metrics accumulated by both policies, they would share their state in the store
+func initCounters(metrics []*metric.Metric) error {
+
+	for _, m := range metrics {
+		if m.Monotonic && m.Extend32BitCounter {
+			return fmt.Errorf("%s: monotonic can't be combined with extend32BitCounter", m.PromDesc.FqName)
+		}
+	}
+	return nil
+}
+
+// monotonicName identifies the metric in the store by its name and fixed labels, since metrics with
+// different fixed labels share the name, e.g. direction="Sent" and direction="Received"
+func monotonicName(m *metric.Metric) string {
+
+	if len(m.PromDesc.FixedLabels) == 0 {
+		return m.PromDesc.FqName
+	}
+	pairs := []string{}
+	for name, value := range m.PromDesc.FixedLabels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(pairs)
+	return m.PromDesc.FqName + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a collector
+type Option func(*options)
+
+type options struct {
+	latencyThreshold time.Duration
+	httpClient       *http.Client
+	wanUtilization   bool
+	hosts            bool
+	loginEvents      bool
+	externalIP       bool
+	wanReconnects    bool
+	portMappings     bool
+	portMappingItems bool
+	wlanClients      bool
+	wlanClientNames  bool
+	serviceInventory bool
+	firmwareUpdate   bool
+	thermostats      bool
+	roundLog         bool
+	deviceUp         bool
+	afterCollect     func([]Sample)
+	counters         *CounterStore
+	collectInterval  time.Duration
+
+	discoveryInterval  time.Duration
+	discoveryCacheFile string
+
+	namingConventions bool
+
+	seriesLimit int
+	labelAllow  string
+	labelDeny   string
+}
+
+func newOptions(opts []Option) *options {
+
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// counterStore returns the configured counter store or a new one in memory
+func (o *options) counterStore() *CounterStore {
+
+	if o.counters != nil {
+		return o.counters
+	}
+	store, _ := NewCounterStore("")
+	return store
+}
+
+// WithLatencyThreshold skips high cost metrics of a collection round as soon as the
+// average request latency of the round exceeds the threshold (0 = disabled)
+func WithLatencyThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.latencyThreshold = threshold
+	}
+}
+
+// WithHTTPClient uses the client for all requests to the box
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithWANUtilization exports the WAN link utilization derived from the byte counters and the link capacity
+func WithWANUtilization(enabled bool) Option {
+	return func(o *options) {
+		o.wanUtilization = enabled
+	}
+}
+
+// WithHosts exports the host inventory of the box, which can lead to a large number of series
+func WithHosts(enabled bool) Option {
+	return func(o *options) {
+		o.hosts = enabled
+	}
+}
+
+// WithLoginEvents exports failed logins and active user interface sessions found in the event log of the box
+func WithLoginEvents(enabled bool) Option {
+	return func(o *options) {
+		o.loginEvents = enabled
+	}
+}
+
+// WithExternalIP exports the external addresses of the box and counts their changes
+func WithExternalIP(enabled bool) Option {
+	return func(o *options) {
+		o.externalIP = enabled
+	}
+}
+
+// WithWANReconnects exports the uptime of the WAN connection and counts its reconnects
+func WithWANReconnects(enabled bool) Option {
+	return func(o *options) {
+		o.wanReconnects = enabled
+	}
+}
+
+// WithPortMappings exports the number of enabled port mappings of the WAN connection, with entries
+// additionally a series per port mapping
+func WithPortMappings(enabled bool, entries bool) Option {
+	return func(o *options) {
+		o.portMappings = enabled || entries
+		o.portMappingItems = entries
+	}
+}
+
+// WithWLANClients exports the link speed, signal strength and association state per WLAN client, with
+// resolveNames labeled by the host name of the host table
+func WithWLANClients(enabled bool, resolveNames bool) Option {
+	return func(o *options) {
+		o.wlanClients = enabled
+		o.wlanClientNames = resolveNames
+	}
+}
+
+// WithSeriesLimit exports at most limit series per metric and collection, the others are counted in
+// fritzbox_metrics_dropped_total (0 = unlimited)
+func WithSeriesLimit(limit int) Option {
+	return func(o *options) {
+		o.seriesLimit = limit
+	}
+}
+
+// WithLabelFilter drops the series whose label values don't match the allowlist or match the denylist,
+// both comma separated lists of label=regex matchers
+func WithLabelFilter(allow string, deny string) Option {
+	return func(o *options) {
+		o.labelAllow = allow
+		o.labelDeny = deny
+	}
+}
+
+// WithFirmwareUpdate exports whether AVM offers a firmware update for the box
+func WithFirmwareUpdate(enabled bool) Option {
+	return func(o *options) {
+		o.firmwareUpdate = enabled
+	}
+}
+
+// WithServiceInventory exports an info series per discovered upnp service
+func WithServiceInventory(enabled bool) Option {
+	return func(o *options) {
+		o.serviceInventory = enabled
+	}
+}
+
+// WithThermostats exports the state of the thermostats read via AHA-HTTP (lua collector only)
+func WithThermostats(enabled bool) Option {
+	return func(o *options) {
+		o.thermostats = enabled
+	}
+}
+
+// WithRoundLog logs a summary of each collection round
+func WithRoundLog(enabled bool) Option {
+	return func(o *options) {
+		o.roundLog = enabled
+	}
+}
+
+// WithDeviceUp exports fritzbox_device_up, whether the last collection from the box succeeded.
+// It should be enabled for a single collector per box.
+func WithDeviceUp(enabled bool) Option {
+	return func(o *options) {
+		o.deviceUp = enabled
+	}
+}
+
+// WithAfterCollect calls the hook with the samples of each collection round, e.g. to evaluate threshold rules.
+// The hook is called while the collector is locked, so it must not block.
+func WithAfterCollect(hook func([]Sample)) Option {
+	return func(o *options) {
+		o.afterCollect = hook
+	}
+}
+
+// WithCounterStore accumulates the monotonic metrics in the store, e.g. one persisting them across restarts
+// (default: a store of the collector without file)
+func WithCounterStore(store *CounterStore) Option {
+	return func(o *options) {
+		o.counters = store
+	}
+}
+
+// WithCollectInterval collects the metrics in the background (see RunBackground) instead of on every scrape (0 = disabled)
+func WithCollectInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.collectInterval = interval
+	}
+}
+
+// WithDiscoveryInterval discovers the upnp services of the box again after the interval (0 = only after unknown service errors)
+func WithDiscoveryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.discoveryInterval = interval
+	}
+}
+
+// WithDiscoveryCacheFile persists the discovered upnp services in the file, so a restart needs no discovery
+func WithDiscoveryCacheFile(file string) Option {
+	return func(o *options) {
+		o.discoveryCacheFile = file
+	}
+}
+
+// WithNamingConventions renames the metrics to follow the prometheus naming conventions
+// (unit suffix and _total suffix for counters)
+func WithNamingConventions(enabled bool) Option {
+	return func(o *options) {
+		o.namingConventions = enabled
+	}
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// maxPortMappings limits the iteration of the port mapping table, in case a box never reports its end
+const maxPortMappings = 1024
+
+// portMappings reads the port mapping table of the WAN connection, e.g. to detect port openings of UPnP clients
+type portMappings struct {
+	entries  bool
+	mappings []portMapping
+	updated  bool
+}
+
+type portMapping struct {
+	protocol     string
+	externalPort string
+	client       string
+	internalPort string
+	description  string
+	enabled      bool
+}
+
+func (p *portMappings) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	service := wanConnectionService(exporter)
+	mappings := []portMapping{}
+	for i := 0; i < maxPortMappings; i++ {
+		result, err := exporter.CallWithArgument(ctx, service, "GetGenericPortMappingEntry", &upnp.ActionArgument{Name: "NewPortMappingIndex", Value: i})
+		if err != nil {
+			// the box answers the index behind the last entry with SpecifiedArrayIndexInvalid (713)
+			if metric.ErrorReason(err) == metric.ReasonSoapFault {
+				break
+			}
+			return err
+		}
+		enabled, _ := result["PortMappingEnabled"].(bool)
+		mappings = append(mappings, portMapping{
+			protocol:     mappingField(result, "PortMappingProtocol"),
+			externalPort: mappingField(result, "ExternalPort"),
+			client:       mappingField(result, "InternalClient"),
+			internalPort: mappingField(result, "InternalPort"),
+			description:  mappingField(result, "PortMappingDescription"),
+			enabled:      enabled,
+		})
+	}
+	p.mappings = mappings
+	p.updated = true
+	return nil
+}
+
+func (p *portMappings) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if !p.updated {
+		return
+	}
+	enabled := 0
+	for _, m := range p.mappings {
+		if m.enabled {
+			enabled++
+		}
+		if p.entries {
+			ch <- prometheus.MustNewConstMetric(descs.portMapping, prometheus.GaugeValue, boolToFloat(m.enabled),
+				m.protocol, m.externalPort, m.client, m.internalPort, m.description)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(descs.portMappings, prometheus.GaugeValue, float64(enabled))
+}
+
+func mappingField(result map[string]interface{}, key string) string {
+
+	value, ok := result[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const wanPPPConnectionService = "urn:schemas-upnp-org:service:WANPPPConnection:1"
+
+// wanReconnects tracks the uptime of the WAN connection to count reconnects, e.g. the forced
+// reconnects of the ISP. DSL boxes report the PPP session, other boxes the IP connection.
+type wanReconnects struct {
+	uptime     uint64
+	ip         string
+	reconnects float64
+	updated    bool
+}
+
+func (w *wanReconnects) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	service := wanConnectionService(exporter)
+	result, err := exporter.Call(ctx, service, "GetStatusInfo")
+	if err != nil {
+		return err
+	}
+	uptime, ok := result["Uptime"].(uint64)
+	if !ok {
+		return fmt.Errorf("GetStatusInfo of %s has no result Uptime", service)
+	}
+	result, err = exporter.Call(ctx, service, "GetExternalIPAddress")
+	if err != nil {
+		return err
+	}
+	ip, _ := result["ExternalIPAddress"].(string)
+	if ip == "0.0.0.0" {
+		ip = ""
+	}
+
+	// a new address without lower uptime means the reconnect happened between two updates
+	// after the connection was up for longer than before
+	if w.updated && (uptime < w.uptime || (ip != "" && w.ip != "" && ip != w.ip)) {
+		w.reconnects++
+	}
+	w.uptime = uptime
+	if ip != "" {
+		w.ip = ip
+	}
+	w.updated = true
+	return nil
+}
+
+// wanConnectionService returns the PPP connection of DSL boxes, the IP connection otherwise
+func wanConnectionService(exporter *upnp.Exporter) string {
+
+	if _, ok := exporter.Services[wanPPPConnectionService]; ok {
+		return wanPPPConnectionService
+	}
+	return wanIPConnectionService
+}
+
+func (w *wanReconnects) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if !w.updated {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(descs.wanUptime, prometheus.GaugeValue, float64(w.uptime))
+	ch <- prometheus.MustNewConstMetric(descs.wanReconnects, prometheus.CounterValue, w.reconnects)
+}
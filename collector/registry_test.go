@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// staticExporter returns the same results for all metrics
+type staticExporter struct{}
+
+func (staticExporter) Collect(metrics []*metric.Metric) error {
+
+	for _, m := range metrics {
+		m.MetricResult = []map[string]interface{}{{"NewTotalBytesSent": uint64(1024), "NewInterface": "LAN"}}
+	}
+	return nil
+}
+
+func TestCollectorsOfSeveralGateways(t *testing.T) {
+
+	metricsFile := &metric.MetricsFile{Metrics: []*metric.Metric{
+		{
+			PromDesc:  metric.PromDesc{FqName: "gateway_wan_bytes_sent", Help: "bytes sent on gateway WAN interface", VarLabels: []string{"gateway"}},
+			PromType:  "CounterValue",
+			ResultKey: "NewTotalBytesSent",
+			Monotonic: true,
+		},
+		{
+			PromDesc:  metric.PromDesc{FqName: "gateway_interface_bytes_sent", Help: "bytes sent per interface", VarLabels: []string{"gateway", "NewInterface"}},
+			PromType:  "GaugeValue",
+			ResultKey: "NewTotalBytesSent",
+			CacheTTL:  "1m",
+		},
+	}}
+
+	registry := prometheus.NewPedanticRegistry()
+	for _, gateway := range []string{"fritz.box", "repeater.fritz.box"} {
+		c, err := NewCollector(metricsFile, staticExporter{}, "mock", gateway, WithDeviceUp(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = registry.Register(c)
+		if err != nil {
+			t.Fatalf("registering the collector of %s: %v", gateway, err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	series := map[string]int{}
+	for _, family := range families {
+		series[family.GetName()] = len(family.GetMetric())
+	}
+	for _, name := range []string{"gateway_wan_bytes_sent", "gateway_interface_bytes_sent", "fritzbox_device_up"} {
+		if series[name] != 2 {
+			t.Errorf("%s has %d series, want one per gateway", name, series[name])
+		}
+	}
+}
+
+func TestLuaAndUpnpCollectorsOfOneGateway(t *testing.T) {
+
+	metricsFiles := map[string]*metric.MetricsFile{
+		"upnp": {Metrics: []*metric.Metric{{
+			PromDesc:  metric.PromDesc{FqName: "gateway_interface_bytes_sent", Help: "bytes sent per interface", VarLabels: []string{"gateway", "NewInterface"}},
+			PromType:  "GaugeValue",
+			ResultKey: "NewTotalBytesSent",
+			CacheTTL:  "1m",
+		}}},
+		"lua": {Metrics: []*metric.Metric{{
+			PromDesc:  metric.PromDesc{FqName: "gateway_data_interface_bytes", Help: "bytes per interface from data.lua", VarLabels: []string{"gateway", "NewInterface"}},
+			PromType:  "GaugeValue",
+			ResultKey: "NewTotalBytesSent",
+			CacheTTL:  "1m",
+		}}},
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	for _, exporterType := range []string{"upnp", "lua"} {
+		c, err := NewCollector(metricsFiles[exporterType], staticExporter{}, exporterType, "fritz.box")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = registry.Register(c)
+		if err != nil {
+			t.Fatalf("registering the %s collector: %v", exporterType, err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "fritzbox_exporter_cached_result_age_seconds" && len(family.GetMetric()) != 2 {
+			t.Errorf("%s has %d series, want one per exporter", family.GetName(), len(family.GetMetric()))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/timing"
+)
+
+// TestReport summarizes a test collection of a collector
+type TestReport struct {
+	Gateway  string `json:"gateway"`
+	Exporter string `json:"exporter"`
+	// Error of the collection, no metric could be collected
+	Error string `json:"error,omitempty"`
+	// ResultError of the conversion of the collected values
+	ResultError string         `json:"resultError,omitempty"`
+	Metrics     []MetricReport `json:"metrics"`
+	// Stages are the seconds spent in each stage of the collection pipeline
+	Stages map[string]float64 `json:"stages"`
+}
+
+// MetricReport is the test result of a single metric
+type MetricReport struct {
+	Name    string   `json:"name"`
+	Results int      `json:"results"`
+	Values  []Sample `json:"values"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Failed reports whether the collection failed as a whole
+func (report *TestReport) Failed() bool {
+	return report.Error != ""
+}
+
+// Partial reports whether single metrics failed or returned no results
+func (report *TestReport) Partial() bool {
+
+	if report.ResultError != "" {
+		return true
+	}
+	for _, m := range report.Metrics {
+		if m.Results == 0 || m.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatStages formats the stage durations in pipeline order, e.g. "auth=0.120s fetch=1.500s"
+func formatStages(stages map[string]float64) string {
+
+	names := []string{}
+	for stage := range stages {
+		names = append(names, stage)
+	}
+	timing.Sort(names)
+
+	parts := []string{}
+	for _, stage := range names {
+		parts = append(parts, fmt.Sprintf("%s=%.3fs", stage, stages[stage]))
+	}
+	return strings.Join(parts, " ")
+}
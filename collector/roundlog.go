@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/httpclient"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// requestCounter counts the HTTP requests of a collector to the box and observes their duration
+type requestCounter struct {
+	next     http.RoundTripper
+	requests int64
+	gateway  string
+	exporter string
+}
+
+// countRequests returns a copy of the client counting its requests
+func countRequests(client *http.Client, gateway string, exporter string) (*http.Client, *requestCounter) {
+
+	if client == nil {
+		client = httpclient.Default()
+	}
+	counted := &http.Client{}
+	*counted = *client
+	counter := &requestCounter{next: counted.Transport, gateway: gateway, exporter: exporter}
+	if counter.next == nil {
+		counter.next = http.DefaultTransport
+	}
+	counted.Transport = counter
+	return counted, counter
+}
+
+func (c *requestCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	atomic.AddInt64(&c.requests, 1)
+
+	inFlight := requestsInFlight.WithLabelValues(c.gateway, c.exporter)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	resp, err := c.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	requestDuration.WithLabelValues(c.gateway, c.exporter, httpclient.Endpoint(req), code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// take returns the requests since the last call
+// take returns the number of requests since the last call, 0 for custom exporters whose requests are not counted
+func (c *requestCounter) take() int64 {
+
+	if c == nil {
+		return 0
+	}
+	return atomic.SwapInt64(&c.requests, 0)
+}
+
+// logRound logs a summary of the collection round in logfmt, so the health of the scrapes is visible in the logs
+func (collector *Collector) logRound(start time.Time, errs int) {
+
+	if !collector.roundLog {
+		return
+	}
+
+	series := 0
+	for _, m := range collector.metrics {
+		series += len(m.PromResult)
+	}
+	cacheHits := 0
+	if upnpExporter, ok := collector.exporter.(*upnp.Exporter); ok {
+		cacheHits = upnpExporter.CacheHits
+	}
+
+	fmt.Printf("level=info msg=\"collection finished\" gateway=%s exporter=%s duration=%s http_calls=%d cache_hits=%d series=%d errors=%d\n",
+		collector.gateway, collector.ExporterType(), time.Since(start).Round(time.Millisecond), collector.requests.take(), cacheHits, series, errs+len(collector.resultErrors))
+}
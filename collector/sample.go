@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// Sample is a single collected value, usable without prometheus
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Time   time.Time         `json:"time"`
+}
+
+// CollectOnce collects all metrics a single time and returns them as samples.
+// It allows embedding the collection into other applications without running an HTTP server.
+// Like a scrape it serves metrics with cache TTL from the cache and applies the series limit and label filters.
+// Failing metrics don't affect the others, their error is returned along with the samples collected.
+func (collector *Collector) CollectOnce(ctx context.Context) ([]Sample, error) {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	start := time.Now()
+	errs := 0
+	err := collector.collect(ctx)
+	if err != nil {
+		errs++
+	}
+
+	collector.addGatewayGeneric()
+
+	resultErr := collector.getResult()
+	if err == nil {
+		err = resultErr
+	}
+	collector.logRound(start, errs)
+	collector.observeStages()
+
+	samples := collector.filteredSamples()
+	if collector.afterCollect != nil {
+		collector.afterCollect(samples)
+	}
+	return samples, err
+}
+
+// filteredSamples returns the samples of all metrics of the last collection passing the series filter
+func (collector *Collector) filteredSamples() []Sample {
+
+	if collector.filter == nil {
+		return collector.allSamples()
+	}
+	now := time.Now()
+	samples := []Sample{}
+	for _, m := range collector.metrics {
+		samples = append(samples, collector.filter.samples(collector.samples(m, now))...)
+	}
+	return samples
+}
+
+// allSamples returns the samples of all metrics of the last collection
+func (collector *Collector) allSamples() []Sample {
+
+	now := time.Now()
+	samples := []Sample{}
+	for _, m := range collector.metrics {
+		samples = append(samples, collector.samples(m, now)...)
+	}
+	return samples
+}
+
+// runAfterCollect passes the samples of the last collection to the after collect hook, if configured
+func (collector *Collector) runAfterCollect() {
+
+	if collector.afterCollect != nil {
+		collector.afterCollect(collector.allSamples())
+	}
+}
+
+// samples converts the prometheus results of the metric
+func (collector *Collector) samples(m *metric.Metric, now time.Time) []Sample {
+
+	samples := []Sample{}
+	for _, promResult := range m.PromResult {
+
+		labels := map[string]string{"gateway": collector.gateway}
+		for name, value := range m.PromDesc.FixedLabels {
+			labels[name] = value
+		}
+		for i, name := range m.VarLabelNames() {
+			labels[name] = promResult.LabelValues[i]
+		}
+
+		samples = append(samples, Sample{Name: m.PromDesc.FqName, Labels: labels, Value: promResult.Value, Time: now})
+	}
+	return samples
+}
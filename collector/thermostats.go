@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/aha"
+	"github.com/aexel90/fritzbox_exporter/lua"
+)
+
+var valveStates = []string{"closed", "open", "temperature"}
+
+// thermostats reads the radiator controllers (HKR) from the AHA-HTTP device list, since TR-064 lacks
+// e.g. the window open detection and the holiday mode
+type thermostats struct {
+	devices []aha.Device
+	updated bool
+}
+
+func (t *thermostats) update(ctx context.Context, exporter *lua.Exporter) error {
+
+	body, err := exporter.HomeAutomation(ctx, aha.DeviceListInfosCommand)
+	if err != nil {
+		return err
+	}
+	list, err := aha.Parse(body)
+	if err != nil {
+		return err
+	}
+
+	t.devices = nil
+	for _, device := range list.Devices {
+		// devices out of DECT range report outdated values
+		if device.Thermostat != nil && device.Present == 1 {
+			t.devices = append(t.devices, device)
+		}
+	}
+	t.updated = true
+	return nil
+}
+
+func (t *thermostats) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if !t.updated {
+		return
+	}
+	for _, device := range t.devices {
+		hkr := device.Thermostat
+		if celsius, ok := aha.Celsius(hkr.Measured); ok {
+			ch <- prometheus.MustNewConstMetric(descs.thermostatTemperature, prometheus.GaugeValue, celsius, device.Identifier, device.Name)
+		}
+		if celsius, ok := aha.Celsius(hkr.Target); ok {
+			ch <- prometheus.MustNewConstMetric(descs.thermostatTarget, prometheus.GaugeValue, celsius, device.Identifier, device.Name)
+		}
+		for _, state := range valveStates {
+			ch <- prometheus.MustNewConstMetric(descs.thermostatValve, prometheus.GaugeValue, boolToFloat(hkr.ValveState() == state), device.Identifier, device.Name, state)
+		}
+		ch <- prometheus.MustNewConstMetric(descs.thermostatWindowOpen, prometheus.GaugeValue, float64(hkr.WindowOpenActive), device.Identifier, device.Name)
+		ch <- prometheus.MustNewConstMetric(descs.thermostatHoliday, prometheus.GaugeValue, float64(hkr.HolidayActive), device.Identifier, device.Name)
+		ch <- prometheus.MustNewConstMetric(descs.thermostatBatteryLow, prometheus.GaugeValue, boolToFloat(device.IsBatteryLow()), device.Identifier, device.Name)
+		if percent, ok := device.BatteryPercent(); ok {
+			ch <- prometheus.MustNewConstMetric(descs.thermostatBattery, prometheus.GaugeValue, float64(percent), device.Identifier, device.Name)
+		}
+	}
+}
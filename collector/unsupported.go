@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// disableUnsupported removes the metrics whose service or action the FRITZ!Box doesn't have (e.g. the DSL
+// metrics on a cable box) with a single warning at startup, instead of failing on every collection.
+// It returns the remaining metrics and the names of the disabled ones.
+func disableUnsupported(metrics []*metric.Metric, services map[string]*upnp.Service) ([]*metric.Metric, []string) {
+
+	supported := []*metric.Metric{}
+	unsupported := []string{}
+	seen := map[string]bool{}
+	var warning strings.Builder
+	for _, m := range metrics {
+		err := upnp.Unsupported(services, m)
+		if err == nil {
+			supported = append(supported, m)
+			continue
+		}
+		fmt.Fprintf(&warning, "\n  %s: %v", m.PromDesc.FqName, err)
+		if !seen[m.PromDesc.FqName] {
+			seen[m.PromDesc.FqName] = true
+			unsupported = append(unsupported, m.PromDesc.FqName)
+		}
+	}
+	if warning.Len() > 0 {
+		fmt.Printf("Warning: disabled %d metric definitions not supported by the FRITZ!Box:%s\n", len(metrics)-len(supported), warning.String())
+	}
+	sort.Strings(unsupported)
+	return supported, unsupported
+}
+
+// collectUnsupported exports a series per metric disabled by disableUnsupported
+func collectUnsupported(ch chan<- prometheus.Metric, descs *descs, unsupported []string) {
+
+	for _, name := range unsupported {
+		ch <- prometheus.MustNewConstMetric(descs.unsupported, prometheus.GaugeValue, 1, name)
+	}
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const wanCommonInterfaceConfig = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+
+// wanUtilization derives the link utilization from the total byte counters sampled during subsequent collections
+type wanUtilization struct {
+	lastTime  time.Time
+	lastBytes map[string]uint64
+	ratios    map[string]float64
+}
+
+var utilizationDirections = map[string]struct{ bytesKey, maxBitRateKey string }{
+	"Sent":     {"TotalBytesSent", "Layer1UpstreamMaxBitRate"},
+	"Received": {"TotalBytesReceived", "Layer1DownstreamMaxBitRate"},
+}
+
+func (u *wanUtilization) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	addonInfos, err := exporter.Call(ctx, wanCommonInterfaceConfig, "GetAddonInfos")
+	if err != nil {
+		return err
+	}
+	linkProperties, err := exporter.Call(ctx, wanCommonInterfaceConfig, "GetCommonLinkProperties")
+	if err != nil {
+		return err
+	}
+
+	// the total byte counters are ui4 on most boxes, they wrap at 4 GiB
+	resultTypes := exporter.ResultTypes(wanCommonInterfaceConfig, "GetAddonInfos")
+
+	now := time.Now()
+	elapsed := now.Sub(u.lastTime).Seconds()
+	ratios := make(map[string]float64)
+	bytes := make(map[string]uint64)
+
+	for direction, keys := range utilizationDirections {
+		current, ok := addonInfos[keys.bytesKey].(uint64)
+		if !ok {
+			return fmt.Errorf("GetAddonInfos has no result %s", keys.bytesKey)
+		}
+		bytes[direction] = current
+
+		maxBitRate, ok := linkProperties[keys.maxBitRateKey].(uint64)
+		if !ok || maxBitRate == 0 {
+			continue
+		}
+
+		last, ok := u.lastBytes[direction]
+		if !ok || elapsed <= 0 {
+			continue
+		}
+
+		// a reset of the counter (e.g. reboot of the box) skips the round
+		delta, ok := counterDelta(float64(last), float64(current), resultTypes[keys.bytesKey] == "ui4")
+		if !ok {
+			continue
+		}
+		ratios[direction] = delta * 8 / elapsed / float64(maxBitRate)
+	}
+
+	u.lastTime = now
+	u.lastBytes = bytes
+	u.ratios = ratios
+	return nil
+}
+
+// counterDelta returns the increase of a counter. Only counters of 32 bit (wraps32) can wrap, other decreases
+// (e.g. a reset by a reboot of the box) are reported as not ok.
+func counterDelta(last float64, current float64, wraps32 bool) (float64, bool) {
+
+	if current >= last {
+		return current - last, true
+	}
+	if wraps32 && wrapped32(last, current) {
+		return current + 1<<32 - last, true
+	}
+	return 0, false
+}
+
+func (u *wanUtilization) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	for direction, ratio := range u.ratios {
+		ch <- prometheus.MustNewConstMetric(descs.wanUtilization, prometheus.GaugeValue, ratio, direction)
+	}
+}
@@ -0,0 +1,166 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+const (
+	wlanServicePrefix = "urn:dslforum-org:service:WLANConfiguration:"
+	hostsService      = "urn:dslforum-org:service:Hosts:1"
+)
+
+// maxWLANClients limits the iteration of the associated devices per WLAN, in case a box never reports its end
+const maxWLANClients = 256
+
+// wlanClients reads the devices associated to each WLAN (2.4 GHz, 5 GHz, guest) via
+// WLANConfiguration:N#GetGenericAssociatedDeviceInfo, a series per client and WLAN
+type wlanClients struct {
+	resolveNames bool
+	clients      []wlanClient
+	updated      bool
+}
+
+type wlanClient struct {
+	wlan       string
+	mac        string
+	name       string
+	associated bool
+	speed      float64
+	signal     float64
+}
+
+func (w *wlanClients) update(ctx context.Context, exporter *upnp.Exporter) error {
+
+	// a request per client and WLAN is expensive, so skip it while the box is under load
+	if exporter.Degraded {
+		w.clients = nil
+		w.updated = false
+		return nil
+	}
+
+	services := []string{}
+	for service := range exporter.Services {
+		if strings.HasPrefix(service, wlanServicePrefix) {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+
+	clients := []wlanClient{}
+	seen := map[string]bool{}
+	for _, service := range services {
+		wlan := strings.TrimPrefix(service, wlanServicePrefix)
+		count, err := wlanClientCount(ctx, exporter, service)
+		if err != nil {
+			w.clients = nil
+			w.updated = false
+			return err
+		}
+		for i := 0; i < count; i++ {
+			result, err := exporter.CallWithArgument(ctx, service, "GetGenericAssociatedDeviceInfo", &upnp.ActionArgument{Name: "NewAssociatedDeviceIndex", Value: i})
+			if err != nil {
+				// the box answers the index behind the last client with SpecifiedArrayIndexInvalid (713),
+				// e.g. if a client left since the count was read
+				if metric.ErrorReason(err) == metric.ReasonSoapFault {
+					break
+				}
+				w.clients = nil
+				w.updated = false
+				return err
+			}
+			mac := mappingField(result, "AssociatedDeviceMACAddress")
+			// a client listed twice would fail the scrape with a duplicate series
+			if seen[wlan+"\xff"+mac] {
+				continue
+			}
+			seen[wlan+"\xff"+mac] = true
+			associated, _ := result["AssociatedDeviceAuthState"].(bool)
+			clients = append(clients, wlanClient{
+				wlan:       wlan,
+				mac:        mac,
+				associated: associated,
+				speed:      wlanClientValue(result, "X_AVM-DE_Speed"),
+				signal:     wlanClientValue(result, "X_AVM-DE_SignalStrength"),
+			})
+		}
+	}
+
+	if w.resolveNames {
+		if _, ok := exporter.Services[hostsService]; ok {
+			for i := range clients {
+				clients[i].name = w.hostName(ctx, exporter, clients[i].mac)
+			}
+		}
+	}
+	w.clients = clients
+	w.updated = true
+	return nil
+}
+
+// wlanClientCount returns the number of clients associated to the WLAN, boxes without GetTotalAssociations
+// are iterated until the end of the list up to maxWLANClients
+func wlanClientCount(ctx context.Context, exporter *upnp.Exporter, service string) (int, error) {
+
+	// calling an unknown action would mark the services as stale
+	if _, ok := exporter.Services[service].Actions["GetTotalAssociations"]; !ok {
+		return maxWLANClients, nil
+	}
+	result, err := exporter.Call(ctx, service, "GetTotalAssociations")
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result["TotalAssociations"].(uint64)
+	if !ok {
+		return 0, fmt.Errorf("GetTotalAssociations of %s has no result TotalAssociations", service)
+	}
+	if count > maxWLANClients {
+		count = maxWLANClients
+	}
+	return int(count), nil
+}
+
+// hostName looks the client up in the host table of the box, unknown clients keep an empty name
+func (w *wlanClients) hostName(ctx context.Context, exporter *upnp.Exporter, mac string) string {
+
+	if mac == "" {
+		return ""
+	}
+	result, err := exporter.CallWithArgument(ctx, hostsService, "GetSpecificHostEntry", &upnp.ActionArgument{Name: "NewMACAddress", Value: mac})
+	if err != nil {
+		return ""
+	}
+	return mappingField(result, "HostName")
+}
+
+func (w *wlanClients) collect(ch chan<- prometheus.Metric, descs *descs) {
+
+	if !w.updated {
+		return
+	}
+	for _, c := range w.clients {
+		ch <- prometheus.MustNewConstMetric(descs.wlanClientAssociated, prometheus.GaugeValue, boolToFloat(c.associated), c.wlan, c.mac, c.name)
+		ch <- prometheus.MustNewConstMetric(descs.wlanClientSpeed, prometheus.GaugeValue, c.speed, c.wlan, c.mac, c.name)
+		ch <- prometheus.MustNewConstMetric(descs.wlanClientSignal, prometheus.GaugeValue, c.signal, c.wlan, c.mac, c.name)
+	}
+}
+
+func wlanClientValue(result map[string]interface{}, key string) float64 {
+
+	switch value := result[key].(type) {
+	case uint64:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return 0
+}
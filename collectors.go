@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// collectorSet holds the collectors registered for prometheus, which can be replaced on reload
+type collectorSet struct {
+	mutex      sync.Mutex
+	collectors []*collector.Collector
+	// ctx bounds the background collection, which is restarted with the collectors on reload
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// load creates the collectors of all gateways from the metric files and replaces the registered ones
+func (set *collectorSet) load() error {
+
+	targets, err := gatewayTargets()
+	if err != nil {
+		return err
+	}
+
+	collectors := []*collector.Collector{}
+	for _, t := range targets {
+		luaCollector, upnpCollector, err := newCollectorsFor(t)
+		if err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("gateway %s: %v", t.upnpURL, err)
+			}
+			return err
+		}
+		for _, c := range []*collector.Collector{luaCollector, upnpCollector} {
+			if c != nil {
+				collectors = append(collectors, c)
+			}
+		}
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	for _, c := range set.collectors {
+		prometheus.Unregister(c)
+	}
+	for _, c := range collectors {
+		err = prometheus.Register(c)
+		if err != nil {
+			// restore the previous collectors
+			for _, registered := range collectors {
+				prometheus.Unregister(registered)
+			}
+			for _, previous := range set.collectors {
+				prometheus.MustRegister(previous)
+			}
+			return err
+		}
+	}
+	set.collectors = collectors
+
+	if set.cancel != nil {
+		set.cancel()
+	}
+	if set.ctx == nil {
+		set.ctx = context.Background()
+	}
+	var ctx context.Context
+	ctx, set.cancel = context.WithCancel(set.ctx)
+	for _, c := range collectors {
+		go c.RunBackground(ctx)
+	}
+	return nil
+}
+
+func (set *collectorSet) get() []*collector.Collector {
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return set.collectors
+}
+
+// collectOnce collects all collectors a single time in parallel, failing collectors are skipped
+func (set *collectorSet) collectOnce(ctx context.Context) []collector.Sample {
+
+	collectors := set.get()
+	collected := make([][]collector.Sample, len(collectors))
+
+	var wg sync.WaitGroup
+	for i, c := range collectors {
+		wg.Add(1)
+		go func(i int, c *collector.Collector) {
+			defer wg.Done()
+			var err error
+			collected[i], err = c.CollectOnce(ctx)
+			if err != nil {
+				fmt.Println("Error: ", err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	samples := []collector.Sample{}
+	for _, s := range collected {
+		samples = append(samples, s...)
+	}
+	return samples
+}
+
+// registryOf registers the metrics of the groups of all collectors in a new registry
+func (set *collectorSet) registryOf(groups []string) (*prometheus.Registry, error) {
+
+	collectors := set.get()
+	known := map[string]bool{}
+	for group := range metric.Groups {
+		known[group] = true
+	}
+	for _, c := range collectors {
+		for _, group := range c.Groups() {
+			known[group] = true
+		}
+	}
+	for _, group := range groups {
+		if !known[group] {
+			return nil, fmt.Errorf("unknown metric group %q", group)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		err := registry.Register(c.Select(groups))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// units returns the units of the metrics of all collectors by metric name
+func (set *collectorSet) units() map[string]string {
+
+	units := map[string]string{}
+	for _, c := range set.get() {
+		for name, unit := range c.Units() {
+			units[name] = unit
+		}
+	}
+	return units
+}
+
+// login logs in to all gateways requiring a session
+func (set *collectorSet) login(ctx context.Context) error {
+
+	for _, c := range set.get() {
+		err := c.Login(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidate drops the cached sessions and service descriptions of all collectors
+func (set *collectorSet) invalidate() error {
+
+	for _, c := range set.get() {
+		err := c.Invalidate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+)
+
+var (
+	flagCompareUpnpURL  string
+	flagCompareLuaURL   string
+	flagCompareUsername string
+	flagComparePassword string
+	flagCompareAll      bool
+)
+
+func registerCompareCommand() {
+
+	cmd := newCommand("compare", "compare the configured metrics of two FRITZ!Boxes", compare)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	cmd.flags.StringVar(&flagCompareUpnpURL, "compare-upnp-url", "", "The URL of the second FRITZ!Box - UPNP")
+	cmd.flags.StringVar(&flagCompareLuaURL, "compare-lua-url", "", "The URL of the second FRITZ!Box - LUA")
+	cmd.flags.StringVar(&flagCompareUsername, "compare-username", "", "The user for the second FRITZ!Box (default: username)")
+	cmd.flags.StringVar(&flagComparePassword, "compare-password", "", "The password for the second FRITZ!Box (default: password)")
+	cmd.flags.BoolVar(&flagCompareAll, "compare-all", false, "print equal values as well")
+}
+
+func compare() error {
+
+	if flagCompareUpnpURL == "" && flagCompareLuaURL == "" {
+		return fmt.Errorf("compare-upnp-url or compare-lua-url required")
+	}
+
+	second := target{upnpURL: flagCompareUpnpURL, luaURL: flagCompareLuaURL, username: flagCompareUsername, password: flagComparePassword}
+	if second.upnpURL == "" {
+		second.upnpURL = flagGatewayUpnpURL
+	}
+	if second.luaURL == "" {
+		second.luaURL = flagGatewayLuaURL
+	}
+	if second.username == "" {
+		second.username = flagUsername
+	}
+	if second.password == "" {
+		second.password = flagPassword
+	}
+
+	first := defaultTarget()
+
+	// metrics failing on a box are missing from its values and shown as unsupported
+	valuesA, errsA, err := collectTarget(first)
+	if err != nil {
+		return fmt.Errorf("%s: %v", first.upnpURL, err)
+	}
+	valuesB, errsB, err := collectTarget(second)
+	if err != nil {
+		return fmt.Errorf("%s: %v", second.upnpURL, err)
+	}
+
+	keys := []string{}
+	for key := range valuesA {
+		keys = append(keys, key)
+	}
+	for key := range valuesB {
+		if _, ok := valuesA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("A: %s %s\nB: %s %s\n\n", first.upnpURL, first.luaURL, second.upnpURL, second.luaURL)
+	fmt.Printf("%-80s %20s %20s\n", "metric", "A", "B")
+
+	differences := 0
+	for _, key := range keys {
+		valueA, okA := valuesA[key]
+		valueB, okB := valuesB[key]
+
+		switch {
+		case okA && !okB:
+			fmt.Printf("%-80s %20v %20s\n", key, valueA, "(unsupported)")
+		case !okA && okB:
+			fmt.Printf("%-80s %20s %20v\n", key, "(unsupported)", valueB)
+		case valueA != valueB:
+			fmt.Printf("%-80s %20v %20v\n", key, valueA, valueB)
+		default:
+			if flagCompareAll {
+				fmt.Printf("%-80s %20v %20v\n", key, valueA, valueB)
+			}
+			continue
+		}
+		differences++
+	}
+	fmt.Printf("\n%d metrics, %d differences\n", len(keys), differences)
+	for _, err := range errsA {
+		fmt.Printf("A: Error: %v\n", err)
+	}
+	for _, err := range errsB {
+		fmt.Printf("B: Error: %v\n", err)
+	}
+	return nil
+}
+
+// collectTarget collects all configured metrics of a target, keyed by metric name and labels without gateway.
+// The errors of failed metrics are returned along with the values of the others.
+func collectTarget(t target) (map[string]float64, []error, error) {
+
+	luaCollector, upnpCollector, err := newCollectorsFor(t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]float64)
+	errs := []error{}
+	for _, c := range []*collector.Collector{luaCollector, upnpCollector} {
+		if c == nil {
+			continue
+		}
+
+		samples, err := c.CollectOnce(context.Background())
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, sample := range samples {
+			values[sampleKey(sample)] = sample.Value
+		}
+	}
+	return values, errs, nil
+}
+
+func sampleKey(sample collector.Sample) string {
+
+	labels := []string{}
+	for name, value := range sample.Labels {
+		if name == "gateway" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(labels)
+	return sample.Name + "{" + strings.Join(labels, ",") + "}"
+}
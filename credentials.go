@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	flagUsernameFile string
+	flagPasswordFile string
+)
+
+// readCredentialFiles sets username and password from the files given by -username-file and -password-file,
+// e.g. docker or kubernetes secrets, so the password is neither visible in the process list nor in the environment
+func readCredentialFiles() error {
+
+	if flagUsername != "" && flagUsernameFile != "" {
+		return fmt.Errorf("username and username-file must not be set together")
+	}
+	if flagPassword != "" && flagPasswordFile != "" {
+		return fmt.Errorf("password and password-file must not be set together")
+	}
+
+	if flagUsernameFile != "" {
+		username, err := readCredentialFile(flagUsernameFile)
+		if err != nil {
+			return err
+		}
+		flagUsername = username
+	}
+	if flagPasswordFile != "" {
+		password, err := readCredentialFile(flagPasswordFile)
+		if err != nil {
+			return err
+		}
+		flagPassword = password
+	}
+	return nil
+}
+
+func readCredentialFile(file string) (string, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading credential file: %v", err)
+	}
+	// secrets are often written with a trailing newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
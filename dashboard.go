@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aexel90/fritzbox_exporter/dashboard"
+)
+
+var (
+	flagDashboardOutput string
+	flagDashboardTitle  string
+)
+
+func registerDashboardCommand() {
+
+	cmd := newCommand("dashboard", "generate a grafana dashboard for the configured metrics", generateDashboard)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	cmd.flags.StringVar(&flagDashboardOutput, "output", "", "The JSON file where to store the generated dashboard (default stdout)")
+	cmd.flags.StringVar(&flagDashboardTitle, "dashboard.title", "FRITZ!Box", "The title of the generated dashboard.")
+}
+
+func generateDashboard() error {
+
+	metrics, err := configuredMetrics()
+	if err != nil {
+		return err
+	}
+
+	jsonString, err := json.MarshalIndent(dashboard.Generate(flagDashboardTitle, metrics), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if flagDashboardOutput == "" {
+		fmt.Println(string(jsonString))
+		return nil
+	}
+	return ioutil.WriteFile(flagDashboardOutput, jsonString, 0644)
+}
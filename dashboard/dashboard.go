@@ -0,0 +1,305 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// gridWidth is the number of columns of the grafana grid
+const gridWidth = 24
+
+// datasource refers to the prometheus datasource selected in the dashboard variable
+var datasource = &Datasource{Type: "prometheus", UID: "${datasource}"}
+
+// units maps the metric units (or name suffixes) to grafana units
+var units = map[string]string{
+	"seconds": "s",
+	"bytes":   "bytes",
+	"bits":    "bits",
+	"celsius": "celsius",
+	"percent": "percent",
+	"ratio":   "percentunit",
+	"watts":   "watt",
+	"volts":   "volt",
+	"amperes": "amp",
+	"hertz":   "hertz",
+	"dbm":     "dBm",
+	"db":      "dB",
+}
+
+// rateUnits maps the units of counters to the grafana units of their rates
+var rateUnits = map[string]string{
+	"bytes":   "Bps",
+	"bits":    "bps",
+	"packets": "pps",
+}
+
+// Dashboard is the JSON model of a grafana dashboard
+type Dashboard struct {
+	Title         string     `json:"title"`
+	UID           string     `json:"uid,omitempty"`
+	Description   string     `json:"description,omitempty"`
+	Tags          []string   `json:"tags"`
+	Editable      bool       `json:"editable"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []*Panel   `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []*Variable `json:"list"`
+}
+
+// Variable is a dashboard variable, the datasource or a label query
+type Variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	Multi      bool        `json:"multi,omitempty"`
+	IncludeAll bool        `json:"includeAll,omitempty"`
+}
+
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// Panel is a dashboard panel, rows are panels of type row
+type Panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	GridPos     GridPos      `json:"gridPos"`
+	Collapsed   bool         `json:"collapsed,omitempty"`
+	Datasource  *Datasource  `json:"datasource,omitempty"`
+	Targets     []*Target    `json:"targets,omitempty"`
+	FieldConfig *FieldConfig `json:"fieldConfig,omitempty"`
+	Options     *Options     `json:"options,omitempty"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Target struct {
+	Datasource   *Datasource `json:"datasource"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat,omitempty"`
+	Instant      bool        `json:"instant,omitempty"`
+	Format       string      `json:"format,omitempty"`
+	RefID        string      `json:"refId"`
+}
+
+type FieldConfig struct {
+	Defaults  FieldDefaults `json:"defaults"`
+	Overrides []interface{} `json:"overrides"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+type Options struct {
+	ReduceOptions *ReduceOptions `json:"reduceOptions,omitempty"`
+	Legend        *Legend        `json:"legend,omitempty"`
+}
+
+type ReduceOptions struct {
+	Calcs  []string `json:"calcs"`
+	Fields string   `json:"fields"`
+	Values bool     `json:"values"`
+}
+
+type Legend struct {
+	DisplayMode string `json:"displayMode"`
+	Placement   string `json:"placement"`
+	ShowLegend  bool   `json:"showLegend"`
+}
+
+// Generate returns a dashboard with a row per upnp service or lua page and a panel per metric name:
+// stats for gauges without labels, time series of the rates of counters and of gauges with labels
+// and tables for info metrics. The panels are filtered by the gateway variable.
+func Generate(title string, metrics []*metric.Metric) *Dashboard {
+
+	d := &Dashboard{
+		Title:         title,
+		UID:           "fritzbox-exporter",
+		Description:   "Generated by fritzbox_exporter from its metric definitions",
+		Tags:          []string{"fritzbox"},
+		Editable:      true,
+		SchemaVersion: 39,
+		Refresh:       "1m",
+		Time:          TimeRange{From: "now-6h", To: "now"},
+		Templating: Templating{List: []*Variable{
+			{Name: "datasource", Label: "Datasource", Type: "datasource", Query: "prometheus"},
+			{Name: "gateway", Label: "Gateway", Type: "query", Query: "label_values(fritzbox_device_up, gateway)",
+				Datasource: datasource, Refresh: 2, Multi: true, IncludeAll: true},
+		}},
+	}
+
+	// the rows in the order of their first metric, each metric name only once
+	rows := []string{}
+	rowMetrics := map[string][]*metric.Metric{}
+	seen := map[string]bool{}
+	for _, m := range metrics {
+		if seen[m.PromDesc.FqName] {
+			continue
+		}
+		seen[m.PromDesc.FqName] = true
+		row := rowTitle(m)
+		if _, ok := rowMetrics[row]; !ok {
+			rows = append(rows, row)
+		}
+		rowMetrics[row] = append(rowMetrics[row], m)
+	}
+
+	layout := &layout{}
+	for _, row := range rows {
+		d.add(layout, &Panel{Type: "row", Title: row}, gridWidth, 1)
+		for _, m := range rowMetrics[row] {
+			panel, w, h := newPanel(m)
+			d.add(layout, panel, w, h)
+		}
+		layout.newLine()
+	}
+	return d
+}
+
+// layout places the panels left to right in lines
+type layout struct {
+	x, y, lineHeight int
+}
+
+func (l *layout) newLine() {
+	l.x, l.y, l.lineHeight = 0, l.y+l.lineHeight, 0
+}
+
+func (d *Dashboard) add(l *layout, panel *Panel, w int, h int) {
+
+	if l.x+w > gridWidth {
+		l.newLine()
+	}
+	panel.ID = len(d.Panels) + 1
+	panel.GridPos = GridPos{H: h, W: w, X: l.x, Y: l.y}
+	d.Panels = append(d.Panels, panel)
+	l.x += w
+	l.lineHeight = max(l.lineHeight, h)
+}
+
+// newPanel returns the panel of the metric and its width and height
+func newPanel(m *metric.Metric) (*Panel, int, int) {
+
+	name := m.PromDesc.FqName
+	labels := m.VarLabelNames()
+	selector := fmt.Sprintf(`%s{gateway=~"$gateway"}`, name)
+	legend := "{{gateway}}"
+	for _, label := range labels {
+		legend += fmt.Sprintf(" {{%s}}", label)
+	}
+
+	panel := &Panel{Title: name, Description: m.PromDesc.Help, Datasource: datasource}
+	target := &Target{Datasource: datasource, Expr: selector, LegendFormat: legend, RefID: "A"}
+	panel.Targets = []*Target{target}
+	unit := unitOf(m)
+
+	switch {
+	case strings.HasSuffix(name, "_info"):
+		panel.Type = "table"
+		target.Instant = true
+		target.Format = "table"
+		return panel, gridWidth, 8
+	case m.PromType == "CounterValue":
+		panel.Type = "timeseries"
+		target.Expr = fmt.Sprintf("rate(%s[$__rate_interval])", selector)
+		panel.FieldConfig = newFieldConfig(rateUnit(unit))
+		panel.Options = &Options{Legend: &Legend{DisplayMode: "list", Placement: "bottom", ShowLegend: true}}
+		return panel, 8, 8
+	case len(labels) == 0:
+		panel.Type = "stat"
+		panel.FieldConfig = newFieldConfig(grafanaUnit(unit))
+		panel.Options = &Options{ReduceOptions: &ReduceOptions{Calcs: []string{"lastNotNull"}}}
+		return panel, 4, 4
+	default:
+		panel.Type = "timeseries"
+		panel.FieldConfig = newFieldConfig(grafanaUnit(unit))
+		panel.Options = &Options{Legend: &Legend{DisplayMode: "list", Placement: "bottom", ShowLegend: true}}
+		return panel, 8, 8
+	}
+}
+
+func newFieldConfig(unit string) *FieldConfig {
+	return &FieldConfig{Defaults: FieldDefaults{Unit: unit}, Overrides: []interface{}{}}
+}
+
+// rowTitle is the upnp service without urn prefix and version (e.g. WANCommonInterfaceConfig) or the lua page
+func rowTitle(m *metric.Metric) string {
+
+	switch {
+	case m.Service != "":
+		parts := strings.Split(m.Service, ":")
+		if len(parts) >= 2 {
+			return parts[len(parts)-2]
+		}
+		return m.Service
+	case m.Page != "":
+		return "Page " + m.Page
+	default:
+		return "Other"
+	}
+}
+
+// unitOf returns the declared unit of the metric, or the unit suffix of its name
+func unitOf(m *metric.Metric) string {
+
+	if m.Unit != "" {
+		return strings.ToLower(m.Unit)
+	}
+	name := strings.TrimSuffix(m.PromDesc.FqName, "_total")
+	for unit := range units {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	for unit := range rateUnits {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+func grafanaUnit(unit string) string {
+
+	if u, ok := units[unit]; ok {
+		return u
+	}
+	return "short"
+}
+
+func rateUnit(unit string) string {
+
+	if u, ok := rateUnits[unit]; ok {
+		return u
+	}
+	// e.g. seconds per second of a counter of durations
+	if unit == "seconds" {
+		return "percentunit"
+	}
+	return "cps"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/lua"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+var (
+	flagResultFileUpnpAll string
+	flagDiscoverLua       bool
+	flagLuaPages          string
+	flagResultFileLuaAll  string
+)
+
+func registerDiscoverCommand() {
+
+	cmd := newCommand("discover", "collect ALL available upnp metrics (and lua pages)", discover)
+	addGatewayFlags(cmd.flags)
+	addAutoDiscoverFlags(cmd.flags)
+	cmd.flags.StringVar(&flagResultFileUpnpAll, "result-file-upnp-all", "", "The JSON file where to store the result during collect")
+	cmd.flags.BoolVar(&flagDiscoverLua, "discover-lua", false, "collect the lua pages as well")
+	cmd.flags.StringVar(&flagLuaPages, "lua-pages", strings.Join(lua.KnownPages, ","), "Comma separated lua pages to collect")
+	cmd.flags.StringVar(&flagResultFileLuaAll, "result-file-lua-all", "", "The JSON file where to store the lua result during collect")
+}
+
+func discover() error {
+
+	if flagAutoDiscover {
+		return listDiscoveredTargets()
+	}
+
+	client, err := newGatewayHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	upnp.CollectAll(flagGatewayUpnpURL, flagUsername, flagPassword, client, flagResultFileUpnpAll)
+
+	if flagDiscoverLua {
+		client, err = newGatewayHTTPClient()
+		if err != nil {
+			return err
+		}
+		lua.CollectAll(flagGatewayLuaURL, flagUsername, flagPassword, client, strings.Split(flagLuaPages, ","), flagResultFileLuaAll)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/namsral/flag"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+var (
+	flagUpnpEvents              string
+	flagUpnpEventsListenAddress string
+	flagUpnpEventsCallbackURL   string
+)
+
+var (
+	eventValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fritzbox_event_value",
+		Help: "Last evented value of a numeric state variable.",
+	}, []string{"gateway", "service", "variable"})
+
+	eventChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fritzbox_event_changes_total",
+		Help: "Number of value changes of an evented state variable, e.g. of the external IP address.",
+	}, []string{"gateway", "service", "variable"})
+
+	eventNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fritzbox_event_notifications_total",
+		Help: "Number of event notifications received from the gateway.",
+	}, []string{"gateway", "service"})
+)
+
+func addEventFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagUpnpEvents, "upnp.events", "", "Comma separated upnp services to subscribe to events of instead of polling, e.g. WANIPConnection:1,WANCommonInterfaceConfig:1 (empty = disabled).")
+	fs.StringVar(&flagUpnpEventsListenAddress, "upnp.events-listen-address", ":9044", "The address to listen on for the event notifications of the FRITZ!Box.")
+	fs.StringVar(&flagUpnpEventsCallbackURL, "upnp.events-callback-url", "", "The URL of the event listener reachable by the FRITZ!Box, e.g. http://192.168.178.10:9044/")
+}
+
+// eventState remembers the last values of the evented state variables to count changes
+type eventState struct {
+	mutex   sync.Mutex
+	gateway string
+	values  map[string]string
+}
+
+func (state *eventState) notify(serviceType string, variables map[string]string) {
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	service := serviceType
+	if i := strings.Index(serviceType, ":service:"); i >= 0 {
+		service = serviceType[i+len(":service:"):]
+	}
+	eventNotifications.WithLabelValues(state.gateway, service).Inc()
+
+	for name, value := range variables {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			eventValue.WithLabelValues(state.gateway, service, name).Set(number)
+		}
+
+		key := service + "|" + name
+		previous, ok := state.values[key]
+		if ok && previous != value {
+			eventChanges.WithLabelValues(state.gateway, service, name).Inc()
+		}
+		state.values[key] = value
+	}
+}
+
+// subscribeEvents subscribes to the events of the configured services and serves the callbacks until ctx is done
+func subscribeEvents(ctx context.Context, t target) error {
+
+	if flagUpnpEventsCallbackURL == "" {
+		return fmt.Errorf("upnp.events-callback-url is required for upnp.events")
+	}
+
+	client, err := newGatewayHTTPClient()
+	if err != nil {
+		return err
+	}
+	exporter := &upnp.Exporter{BaseURL: t.upnpURL, Username: t.username, Password: t.password, Client: client,
+		DiscoveryCacheFile: flagUpnpDiscoveryCacheFile}
+	err = exporter.LoadServices()
+	if err != nil {
+		return err
+	}
+
+	serviceTypes := []string{}
+	for _, name := range strings.Split(flagUpnpEvents, ",") {
+		serviceType, err := findServiceType(exporter, strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+
+	name := t.name
+	if name == gatewayLabelDevice {
+		name = deviceLabel(t, client)
+	}
+	gateway, err := gatewayLabel(name, t.upnpURL, t.luaURL)
+	if err != nil {
+		return err
+	}
+	state := &eventState{gateway: gateway, values: map[string]string{}}
+	subscriber := &upnp.Subscriber{Exporter: exporter, CallbackURL: flagUpnpEventsCallbackURL, Notify: state.notify}
+
+	prometheus.MustRegister(eventValue, eventChanges, eventNotifications)
+
+	server := &http.Server{Addr: flagUpnpEventsListenAddress, Handler: subscriber}
+	go func() {
+		fmt.Printf("event notifications accepted at %s\n", flagUpnpEventsListenAddress)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println("Error: event listener failed: ", err)
+		}
+	}()
+
+	err = subscriber.Run(ctx, serviceTypes)
+	server.Close()
+	return err
+}
+
+// findServiceType finds the full service type of the name, e.g. WANIPConnection:1
+func findServiceType(exporter *upnp.Exporter, name string) (string, error) {
+
+	for serviceType := range exporter.Services {
+		if serviceType == name || strings.HasSuffix(serviceType, ":service:"+name) {
+			return serviceType, nil
+		}
+	}
+	return "", fmt.Errorf("service %s not found", name)
+}
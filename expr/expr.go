@@ -0,0 +1,350 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed transformation expression like "value * 8", "TotalBytesSent - TotalBytesReceived"
+// or "(value == \"Up\") * 1 + (value == \"Connecting\") * 2". Comparisons evaluate to 1 or 0.
+// Identifiers may contain dots and dashes, so operators have to be separated by spaces.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Variables resolves identifiers during evaluation
+type Variables func(name string) (interface{}, bool)
+
+type node interface {
+	eval(vars Variables) (interface{}, error)
+}
+
+type numberNode float64
+type stringNode string
+type identNode string
+
+type unaryNode struct {
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+// Parse parses an expression
+func Parse(source string) (*Expression, error) {
+
+	p := &parser{tokens: tokenize(source)}
+	root, err := p.parseComparison()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %v", source, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression '%s': unexpected '%s'", source, p.tokens[p.pos])
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression to a number
+func (e *Expression) Eval(vars Variables) (float64, error) {
+
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	return toFloat(value)
+}
+
+func (n numberNode) eval(vars Variables) (interface{}, error) {
+	return float64(n), nil
+}
+
+func (n stringNode) eval(vars Variables) (interface{}, error) {
+	return string(n), nil
+}
+
+func (n identNode) eval(vars Variables) (interface{}, error) {
+
+	value, ok := vars(string(n))
+	if !ok {
+		return nil, fmt.Errorf("unknown variable '%s'", string(n))
+	}
+	return value, nil
+}
+
+func (n unaryNode) eval(vars Variables) (interface{}, error) {
+
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toFloat(value)
+	return -f, err
+}
+
+func (n binaryNode) eval(vars Variables) (interface{}, error) {
+
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// string comparison
+	ls, lIsString := left.(string)
+	rs, rIsString := right.(string)
+	if lIsString || rIsString {
+		switch n.op {
+		case "==":
+			return boolToFloat(fmt.Sprintf("%v", left) == fmt.Sprintf("%v", right)), nil
+		case "!=":
+			return boolToFloat(fmt.Sprintf("%v", left) != fmt.Sprintf("%v", right)), nil
+		}
+		if lIsString && rIsString && n.op == "+" {
+			return ls + rs, nil
+		}
+	}
+
+	l, err := toFloat(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toFloat(right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "==":
+		return boolToFloat(l == r), nil
+	case "!=":
+		return boolToFloat(l != r), nil
+	case "<":
+		return boolToFloat(l < r), nil
+	case "<=":
+		return boolToFloat(l <= r), nil
+	case ">":
+		return boolToFloat(l > r), nil
+	case ">=":
+		return boolToFloat(l >= r), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+func toFloat(value interface{}) (float64, error) {
+
+	switch tval := value.(type) {
+	case float64:
+		return tval, nil
+	case int:
+		return float64(tval), nil
+	case int64:
+		return float64(tval), nil
+	case uint64:
+		return float64(tval), nil
+	case bool:
+		return boolToFloat(tval), nil
+	case string:
+		f, err := strconv.ParseFloat(tval, 64)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a number", tval)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("unsupported type %T", value)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *parser) parseComparison() (node, error) {
+
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+
+	if p.peek() == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end")
+	case token == "(":
+		inner, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return inner, nil
+	case strings.HasPrefix(token, `"`):
+		return stringNode(strings.Trim(token, `"`)), nil
+	case unicode.IsDigit(rune(token[0])):
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, err
+		}
+		return numberNode(f), nil
+	case isIdentStart(rune(token[0])):
+		return identNode(token), nil
+	}
+	return nil, fmt.Errorf("unexpected '%s'", token)
+}
+
+func tokenize(source string) []string {
+
+	var tokens []string
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:min(j+1, len(runes))]))
+			i = j + 1
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case isIdentStart(r):
+			j := i
+			for j < len(runes) && (isIdentStart(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case strings.ContainsRune("=!<>", r) && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
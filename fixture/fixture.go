@@ -0,0 +1,166 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// Recording holds the exporter results of metrics recorded from a FRITZ!Box, keyed by the source of the metrics
+type Recording struct {
+	Recorded time.Time                           `json:"recorded"`
+	Results  map[string][]map[string]interface{} `json:"results"`
+
+	mutex sync.Mutex
+}
+
+// Key identifies the source of the metric results: service, action, argument and list of upnp metrics,
+// page, params, result path and labels of lua metrics (the lua exporter extracts the values already).
+// Metrics differing only in result key, transform etc. share the results of their source.
+func Key(m *metric.Metric) string {
+
+	if m.Page != "" {
+		parts := []string{"lua", m.Page, sortedMap(m.Params), m.ResultPath, m.ResultKey, strings.Join(m.PromDesc.VarLabels, ",")}
+		for _, pre := range m.PreRequests {
+			parts = append(parts, "pre="+pre.Page+"?"+sortedMap(pre.Params))
+		}
+		if len(m.Labels) > 0 {
+			parts = append(parts, "labels")
+		}
+		return strings.Join(parts, "|")
+	}
+
+	parts := []string{"upnp", m.Service, m.Action}
+	if a := m.ActionArgument; a != nil {
+		parts = append(parts, fmt.Sprintf("%s=%s/%s/%t/%d/%d", a.Name, a.ProviderAction, a.Value, a.IsIndex, a.IndexStart, a.IndexStep))
+	}
+	if m.ListURLKey != "" || m.ListKey != "" {
+		parts = append(parts, m.ListURLKey+m.ListKey, m.ListElement, m.ListFormat)
+	}
+	return strings.Join(parts, "|")
+}
+
+// Add records the results of the collected metrics
+func (recording *Recording) Add(metrics []*metric.Metric) {
+
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+
+	if recording.Results == nil {
+		recording.Results = make(map[string][]map[string]interface{})
+	}
+	for _, m := range metrics {
+		if m.MetricResult != nil {
+			recording.Results[Key(m)] = m.MetricResult
+		}
+	}
+	recording.Recorded = time.Now()
+}
+
+// Save writes the recording to the JSON file
+func (recording *Recording) Save(file string) error {
+
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+
+	data, err := json.MarshalIndent(recording, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// Load reads a recording, integer results stay integers so split words and large counters are exact
+func Load(file string) (*Recording, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	recording := &Recording{}
+	err = decoder.Decode(recording)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing recording %s: %v", file, err)
+	}
+	for _, results := range recording.Results {
+		for _, result := range results {
+			for name, value := range result {
+				if number, ok := value.(json.Number); ok {
+					result[name] = convertNumber(number)
+				}
+			}
+		}
+	}
+	return recording, nil
+}
+
+// Exporter serves the results of a recording instead of requesting a FRITZ!Box, e.g. to develop
+// metric definitions and dashboards or for integration tests
+type Exporter struct {
+	Recording *Recording
+}
+
+// Collect sets the recorded results of the metrics, it fails if none of them was recorded
+func (exporter *Exporter) Collect(metrics []*metric.Metric) error {
+
+	missing := 0
+	for _, m := range metrics {
+		results, ok := exporter.Recording.Results[Key(m)]
+		if !ok {
+			m.MetricResult = nil
+			missing++
+			continue
+		}
+		// the collector adds the gateway to the results, so every collection gets copies
+		m.MetricResult = make([]map[string]interface{}, len(results))
+		for i, result := range results {
+			if result == nil {
+				continue
+			}
+			m.MetricResult[i] = make(map[string]interface{}, len(result))
+			for name, value := range result {
+				m.MetricResult[i][name] = value
+			}
+		}
+	}
+	if missing > 0 && missing == len(metrics) {
+		return fmt.Errorf("none of the %d metrics is recorded", missing)
+	}
+	return nil
+}
+
+func convertNumber(number json.Number) interface{} {
+
+	if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		return u
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	f, _ := number.Float64()
+	return f
+}
+
+func sortedMap(m map[string]string) string {
+
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{}
+	for _, name := range names {
+		parts = append(parts, name+"="+m[name])
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/namsral/flag"
+)
+
+var flagGatewaysFile string
+
+// gatewaysFile configures several FRITZ!Boxes to collect in one exporter
+type gatewaysFile struct {
+	Gateways []gatewayConfig `json:"gateways"`
+}
+
+// gatewayConfig is a FRITZ!Box of the gateways file, missing credentials are taken from the flags
+type gatewayConfig struct {
+	// Name is the value of the gateway label, device for the model name (default: host name of the URL of each collector)
+	Name         string `json:"name"`
+	UpnpURL      string `json:"upnpUrl"`
+	LuaURL       string `json:"luaUrl"`
+	Username     string `json:"username,omitempty"`
+	UsernameFile string `json:"usernameFile,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+}
+
+func addGatewaysFileFlag(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagGatewaysFile, "gateways.file", "", "The JSON file with several FRITZ!Boxes to collect in parallel, instead of the gateway URL flags.")
+}
+
+// gatewayTargets returns the FRITZ!Boxes of the gateways file, the ones found on the LAN with auto discovery
+// or the one configured via the gateway flags
+func gatewayTargets() ([]target, error) {
+
+	if flagAutoDiscover {
+		if flagGatewaysFile != "" {
+			return nil, fmt.Errorf("auto-discover and gateways.file must not be set together")
+		}
+		return discoverTargets()
+	}
+	if flagGatewaysFile == "" {
+		return []target{defaultTarget()}, nil
+	}
+
+	var file gatewaysFile
+	err := readAndParseFile(flagGatewaysFile, &file)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Gateways) == 0 {
+		return nil, fmt.Errorf("no gateways in %s", flagGatewaysFile)
+	}
+
+	targets := []target{}
+	names := map[string]bool{}
+	for i, g := range file.Gateways {
+		if g.UpnpURL == "" || g.LuaURL == "" {
+			return nil, fmt.Errorf("gateway #%d: upnpUrl and luaUrl required", i)
+		}
+		if g.Name != "" {
+			if names[g.Name] {
+				return nil, fmt.Errorf("gateway #%d: duplicate name '%s'", i, g.Name)
+			}
+			names[g.Name] = true
+		}
+
+		t := target{name: g.Name, upnpURL: g.UpnpURL, luaURL: g.LuaURL, username: g.Username, password: g.Password}
+		if g.UsernameFile != "" {
+			t.username, err = readCredentialFile(g.UsernameFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if g.PasswordFile != "" {
+			t.password, err = readCredentialFile(g.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if t.username == "" {
+			t.username = flagUsername
+		}
+		if t.password == "" {
+			t.password = flagPassword
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
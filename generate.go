@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+var flagGenerateOutput string
+
+func registerGenerateCommand() {
+
+	cmd := newCommand("generate", "generate upnp metric definitions for all numeric results of the box", generate)
+	addGatewayFlags(cmd.flags)
+	cmd.flags.StringVar(&flagGenerateOutput, "output", "", "The JSON file where to store the generated definitions (default stdout)")
+}
+
+func generate() error {
+
+	client, err := newGatewayHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	metricsFile, err := upnp.GenerateMetrics(flagGatewayUpnpURL, flagUsername, flagPassword, client)
+	if err != nil {
+		return err
+	}
+
+	jsonString, err := json.MarshalIndent(metricsFile, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if flagGenerateOutput == "" {
+		fmt.Println(string(jsonString))
+		return nil
+	}
+	return ioutil.WriteFile(flagGenerateOutput, jsonString, 0644)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/namsral/flag"
+
+	"github.com/aexel90/fritzbox_exporter/graphite"
+)
+
+var (
+	flagGraphiteAddress  string
+	flagGraphitePrefix   string
+	flagGraphiteInterval time.Duration
+)
+
+func addGraphiteFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagGraphiteAddress, "graphite.address", "", "The graphite plaintext address (host:port) to push the metrics to, empty to disable.")
+	fs.StringVar(&flagGraphitePrefix, "graphite.prefix", "fritzbox", "The prefix of the graphite metric paths.")
+	fs.DurationVar(&flagGraphiteInterval, "graphite.interval", 60*time.Second, "The interval of the graphite pushes.")
+}
+
+// pushGraphite collects the metrics and pushes them to graphite every interval until ctx is done
+func pushGraphite(ctx context.Context, set *collectorSet) {
+
+	ticker := time.NewTicker(flagGraphiteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := graphite.Push(flagGraphiteAddress, flagGraphitePrefix, set.collectOnce(ctx))
+		if err != nil {
+			fmt.Println("Error: graphite push failed: ", err)
+		}
+	}
+}
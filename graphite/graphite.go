@@ -0,0 +1,61 @@
+package graphite
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+)
+
+const dialTimeout = 10 * time.Second
+
+var sanitizer = strings.NewReplacer(" ", "_", ";", "_", "=", "_", "~", "_", "\n", "_", "\t", "_")
+
+// Push sends the samples in the graphite plaintext protocol to address (host:port).
+// The labels become graphite tags, e.g. prefix.gateway_wan_traffic;direction=sent;gateway=fritz.box 42 1600000000
+func Push(address string, prefix string, samples []collector.Sample) error {
+
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	writer := bufio.NewWriter(conn)
+	for _, sample := range samples {
+		_, err = writer.WriteString(Format(prefix, sample))
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Format formats a sample as line of the graphite plaintext protocol
+func Format(prefix string, sample collector.Sample) string {
+
+	path := sanitizer.Replace(sample.Name)
+	if prefix != "" {
+		path = prefix + "." + path
+	}
+
+	names := []string{}
+	for name := range sample.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := sample.Labels[name]
+		// graphite rejects empty tag values
+		if value == "" {
+			continue
+		}
+		path += fmt.Sprintf(";%s=%s", sanitizer.Replace(name), sanitizer.Replace(value))
+	}
+	return fmt.Sprintf("%s %v %d\n", path, sample.Value, sample.Time.Unix())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/namsral/flag"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// flagCollectorGroups holds the -collector.<group> flags by group
+var flagCollectorGroups = map[string]*bool{}
+
+// addCollectorGroupFlags registers an enable flag for each known metric group
+func addCollectorGroupFlags(fs *flag.FlagSet) {
+
+	groups := make([]string, 0, len(metric.Groups))
+	for group := range metric.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		if flagCollectorGroups[group] == nil {
+			flagCollectorGroups[group] = new(bool)
+		}
+		fs.BoolVar(flagCollectorGroups[group], "collector."+group, true, "Enable the metrics of group "+group+": "+metric.Groups[group]+".")
+	}
+}
+
+// filterGroups removes the metrics of disabled groups from the metrics file
+func filterGroups(metricsFile *metric.MetricsFile) {
+
+	if metricsFile == nil {
+		return
+	}
+	metrics := metricsFile.Metrics[:0]
+	for _, m := range metricsFile.Metrics {
+		if enabled, ok := flagCollectorGroups[m.Group]; ok && !*enabled {
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	metricsFile.Metrics = metrics
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/namsral/flag"
+
+	"github.com/aexel90/fritzbox_exporter/history"
+)
+
+// defaultRangeDuration is the range of queries without start
+const defaultRangeDuration = time.Hour
+
+var (
+	flagHistoryRetention time.Duration
+	flagHistoryInterval  time.Duration
+)
+
+func addHistoryFlags(fs *flag.FlagSet) {
+
+	fs.DurationVar(&flagHistoryRetention, "history.retention", 0, "Keep the collected samples of this period in memory and serve them at /api/v1/range (0 = disabled).")
+	fs.DurationVar(&flagHistoryInterval, "history.interval", 60*time.Second, "The interval of the collections kept in memory.")
+}
+
+// recordHistory collects the metrics into the buffer every interval until ctx is done
+func recordHistory(ctx context.Context, set *collectorSet, buffer *history.Buffer) {
+
+	ticker := time.NewTicker(flagHistoryInterval)
+	defer ticker.Stop()
+
+	for {
+		buffer.Add(set.collectOnce(ctx))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rangeHandler answers /api/v1/range?metric=<name>&start=<time>&end=<time> in the format of the prometheus query API,
+// times are unix timestamps or RFC3339, by default the last hour is returned
+func rangeHandler(buffer *history.Buffer) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		name := r.URL.Query().Get("metric")
+		if name == "" {
+			rangeError(w, fmt.Errorf("parameter metric is required"))
+			return
+		}
+
+		end, err := parseTime(r.URL.Query().Get("end"), time.Now())
+		if err != nil {
+			rangeError(w, err)
+			return
+		}
+		start, err := parseTime(r.URL.Query().Get("start"), end.Add(-defaultRangeDuration))
+		if err != nil {
+			rangeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "matrix",
+				"result":     buffer.Range(name, start, end),
+			},
+		})
+	})
+}
+
+func rangeError(w http.ResponseWriter, err error) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+}
+
+// parseTime parses a unix timestamp or a RFC3339 time, def is used for empty values
+func parseTime(value string, def time.Time) (time.Time, error) {
+
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
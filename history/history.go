@@ -0,0 +1,152 @@
+// Package history keeps the samples of the recent collections in memory,
+// so short outages of prometheus can be bridged by querying the exporter itself.
+package history
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+)
+
+// Point is a single value of a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// MarshalJSON encodes the point like the prometheus query API: [<unix time>, "<value>"]
+func (p Point) MarshalJSON() ([]byte, error) {
+
+	t := float64(p.Time.UnixNano()) / float64(time.Second)
+	return json.Marshal([]interface{}{t, strconv.FormatFloat(p.Value, 'f', -1, 64)})
+}
+
+// Series is the result of a range query for a single label set
+type Series struct {
+	Labels map[string]string `json:"metric"`
+	Points []Point           `json:"values"`
+}
+
+// series is the ring buffer of a single label set
+type series struct {
+	labels map[string]string
+	points []Point
+	next   int
+}
+
+// Buffer holds the samples of the last retention period
+type Buffer struct {
+	mutex     sync.Mutex
+	retention time.Duration
+	capacity  int
+	series    map[string]*series
+}
+
+// New creates a buffer keeping the samples of the retention period, collected every interval
+func New(retention time.Duration, interval time.Duration) *Buffer {
+
+	return &Buffer{
+		retention: retention,
+		capacity:  int(retention/interval) + 1,
+		series:    map[string]*series{},
+	}
+}
+
+// Add stores the samples and drops series without samples within the retention period
+func (b *Buffer) Add(samples []collector.Sample) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, sample := range samples {
+		labels := map[string]string{"__name__": sample.Name}
+		for name, value := range sample.Labels {
+			labels[name] = value
+		}
+		key := seriesKey(labels)
+
+		s := b.series[key]
+		if s == nil {
+			s = &series{labels: labels}
+			b.series[key] = s
+		}
+		point := Point{Time: sample.Time, Value: sample.Value}
+		if len(s.points) < b.capacity {
+			s.points = append(s.points, point)
+		} else {
+			s.points[s.next] = point
+		}
+		s.next = (s.next + 1) % b.capacity
+	}
+
+	oldest := time.Now().Add(-b.retention)
+	for key, s := range b.series {
+		if s.latest().Before(oldest) {
+			delete(b.series, key)
+		}
+	}
+}
+
+// Range returns the points of all series of the metric between start and end, ordered by time
+func (b *Buffer) Range(name string, start time.Time, end time.Time) []Series {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	result := []Series{}
+	for _, s := range b.series {
+		if s.labels["__name__"] != name {
+			continue
+		}
+		points := []Point{}
+		for _, p := range s.ordered() {
+			if !p.Time.Before(start) && !p.Time.After(end) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			result = append(result, Series{Labels: s.labels, Points: points})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesKey(result[i].Labels) < seriesKey(result[j].Labels)
+	})
+	return result
+}
+
+// ordered returns the points from oldest to newest, until the buffer is full next is the end of the points
+func (s *series) ordered() []Point {
+
+	return append(append([]Point{}, s.points[s.next:]...), s.points[:s.next]...)
+}
+
+func (s *series) latest() time.Time {
+
+	points := s.ordered()
+	return points[len(points)-1].Time
+}
+
+// seriesKey identifies a label set
+func seriesKey(labels map[string]string) string {
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name)
+		key.WriteString("=")
+		key.WriteString(labels[name])
+		key.WriteString("\xff")
+	}
+	return key.String()
+}
@@ -0,0 +1,168 @@
+// Package httpclient creates the HTTP clients for the connections to the FRITZ!Box. Each exporter gets its
+// own transport, so its keep-alive connections are pooled independently and bounded, since the box only
+// handles a few parallel connections.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns    = 2
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
+// Config of the connections to the box
+type Config struct {
+	// TLS is the base config, e.g. restricting TLS versions and cipher suites (optional)
+	TLS *tls.Config
+	// Insecure skips certificate validation, since fritz.box uses a self signed cert
+	Insecure bool
+	// CAFile is the PEM file with the certificate / CA of the box to validate against (optional)
+	CAFile string
+	// Proxy is the URL of the HTTP proxy for all requests (default: HTTP_PROXY / NO_PROXY environment)
+	Proxy string
+	// MaxIdleConns bounds the kept-alive connections per host (default 2)
+	MaxIdleConns int
+	// IdleConnTimeout closes kept-alive connections unused for this duration (default 90s)
+	IdleConnTimeout time.Duration
+	// MaxConnectionAge recycles the connections at least after this duration (0 = disabled)
+	MaxConnectionAge time.Duration
+	// DNSResolveInterval resolves the hosts again after this duration and recycles
+	// the connections if their address changed (0 = disabled)
+	DNSResolveInterval time.Duration
+	// CheckAddress is called with the IP address and port of every new connection, which is refused on error,
+	// e.g. if the host name resolved to an address outside an allowlist (optional)
+	CheckAddress func(address string) error
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *http.Client
+)
+
+// Default returns the client shared by exporters without configured client
+func Default() *http.Client {
+
+	defaultOnce.Do(func() {
+		defaultClient = &http.Client{Transport: newTransport(Config{}, nil, nil)}
+	})
+	return defaultClient
+}
+
+// New creates a client with a transport of its own for the config
+func New(config Config) (*http.Client, error) {
+
+	tlsConfig := &tls.Config{}
+	if config.TLS != nil {
+		tlsConfig = config.TLS.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = config.Insecure
+
+	if config.CAFile != "" {
+		caCert, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = certPool
+		tlsConfig.InsecureSkipVerify = false
+	}
+
+	var proxyURL *url.URL
+	if config.Proxy != "" {
+		var err error
+		proxyURL, err = url.Parse(config.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", config.Proxy)
+		}
+	}
+
+	transport := newTransport(config, tlsConfig, proxyURL)
+	if config.MaxConnectionAge > 0 || config.DNSResolveInterval > 0 {
+		return &http.Client{Transport: &RecyclingTransport{
+			Next:            transport,
+			MaxAge:          config.MaxConnectionAge,
+			ResolveInterval: config.DNSResolveInterval,
+		}}, nil
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTransport(config Config, tlsConfig *tls.Config, proxyURL *url.URL) *http.Transport {
+
+	maxIdleConns := config.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	idleConnTimeout := config.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialControl(config.CheckAddress),
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+type endpointKey struct{}
+
+// WithEndpoint names the endpoint of the requests made with the context, e.g. the lua page, for instrumentation
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// Endpoint returns the endpoint named by WithEndpoint, the service of a SOAP request or else the path of the request
+func Endpoint(req *http.Request) string {
+
+	if endpoint, ok := req.Context().Value(endpointKey{}).(string); ok {
+		return endpoint
+	}
+	if action := req.Header.Get("SOAPAction"); action != "" {
+		service := strings.SplitN(action, "#", 2)[0]
+		return service[strings.LastIndex(service, ":service:")+len(":service:"):]
+	}
+	return req.URL.Path
+}
+
+// dialControl refuses connections to addresses failing the check before they are established
+func dialControl(check func(address string) error) func(network string, address string, c syscall.RawConn) error {
+
+	if check == nil {
+		return nil
+	}
+	return func(network string, address string, c syscall.RawConn) error {
+		return check(address)
+	}
+}
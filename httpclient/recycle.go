@@ -0,0 +1,80 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecyclingTransport closes the idle keep-alive connections of the transport, as soon as the address
+// of a host changed (e.g. fritz.box after enabling a VPN) or the connections got older than MaxAge.
+// New connections resolve the host again, so requests don't stick to a stale IP.
+type RecyclingTransport struct {
+	Next *http.Transport
+	// MaxAge recycles the connections at least after this duration (0 = disabled)
+	MaxAge time.Duration
+	// ResolveInterval resolves the hosts again after this duration (0 = disabled)
+	ResolveInterval time.Duration
+
+	mutex    sync.Mutex
+	recycled time.Time
+	hosts    map[string]*resolvedHost
+}
+
+type resolvedHost struct {
+	addresses string
+	resolved  time.Time
+}
+
+// RoundTrip recycles the connections if required and sends the request
+func (t *RecyclingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if t.needsRecycling(req.Context(), req.URL.Hostname()) {
+		t.Next.CloseIdleConnections()
+	}
+	return t.Next.RoundTrip(req)
+}
+
+func (t *RecyclingTransport) needsRecycling(ctx context.Context, host string) bool {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	recycle := false
+	if t.recycled.IsZero() {
+		t.recycled = now
+	}
+	if t.MaxAge > 0 && now.Sub(t.recycled) >= t.MaxAge {
+		recycle = true
+	}
+
+	if t.ResolveInterval > 0 && net.ParseIP(host) == nil {
+		if t.hosts == nil {
+			t.hosts = map[string]*resolvedHost{}
+		}
+		h := t.hosts[host]
+		if h == nil || now.Sub(h.resolved) >= t.ResolveInterval {
+			addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err == nil {
+				sort.Strings(addresses)
+				joined := strings.Join(addresses, ",")
+				if h != nil && h.addresses != joined {
+					fmt.Printf("address of %s changed from %s to %s, recycling connections\n", host, h.addresses, joined)
+					recycle = true
+				}
+				t.hosts[host] = &resolvedHost{addresses: joined, resolved: now}
+			}
+		}
+	}
+
+	if recycle {
+		t.recycled = now
+	}
+	return recycle
+}
@@ -0,0 +1,97 @@
+// Package fritzfake serves a fake FRITZ!Box with httptest for the end-to-end tests of the collectors. The box is
+// the simulator, fritzfake additionally counts the requests by their authentication, so tests can check that the
+// TR-064 actions passed the digest auth and the data.lua pages were requested with the SID of a login.
+package fritzfake
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aexel90/fritzbox_exporter/simulator"
+)
+
+// Stats are the requests the box answered
+type Stats struct {
+	// Challenges are the TR-064 requests answered with a digest challenge
+	Challenges int
+	// Authorized are the TR-064 requests which passed the digest auth
+	Authorized int
+	// Logins are the lua logins which created a session
+	Logins int
+	// Pages are the data.lua requests with a valid SID
+	Pages int
+}
+
+// Box is a fake FRITZ!Box served on a local port, URL is its base URL for the upnp and lua collectors
+type Box struct {
+	*httptest.Server
+	Simulator *simulator.Simulator
+
+	mutex sync.Mutex
+	stats Stats
+}
+
+// New starts a box accepting the credentials, it is closed when the test ends
+func New(t testing.TB, username string, password string) *Box {
+
+	box := &Box{Simulator: simulator.New(username, password)}
+	box.Server = httptest.NewServer(box)
+	t.Cleanup(box.Close)
+	return box
+}
+
+// Stats returns the requests answered so far
+func (box *Box) Stats() Stats {
+
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	return box.stats
+}
+
+// ServeHTTP serves the request by the simulator and counts it
+func (box *Box) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	box.Simulator.ServeHTTP(recorder, r)
+
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/upnp/control/"):
+		if recorder.status == http.StatusUnauthorized {
+			box.stats.Challenges++
+		} else if strings.HasPrefix(r.Header.Get("Authorization"), "Digest ") {
+			box.stats.Authorized++
+		}
+	case r.URL.Path == "/login_sid.lua":
+		if r.URL.Query().Get("response") != "" && !bytes.Contains(recorder.body.Bytes(), []byte("<SID>0000000000000000</SID>")) {
+			box.stats.Logins++
+		}
+	case r.URL.Path == "/data.lua":
+		if recorder.status == http.StatusOK {
+			box.stats.Pages++
+		}
+	}
+}
+
+// responseRecorder passes the response on and keeps its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
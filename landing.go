@@ -0,0 +1,74 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"runtime"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>FRITZ!Box Exporter</title></head>
+<body>
+<h1>FRITZ!Box Exporter</h1>
+<p>Version {{.Version}} (revision {{.Revision}}, {{.GoVersion}})</p>
+<h2>Endpoints</h2>
+<ul>
+<li><a href="metrics">/metrics</a></li>
+{{- if .HealthEndpoints}}
+<li><a href="healthz">/healthz</a></li>
+<li><a href="ready">/ready</a></li>
+{{- end}}
+{{- if .History}}
+<li><a href="api/v1/range">/api/v1/range</a>?metric=&lt;name&gt; (samples of the last {{.History}})</li>
+{{- end}}
+{{- if .AdminAddress}}
+<li>admin endpoints (/-/reload, /-/invalidate-cache, /debug/pprof) on {{.AdminAddress}}</li>
+{{- end}}
+</ul>
+<h2>Metric files</h2>
+<ul>
+{{- range .MetricFiles}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+<h2>Collectors</h2>
+<table>
+<tr><th>Gateway</th><th>Exporter</th><th>Metrics</th></tr>
+{{- range .Collectors}}
+<tr><td>{{.Gateway}}</td><td>{{.ExporterType}}</td><td>{{.MetricCount}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// landingPage shows version and configuration of the exporter at /
+func landingPage(set *collectorSet) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		metricFiles := []string{}
+		for _, file := range []string{flagMetricsLuaFile, flagMetricsUpnpFile} {
+			if file != "" {
+				metricFiles = append(metricFiles, file)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		landingTemplate.Execute(w, map[string]interface{}{
+			"Version":         version,
+			"Revision":        revision,
+			"GoVersion":       runtime.Version(),
+			"HealthEndpoints": flagHealthEndpoints,
+			"History":         flagHistoryRetention,
+			"AdminAddress":    flagAdminAddress,
+			"MetricFiles":     metricFiles,
+			"Collectors":      set.get(),
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package lua
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// KnownPages are data.lua pages of current FRITZ!OS versions, not every model offers all of them
+var KnownPages = []string{"overview", "energy", "ecoStat", "netDev", "netCnt", "dslOv", "dslStat", "homeNet", "sh_dev", "wSet", "chan"}
+
+type collectEntry struct {
+	Page   string          `json:"page"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// CollectAll requests the pages and dumps their raw JSON results
+func CollectAll(URL string, username string, password string, client *http.Client, pages []string, resultFile string) {
+
+	luaExporter := Exporter{BaseURL: URL, Username: username, Password: password, Client: client}
+
+	err := luaExporter.Login(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	jsonContent := []collectEntry{}
+
+	for _, page := range pages {
+		fmt.Printf("collecting page '%s'...\n", page)
+
+		entry := collectEntry{Page: page}
+		result, err := luaExporter.request(context.Background(), page, nil)
+		if err != nil {
+			entry.Error = fmt.Sprintf("FAILED:%s", err.Error())
+		} else {
+			entry.Result = result
+		}
+		jsonContent = append(jsonContent, entry)
+	}
+
+	jsonString, err := json.MarshalIndent(jsonContent, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(jsonString))
+
+	if resultFile != "" {
+		err = ioutil.WriteFile(resultFile, jsonString, 0644)
+		if err != nil {
+			fmt.Printf("Failed writing JSON file '%s': %s\n", resultFile, err.Error())
+		}
+	}
+}
@@ -1,15 +1,23 @@
 package lua
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aexel90/fritzbox_exporter/aha"
+	"github.com/aexel90/fritzbox_exporter/httpclient"
 	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/timing"
 	"github.com/tidwall/gjson"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
@@ -17,6 +25,10 @@ import (
 
 const loginPath = "/login_sid.lua"
 const dataPath = "/data.lua"
+const invalidSID = "0000000000000000"
+
+// ErrSessionInvalid is returned if the box rejects the session, e.g. by answering with the login page
+var ErrSessionInvalid = metric.NewReasonError(metric.ReasonAuth, errors.New("lua session invalid"))
 
 // Exporter data
 type Exporter struct {
@@ -24,6 +36,18 @@ type Exporter struct {
 	Username string
 	Password string
 	SID      string
+	// Client used for all requests to the box (default httpclient.Default)
+	Client *http.Client
+	// Stages receives the durations of the collection pipeline stages (optional)
+	Stages *timing.Stages
+	// Pages holds the status of each page requested so far
+	Pages map[string]*PageStatus
+}
+
+// PageStatus is the result of the last request of a page
+type PageStatus struct {
+	Up          bool
+	LastSuccess time.Time
 }
 
 type sessionInfo struct {
@@ -32,95 +56,280 @@ type sessionInfo struct {
 	Challenge string   `xml:"Challenge"`
 }
 
-func (exporter *Exporter) extractMetricValuesFromJSON(jsonResult gjson.Result, key string, labelNames []string) (results []map[string]interface{}) {
-
-	if jsonResult.IsArray() == true {
-		for _, jsonElement := range jsonResult.Array() {
-			if jsonElement.IsArray() {
-				return exporter.extractMetricValuesFromJSON(jsonElement, key, labelNames)
-			} else if jsonElement.IsObject() {
-				result := make(map[string]interface{})
-
-				if key == "" {
-					result["result"] = 1
-				} else {
-					if jsonElement.Get(key).Exists() {
-						result[key] = jsonElement.Get(key).Float()
-					} else {
-						continue
-					}
-				}
-				exporter.getLabelValues(result, labelNames, jsonElement)
-				results = append(results, result)
+const (
+	// maxJSONDepth limits the nesting of the arrays traversed for a metric
+	maxJSONDepth = 4
+	// maxJSONResults limits the results of a metric, since each result is a label set
+	maxJSONResults = 1000
+)
+
+// jsonExtraction collects the results of a metric from the elements of a JSON result
+type jsonExtraction struct {
+	key        string
+	labelNames []string
+	fields     bool
+	results    []map[string]interface{}
+	elements   int
+	scalars    int
+}
+
+// extractMetricValuesFromJSON reads the value and the labels of each element, with fields all fields of
+// the element are added as well, so the labels of the metric can be derived from them. Nested arrays are
+// traversed completely, warnings describe elements not matching the expected structure and an error is
+// returned, if the result is nested too deep or has too many elements.
+func (exporter *Exporter) extractMetricValuesFromJSON(jsonResult gjson.Result, key string, labelNames []string, fields bool) (results []map[string]interface{}, warnings []string, err error) {
+
+	if !jsonResult.IsArray() {
+		if jsonResult.Exists() {
+			result := make(map[string]interface{})
+			if key == "" {
+				key = "result"
+			}
+			result[key] = jsonValue(jsonResult)
+			exporter.getLabelValues(result, labelNames, jsonResult)
+			results = append(results, result)
+		}
+		return results, nil, nil
+	}
+
+	e := &jsonExtraction{key: key, labelNames: labelNames, fields: fields}
+	err = e.extractArray(exporter, jsonResult, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if e.scalars > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d array elements are no objects and were skipped", e.scalars))
+	}
+	if e.elements > 0 && len(e.results) == 0 && key != "" {
+		warnings = append(warnings, fmt.Sprintf("none of %d elements has the result key %s", e.elements, key))
+	}
+	return e.results, warnings, nil
+}
+
+func (e *jsonExtraction) extractArray(exporter *Exporter, jsonArray gjson.Result, depth int) error {
+
+	if depth > maxJSONDepth {
+		return fmt.Errorf("arrays nested deeper than %d levels", maxJSONDepth)
+	}
+
+	for _, jsonElement := range jsonArray.Array() {
+		if jsonElement.IsArray() {
+			err := e.extractArray(exporter, jsonElement, depth+1)
+			if err != nil {
+				return err
 			}
+			continue
+		}
+		if !jsonElement.IsObject() {
+			e.scalars++
+			continue
 		}
-	} else {
+
+		e.elements++
 		result := make(map[string]interface{})
-		if key == "" {
-			key = "result"
+		if e.key == "" {
+			result["result"] = 1
+		} else {
+			if !jsonElement.Get(e.key).Exists() {
+				continue
+			}
+			result[e.key] = jsonValue(jsonElement.Get(e.key))
+		}
+		exporter.getLabelValues(result, e.labelNames, jsonElement)
+		if e.fields {
+			addFields(result, jsonElement)
+		}
+
+		e.results = append(e.results, result)
+		if len(e.results) > maxJSONResults {
+			return fmt.Errorf("more than %d results, the labels would explode", maxJSONResults)
 		}
-		result[key] = jsonResult.Float()
-		exporter.getLabelValues(result, labelNames, jsonResult)
-		results = append(results, result)
 	}
-	return
+	return nil
+}
+
+// jsonValue keeps booleans and strings, so they can be compared with the okValue of a metric
+func jsonValue(jsonResult gjson.Result) interface{} {
+
+	switch jsonResult.Type {
+	case gjson.True, gjson.False:
+		return jsonResult.Bool()
+	case gjson.String:
+		if _, err := strconv.ParseFloat(jsonResult.Str, 64); err != nil {
+			return jsonResult.Str
+		}
+	}
+	return jsonResult.Float()
 }
 
 func (exporter *Exporter) getLabelValues(results map[string]interface{}, labelNames []string, jsonElement gjson.Result) {
 
 	for _, labelName := range labelNames {
-		labelValue := jsonElement.Get(labelName).String()
-		results[labelName] = labelValue
+		// missing labels are left nil for the missing label policy of the metric
+		labelValue := jsonElement.Get(labelName)
+		if !labelValue.Exists() {
+			results[labelName] = nil
+			continue
+		}
+		results[labelName] = labelValue.String()
 	}
 }
 
+// addFields adds the fields of the JSON object to the results, without replacing the value and labels already read
+func addFields(results map[string]interface{}, jsonElement gjson.Result) {
+
+	jsonElement.ForEach(func(key, value gjson.Result) bool {
+		if _, ok := results[key.String()]; !ok {
+			results[key.String()] = value.Value()
+		}
+		return true
+	})
+}
+
 // Collect metrics
 func (exporter *Exporter) Collect(metrics []*metric.Metric) (err error) {
+	return exporter.CollectWithContext(context.Background(), metrics)
+}
+
+// CollectWithContext collects the metrics and aborts as soon as ctx is done
+func (exporter *Exporter) CollectWithContext(ctx context.Context, metrics []*metric.Metric) (err error) {
 
-	err = exporter.logon()
+	err = exporter.logon(ctx)
 	if err != nil {
+		metric.CountError("lua", loginPath, "", err)
 		return err
 	}
 
+	// a failing page must not affect the others, e.g. a page renamed by a firmware update,
+	// the collection fails only if no page succeeded
+	var firstErr error
+	succeeded := 0
 	for _, m := range metrics {
 		// remove already collected metrics
 		m.MetricResult = nil
-
-		jsonResponse, err := exporter.request(m.Page)
+		m.Err = nil
+
+		start := time.Now()
+		jsonResponse, err := exporter.requestMetric(ctx, m)
+		if err == ErrSessionInvalid {
+			// the session expired or was terminated, so login again and retry once
+			exporter.SID = ""
+			err = exporter.logon(ctx)
+			if err != nil {
+				metric.CountError("lua", loginPath, "", err)
+				return err
+			}
+			jsonResponse, err = exporter.requestMetric(ctx, m)
+		}
+		exporter.setPageStatus(m.Page, err == nil)
+		m.Err = err
 		if err != nil {
-			return err
+			metric.CountError("lua", dataPath, m.Page, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("page %s: %v", m.Page, err)
+			}
+			if ctx.Err() != nil {
+				return firstErr
+			}
+			continue
 		}
+		succeeded++
+		exporter.Stages.Since(timing.Fetch, start)
 
+		start = time.Now()
 		jsonString := string(jsonResponse[:])
 		jsonResult := gjson.Get(jsonString, m.ResultPath)
-		m.MetricResult = exporter.extractMetricValuesFromJSON(jsonResult, m.ResultKey, m.PromDesc.VarLabels)
+		results, warnings, err := exporter.extractMetricValuesFromJSON(jsonResult, m.ResultKey, m.PromDesc.VarLabels, len(m.Labels) > 0)
+		exporter.Stages.Since(timing.Parse, start)
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s (page %s, path %s): %s\n", m.PromDesc.FqName, m.Page, m.ResultPath, warning)
+		}
+		if err != nil {
+			fmt.Printf("Warning: skipping %s (page %s, path %s): %v\n", m.PromDesc.FqName, m.Page, m.ResultPath, err)
+			metric.CountError("lua", dataPath, m.Page, metric.NewReasonError(metric.ReasonInvalidResponse, err))
+			continue
+		}
+		m.MetricResult = results
+	}
+	if succeeded > 0 {
+		return nil
+	}
+	return firstErr
+}
+
+// setPageStatus records the result of a request of the page
+func (exporter *Exporter) setPageStatus(page string, up bool) {
+
+	if exporter.Pages == nil {
+		exporter.Pages = make(map[string]*PageStatus)
+	}
+	status, ok := exporter.Pages[page]
+	if !ok {
+		status = &PageStatus{}
+		exporter.Pages[page] = status
+	}
+	status.Up = up
+	if up {
+		status.LastSuccess = time.Now()
 	}
+}
+
+// Login creates a session, if none exists yet
+func (exporter *Exporter) Login(ctx context.Context) error {
+	return exporter.logon(ctx)
+}
+
+// Invalidate drops the session, so the next collection logs in again
+func (exporter *Exporter) Invalidate() error {
+	exporter.SID = ""
 	return nil
 }
 
-func (exporter *Exporter) logon() error {
+func (exporter *Exporter) logon(ctx context.Context) error {
 
 	if exporter.SID == "" {
-		loginLUA, err := getSessionInfo(exporter.BaseURL + loginPath)
+		defer exporter.Stages.Since(timing.Auth, time.Now())
+
+		loginLUA, err := exporter.getSessionInfo(ctx, exporter.BaseURL+loginPath)
 		if err != nil {
 			return err
 		}
+		if loginLUA == nil {
+			return fmt.Errorf("invalid session info from %s", exporter.BaseURL+loginPath)
+		}
 
 		response := utf16leMd5(loginLUA.Challenge + "-" + exporter.Password)
 		responseString := fmt.Sprintf("%x", response)
 
-		sessionInfo, err := getSessionInfo(exporter.BaseURL + loginPath + "?response=" + loginLUA.Challenge + "-" + responseString + "&username=" + exporter.Username)
+		sessionInfo, err := exporter.getSessionInfo(ctx, exporter.BaseURL+loginPath+"?response="+loginLUA.Challenge+"-"+responseString+"&username="+exporter.Username)
 		if err != nil {
 			return err
 		}
+		if sessionInfo == nil || sessionInfo.SID == "" || sessionInfo.SID == invalidSID {
+			return metric.NewReasonError(metric.ReasonAuth, fmt.Errorf("login failed for user '%s'", exporter.Username))
+		}
 		exporter.SID = sessionInfo.SID
 	}
 	return nil
 }
 
-func getSessionInfo(gatewayURL string) (*sessionInfo, error) {
+func (exporter *Exporter) client() *http.Client {
 
-	response, err := http.Get(gatewayURL)
+	if exporter.Client != nil {
+		return exporter.Client
+	}
+	return httpclient.Default()
+}
+
+func (exporter *Exporter) getSessionInfo(ctx context.Context, gatewayURL string) (*sessionInfo, error) {
+
+	request, err := http.NewRequestWithContext(ctx, "GET", gatewayURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := exporter.client().Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -146,33 +355,133 @@ func utf16leMd5(s string) []byte {
 	return hasher.Sum(nil)
 }
 
-func (exporter *Exporter) request(page string) ([]byte, error) {
-
-	client := &http.Client{}
+// request posts to data.lua, params are additional POST parameters of the page (e.g. xhrId, lang, no_sidrenew)
+func (exporter *Exporter) request(ctx context.Context, page string, params map[string]string) ([]byte, error) {
 
 	parameters := url.Values{}
-	parameters.Add("sid", exporter.SID)
-	parameters.Add("page", page)
+	for key, value := range params {
+		parameters.Set(key, value)
+	}
+	parameters.Set("sid", exporter.SID)
+	parameters.Set("page", page)
 
-	request, err := http.NewRequest("POST", exporter.BaseURL+dataPath, strings.NewReader(parameters.Encode()))
+	request, err := http.NewRequestWithContext(httpclient.WithEndpoint(ctx, page), "POST", exporter.BaseURL+dataPath, strings.NewReader(parameters.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	response, err := client.Do(request)
+	response, err := exporter.client().Do(request)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusForbidden {
+		return nil, ErrSessionInvalid
+	}
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Lua request response not OK: %v", response.Status)
+		return nil, metric.NewReasonError(metric.ReasonHTTPStatus, fmt.Errorf("Lua request response not OK: %v", response.Status))
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
 	}
+
+	err = checkResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// requestMetric requests the page of the metric after its pre-requests, which set the UI state of the session
+// the page depends on
+func (exporter *Exporter) requestMetric(ctx context.Context, m *metric.Metric) ([]byte, error) {
+
+	for _, pre := range m.PreRequests {
+		_, err := exporter.request(ctx, pre.Page, pre.Params)
+		if err == ErrSessionInvalid {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pre-request of page %s: %w", pre.Page, err)
+		}
+	}
+	return exporter.request(ctx, m.Page, m.Params)
+}
+
+// HomeAutomation requests a command of the AHA-HTTP interface (e.g. getdevicelistinfos) with the session,
+// logging in again once if the session expired
+func (exporter *Exporter) HomeAutomation(ctx context.Context, command string) ([]byte, error) {
+
+	err := exporter.logon(ctx)
+	if err != nil {
+		metric.CountError("lua", loginPath, "", err)
+		return nil, err
+	}
+
+	body, err := exporter.requestHomeAutomation(ctx, command)
+	if err == ErrSessionInvalid {
+		exporter.SID = ""
+		err = exporter.logon(ctx)
+		if err != nil {
+			metric.CountError("lua", loginPath, "", err)
+			return nil, err
+		}
+		body, err = exporter.requestHomeAutomation(ctx, command)
+	}
+	if err != nil {
+		metric.CountError("lua", aha.Path, command, err)
+		return nil, err
+	}
 	return body, nil
 }
+
+func (exporter *Exporter) requestHomeAutomation(ctx context.Context, command string) ([]byte, error) {
+
+	parameters := url.Values{}
+	parameters.Set("switchcmd", command)
+	parameters.Set("sid", exporter.SID)
+
+	request, err := http.NewRequestWithContext(httpclient.WithEndpoint(ctx, command), "GET", exporter.BaseURL+aha.Path+"?"+parameters.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := exporter.client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusForbidden {
+		return nil, ErrSessionInvalid
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, metric.NewReasonError(metric.ReasonHTTPStatus, fmt.Errorf("AHA request response not OK: %v", response.Status))
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// checkResponse detects responses of data.lua, which are not the requested data although the status is OK
+func checkResponse(body []byte) error {
+
+	body = bytes.TrimSpace(body)
+	if !gjson.ValidBytes(body) {
+		// with an invalid session the box answers with the login page
+		if bytes.Contains(bytes.ToLower(body), []byte("login")) {
+			return ErrSessionInvalid
+		}
+		return metric.NewReasonError(metric.ReasonInvalidResponse, fmt.Errorf("invalid JSON response"))
+	}
+
+	if sid := gjson.GetBytes(body, "sid"); sid.Exists() && sid.String() == invalidSID {
+		return ErrSessionInvalid
+	}
+	if errorMessage := gjson.GetBytes(body, "error"); errorMessage.Exists() && errorMessage.String() != "" {
+		return metric.NewReasonError(metric.ReasonLuaError, fmt.Errorf("lua error: %s", errorMessage.String()))
+	}
+	return nil
+}
@@ -1,113 +1,554 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/namsral/flag"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/aexel90/fritzbox_exporter/capture"
+	"github.com/aexel90/fritzbox_exporter/chaos"
 	"github.com/aexel90/fritzbox_exporter/collector"
+	"github.com/aexel90/fritzbox_exporter/httpclient"
 	"github.com/aexel90/fritzbox_exporter/metric"
 	"github.com/aexel90/fritzbox_exporter/upnp"
 )
 
+// command is a subcommand of the exporter with its own flag set
+type command struct {
+	name        string
+	description string
+	flags       *flag.FlagSet
+	run         func() error
+}
+
+// options shared by several commands
 var (
-	flagGatewayUpnpURL = flag.String("gateway-upnp-url", "http://fritz.box:49000", "The URL of the FRITZ!Box - UPNP")
-	flagGatewayLuaURL  = flag.String("gateway-lua-url", "http://fritz.box", "The URL of the FRITZ!Box - LUA")
-	flagUsername       = flag.String("username", "", "The user for the FRITZ!Box UPnP service")
-	flagPassword       = flag.String("password", "", "The password for the FRITZ!Box")
-	flagAddress        = flag.String("listen-address", "127.0.0.1:9042", "The address to listen on for HTTP requests.")
+	flagGatewayUpnpURL string
+	flagGatewayLuaURL  string
+	flagGatewayLabel   string
+	flagUsername       string
+	flagPassword       string
+
+	flagUpnpTLSInsecure  bool
+	flagUpnpCAFile       string
+	flagInjectFailures   string
+	flagCaptureRecordDir string
+	flagCaptureReplayDir string
+
+	flagUpnpTLSMinVersion   string
+	flagUpnpTLSCipherSuites string
+
+	flagGatewayMaxConnectionAge   time.Duration
+	flagGatewayDNSResolveInterval time.Duration
+	flagGatewayMaxIdleConns       int
+	flagGatewayIdleConnTimeout    time.Duration
+	flagGatewayProxy              string
 
-	flagMetricsLuaFile  = flag.String("metrics-lua", "", "The JSON file with the lua metric definitions.")
-	flagMetricsUpnpFile = flag.String("metrics-upnp", "", "The JSON file with the upnp metric definitions.")
+	flagMetricsLuaFile  string
+	flagMetricsUpnpFile string
+	flagMetricsPacks    string
 
-	flagTest    = flag.Bool("test", false, "test configured metrics")
-	flagCollect = flag.Bool("collect-upnp", false, "If set ALL available upnp metrics will be collected")
+	flagNamingConventions bool
+	flagMaxSeries         int
+	flagLabelAllow        string
+	flagLabelDeny         string
 
-	flagResultFileLua     = flag.String("result-file-lua", "", "The JSON file where to store lua export results during test")
-	flagResultFileUpnp    = flag.String("result-file-upnp", "", "The JSON file where to store upnp export results during test")
-	flagResultFileUpnpAll = flag.String("result-file-upnp-all", "", "The JSON file where to store the result during collect")
+	flagUpnpLatencyThreshold time.Duration
+	flagUpnpWANUtilization   bool
+	flagUpnpHosts            bool
+	flagUpnpLoginEvents      bool
+	flagUpnpExternalIP       bool
+	flagUpnpServiceInventory bool
+	flagUpnpFirmwareUpdate   bool
+	flagUpnpWANReconnects    bool
+	flagUpnpPortMappings     bool
+	flagUpnpPortMappingItems bool
+	flagUpnpWLANClients      bool
+	flagUpnpWLANClientNames  bool
+	flagLuaThermostats       bool
+	flagLogCollections       bool
+
+	flagUpnpDiscoveryInterval  time.Duration
+	flagUpnpDiscoveryCacheFile string
+
+	flagOutputFormat string
 )
 
+var commands = map[string]*command{}
+
 func main() {
 
-	flag.Parse()
+	registerCommands()
 
-	var metricsFileLua *metric.MetricsFile
-	var metricsFileUpnp *metric.MetricsFile
-	var luaCollector *collector.Collector
-	var upnpCollector *collector.Collector
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name = args[0]
+		args = args[1:]
+	}
 
-	// upnp collect mode
-	if *flagCollect {
-		upnp.CollectAll(*flagGatewayUpnpURL, *flagUsername, *flagPassword, *flagResultFileUpnpAll)
+	if name == "help" {
+		usage()
 		return
 	}
 
-	// flagGatewayLuaURL
-	u, err := url.Parse(*flagGatewayLuaURL)
-	if err != nil {
-		fmt.Println("invalid URL:", err)
-		return
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Printf("unknown command: %s\n\n", name)
+		usage()
+		os.Exit(2)
 	}
 
-	// init LuaCollector
-	if *flagMetricsLuaFile != "" {
-		err := readAndParseFile(*flagMetricsLuaFile, &metricsFileLua)
-		if err != nil {
+	cmd.flags.Parse(args)
+	parseEnvAliases(cmd.flags)
+
+	err := readCredentialFiles()
+	if err != nil {
+		err = &exitError{exitFatal, err}
+	} else {
+		err = cmd.run()
+	}
+	if err != nil {
+		code := exitPartial
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		if err.Error() != "" {
 			fmt.Println(err)
+		}
+		os.Exit(code)
+	}
+}
+
+// exit codes of the commands
+const (
+	exitOK      = 0
+	exitPartial = 1
+	exitFatal   = 2
+)
+
+// exitError lets a command exit with a specific code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func registerCommands() {
+
+	registerServeCommand()
+	registerTestCommand()
+	registerDiscoverCommand()
+	registerCompareCommand()
+	registerValidateCommand()
+	registerUpgradeCommand()
+	registerGenerateCommand()
+	registerDashboardCommand()
+	registerRulesCommand()
+	registerRecordCommand()
+	registerSelftestCommand()
+	registerVersionCommand()
+}
+
+func newCommand(name string, description string, run func() error) *command {
+
+	cmd := &command{
+		name:        name,
+		description: description,
+		flags:       flag.NewFlagSet(name, flag.ExitOnError),
+		run:         run,
+	}
+	commands[name] = cmd
+	return cmd
+}
+
+func usage() {
+
+	fmt.Printf("Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %-10s %s\n", name, commands[name].description)
+	}
+	fmt.Printf("\nRun '%s <command> -h' for the flags of a command. Without command 'serve' is used.\n", os.Args[0])
+}
+
+// parseEnvAliases sets flags containing dots (e.g. web.read-timeout) from environment
+// variables with underscores (WEB_READ_TIMEOUT), since these cannot be set by the flag package itself
+func parseEnvAliases(fs *flag.FlagSet) {
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || !strings.Contains(f.Name, ".") {
 			return
 		}
-		luaCollector, err = collector.NewLuaCollector(metricsFileLua, *flagGatewayLuaURL, *flagUsername, *flagPassword, u.Hostname())
+		envKey := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(f.Name))
+		if value, ok := os.LookupEnv(envKey); ok {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				fmt.Printf("invalid value %q for environment variable %s: %v\n", value, envKey, err)
+				os.Exit(2)
+			}
+		}
+	})
+}
+
+func addGatewayFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagGatewayUpnpURL, "gateway-upnp-url", "http://fritz.box:49000", "The URL of the FRITZ!Box - UPNP")
+	fs.StringVar(&flagGatewayLuaURL, "gateway-lua-url", "http://fritz.box", "The URL of the FRITZ!Box - LUA")
+	fs.StringVar(&flagGatewayLabel, "gateway-label", "", "The value of the gateway label, device for the model name read from the FRITZ!Box (default: host name of the URL of each collector)")
+	fs.StringVar(&flagUsername, "username", "", "The user for the FRITZ!Box UPnP service")
+	fs.StringVar(&flagPassword, "password", "", "The password for the FRITZ!Box")
+	fs.StringVar(&flagUsernameFile, "username-file", "", "The file containing the user for the FRITZ!Box, e.g. a docker secret")
+	fs.StringVar(&flagPasswordFile, "password-file", "", "The file containing the password for the FRITZ!Box, e.g. a docker secret")
+	fs.BoolVar(&flagUpnpTLSInsecure, "upnp.tls-insecure", true, "Skip certificate validation for https connections to the FRITZ!Box, since it uses a self signed cert")
+	fs.StringVar(&flagUpnpCAFile, "upnp.ca-file", "", "The PEM file with the certificate / CA of the FRITZ!Box to validate https connections against")
+	fs.StringVar(&flagUpnpTLSMinVersion, "upnp.tls-min-version", "", "The minimum TLS version for https connections to the FRITZ!Box (1.0, 1.1, 1.2, 1.3)")
+	fs.StringVar(&flagUpnpTLSCipherSuites, "upnp.tls-cipher-suites", "", "Comma separated TLS cipher suites allowed for https connections to the FRITZ!Box (TLS 1.0 - 1.2 only)")
+	fs.DurationVar(&flagGatewayMaxConnectionAge, "gateway.max-connection-age", 0, "Close the kept-alive connections to the FRITZ!Box after this duration, so a changed address is picked up (0 = disabled).")
+	fs.DurationVar(&flagGatewayDNSResolveInterval, "gateway.dns-resolve-interval", time.Minute, "Resolve the FRITZ!Box host again after this interval and close the kept-alive connections if its address changed (0 = disabled).")
+	fs.IntVar(&flagGatewayMaxIdleConns, "gateway.max-idle-connections", 2, "The maximum number of kept-alive idle connections to the FRITZ!Box per exporter (upnp, lua).")
+	fs.DurationVar(&flagGatewayIdleConnTimeout, "gateway.idle-connection-timeout", 90*time.Second, "Close kept-alive connections to the FRITZ!Box unused for this duration.")
+	fs.StringVar(&flagGatewayProxy, "gateway.proxy", "", "The URL of the HTTP proxy for the connections to the FRITZ!Box (default: HTTP_PROXY / NO_PROXY environment).")
+	fs.StringVar(&flagCaptureRecordDir, "capture.record-dir", "", "Store the raw responses of the FRITZ!Box in this directory with passwords, keys and session ids redacted, e.g. to attach them to bug reports about parsing failures.")
+	fs.StringVar(&flagCaptureReplayDir, "capture.replay-dir", "", "Answer the requests to the FRITZ!Box with the responses stored by -capture.record-dir instead of connecting to it.")
+	fs.StringVar(&flagInjectFailures, "inject-failures", "", "Randomly inject failures into the requests to the FRITZ!Box for testing, e.g. timeout:0.05,soapfault:0.02 (kinds: timeout, error, soapfault, unauthorized)")
+	addTargetAllowFlags(fs)
+}
+
+func addOutputFlag(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagOutputFormat, "output", "text", "The output format: text or json")
+}
+
+func validateOutputFlag() error {
+
+	if flagOutputFormat != "text" && flagOutputFormat != "json" {
+		return &exitError{exitFatal, fmt.Errorf("invalid output format %q, expected text or json", flagOutputFormat)}
+	}
+	return nil
+}
+
+func addMetricsFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagMetricsLuaFile, "metrics-lua", "", "The JSON files with the lua metric definitions, a comma separated list of files and glob patterns which are merged.")
+	fs.StringVar(&flagMetricsUpnpFile, "metrics-upnp", "", "The JSON files with the upnp metric definitions, a comma separated list of files and glob patterns which are merged.")
+	fs.StringVar(&flagMetricsPacks, "metrics.packs", "auto", "The embedded metric packs to enable: auto (detected from the model if no metric files are given), none or a comma separated list of "+strings.Join(packNames, ","))
+	fs.BoolVar(&flagNamingConventions, "metrics.naming-conventions", false, "Rename metrics to follow the prometheus naming conventions (unit suffix, _total suffix for counters).")
+	fs.IntVar(&flagMaxSeries, "metrics.max-series", 0, "Export at most this many series per metric and collection, the others are counted in fritzbox_metrics_dropped_total (0 = unlimited).")
+	fs.StringVar(&flagLabelAllow, "metrics.label-allow", "", "Comma separated label=regex matchers, series with such a label are only exported if its value matches, e.g. mac=AA:BB:CC:.*")
+	fs.StringVar(&flagLabelDeny, "metrics.label-deny", "", "Comma separated label=regex matchers, series with a matching label value are not exported.")
+	fs.BoolVar(&flagUpnpWANUtilization, "upnp.wan-utilization", false, "Export fritzbox_wan_utilization_ratio derived from the WAN byte counters and the link capacity.")
+	fs.BoolVar(&flagUpnpHosts, "upnp.hosts", false, "Export the host inventory (fritzbox_host_active per host), opt-in since label cardinality can be large.")
+	fs.BoolVar(&flagUpnpLoginEvents, "upnp.login-events", false, "Export failed logins and active user interface sessions found in the event log of the FRITZ!Box.")
+	fs.BoolVar(&flagUpnpExternalIP, "upnp.external-ip", false, "Export the external IPv4/IPv6 address (fritzbox_external_ip_info) and count its changes (fritzbox_external_ip_changes_total).")
+	fs.BoolVar(&flagUpnpWANReconnects, "upnp.wan-reconnects", false, "Export the uptime of the WAN connection (fritzbox_wan_connection_uptime_seconds) and count its reconnects (fritzbox_wan_reconnects_total).")
+	fs.BoolVar(&flagUpnpPortMappings, "upnp.port-mappings", false, "Export the number of enabled port mappings of the WAN connection (fritzbox_port_mappings), e.g. to detect unexpected UPnP port openings.")
+	fs.BoolVar(&flagUpnpPortMappingItems, "upnp.port-mapping-entries", false, "Export fritzbox_port_mapping_enabled per port mapping (protocol, external port, internal client and port, description), implies -upnp.port-mappings.")
+	fs.BoolVar(&flagUpnpWLANClients, "upnp.wlan-clients", false, "Export link speed, signal strength and association state per WLAN client, opt-in since label cardinality can be large.")
+	fs.BoolVar(&flagUpnpWLANClientNames, "upnp.wlan-client-names", false, "Label the WLAN clients of -upnp.wlan-clients with their host name from the host table (a request per client).")
+	fs.BoolVar(&flagUpnpFirmwareUpdate, "upnp.firmware-update", false, "Export fritzbox_firmware_update_available, 1 if AVM offers a firmware update for the FRITZ!Box (checked hourly).")
+	fs.BoolVar(&flagUpnpServiceInventory, "upnp.service-inventory", false, "Export fritzbox_upnp_service_info per discovered upnp service, e.g. for inventory dashboards of several FRITZ!Boxes.")
+	fs.BoolVar(&flagLuaThermostats, "lua.thermostats", false, "Export the state of the thermostats (FRITZ!DECT 301, Comet DECT) read via AHA-HTTP: temperatures, valve, open window, holiday mode and battery.")
+	fs.DurationVar(&flagUpnpDiscoveryInterval, "upnp.discovery-interval", 0, "Discover the upnp services of the FRITZ!Box again after this interval, e.g. to pick up firmware updates (0 = only after unknown service errors).")
+	fs.StringVar(&flagUpnpDiscoveryCacheFile, "upnp.discovery-cache", "", "The JSON file where to persist the discovered upnp services, so a restart needs no discovery.")
+	fs.BoolVar(&flagLogCollections, "log.collections", true, "Log a summary of each collection round (duration, HTTP calls, cache hits, series, errors).")
+	fs.StringVar(&flagReplayFile, "replay.file", "", "Serve the results recorded with the record command from this file instead of requesting the FRITZ!Box.")
+	fs.DurationVar(&flagUpnpLatencyThreshold, "upnp.latency-threshold", 0, "Skip high cost upnp metrics while the average request latency exceeds this threshold (0 = disabled).")
+	addCollectorGroupFlags(fs)
+}
+
+// target describes the connection to a single FRITZ!Box
+type target struct {
+	// name is the gateway label, gatewayLabelDevice for the model name of the box or the host name of the URL
+	// of each collector if empty
+	name     string
+	upnpURL  string
+	luaURL   string
+	username string
+	password string
+}
+
+// defaultTarget is the FRITZ!Box configured via the gateway flags
+func defaultTarget() target {
+	return target{name: flagGatewayLabel, upnpURL: flagGatewayUpnpURL, luaURL: flagGatewayLuaURL, username: flagUsername, password: flagPassword}
+}
+
+// newCollectors initializes the collectors for the configured metric files
+func newCollectors() (luaCollector *collector.Collector, upnpCollector *collector.Collector, err error) {
+	return newCollectorsFor(defaultTarget())
+}
+
+// newCollectorsFor initializes the collectors for the configured metric files and the given target
+func newCollectorsFor(t target) (luaCollector *collector.Collector, upnpCollector *collector.Collector, err error) {
+
+	// the credentials must only be sent to allowed hosts, e.g. for gateways found on the LAN
+	err = checkTarget(t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metricsFileLua *metric.MetricsFile
+	var metricsFileUpnp *metric.MetricsFile
+
+	// lua and upnp use transports of their own, so their connections are pooled independently
+	luaClient, err := newGatewayHTTPClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	upnpClient, err := newGatewayHTTPClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []collector.Option{
+		collector.WithNamingConventions(flagNamingConventions),
+		collector.WithCollectInterval(flagCollectInterval),
+		collector.WithRoundLog(flagLogCollections),
+		collector.WithSeriesLimit(flagMaxSeries),
+		collector.WithLabelFilter(flagLabelAllow, flagLabelDeny),
+	}
+	if webhookRules != nil {
+		opts = append(opts, collector.WithAfterCollect(webhookRules.evaluate))
+	}
+	if counterStore != nil {
+		opts = append(opts, collector.WithCounterStore(counterStore))
+	}
+
+	metricsFileLua, metricsFileUpnp, err = loadMetricsFiles(t, upnpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the thermostats need the session of a lua collector, even without lua metrics
+	if metricsFileLua == nil && flagLuaThermostats && t.luaURL != "" {
+		metricsFileLua = &metric.MetricsFile{}
+	}
+
+	name := t.name
+	if name == gatewayLabelDevice {
+		name = deviceLabel(t, upnpClient)
+	}
+	luaGateway, err := gatewayLabel(name, t.luaURL, t.upnpURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	upnpGateway, err := gatewayLabel(name, t.upnpURL, t.luaURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if flagReplayFile != "" {
+		replayCollector, err := newReplayCollector(metricsFileLua, metricsFileUpnp, luaGateway, opts)
+		return nil, replayCollector, err
+	}
+
+	// init LuaCollector, exporting fritzbox_device_up only if there is no upnp collector for the box
+	if metricsFileLua != nil {
+		luaOpts := append(opts[:len(opts):len(opts)], collector.WithHTTPClient(luaClient), collector.WithDeviceUp(metricsFileUpnp == nil),
+			collector.WithThermostats(flagLuaThermostats))
+		luaCollector, err = collector.NewLuaCollector(metricsFileLua, t.luaURL, t.username, t.password, luaGateway, luaOpts...)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return nil, nil, err
 		}
 	}
 
 	// init UpnpCollector
-	if *flagMetricsUpnpFile != "" {
-		err := readAndParseFile(*flagMetricsUpnpFile, &metricsFileUpnp)
+	if metricsFileUpnp != nil {
+		upnpOpts := append(opts[:len(opts):len(opts)], collector.WithHTTPClient(upnpClient), collector.WithDeviceUp(true),
+			collector.WithLatencyThreshold(flagUpnpLatencyThreshold),
+			collector.WithWANUtilization(flagUpnpWANUtilization), collector.WithHosts(flagUpnpHosts),
+			collector.WithLoginEvents(flagUpnpLoginEvents), collector.WithExternalIP(flagUpnpExternalIP),
+			collector.WithServiceInventory(flagUpnpServiceInventory), collector.WithFirmwareUpdate(flagUpnpFirmwareUpdate), collector.WithWANReconnects(flagUpnpWANReconnects),
+			collector.WithPortMappings(flagUpnpPortMappings, flagUpnpPortMappingItems), collector.WithWLANClients(flagUpnpWLANClients, flagUpnpWLANClientNames),
+			collector.WithDiscoveryInterval(flagUpnpDiscoveryInterval), collector.WithDiscoveryCacheFile(flagUpnpDiscoveryCacheFile))
+		upnpCollector, err = collector.NewUpnpCollector(metricsFileUpnp, t.upnpURL, t.username, t.password, upnpGateway, upnpOpts...)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return nil, nil, err
 		}
-		upnpCollector, err = collector.NewUpnpCollector(metricsFileUpnp, *flagGatewayUpnpURL, *flagUsername, *flagPassword, u.Hostname())
+	}
+	return luaCollector, upnpCollector, nil
+}
+
+// gatewayLabelDevice as gateway label resolves to the model name of the box
+const gatewayLabelDevice = "device"
+
+// gatewayLabel is the configured name, or the host name of the URL of the collector (of the other URL if it is
+// not configured, e.g. upnp only)
+func gatewayLabel(name string, collectorURL string, otherURL string) (string, error) {
+
+	if name != "" {
+		return name, nil
+	}
+	for _, rawURL := range []string{collectorURL, otherURL} {
+		u, err := url.Parse(rawURL)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return "", fmt.Errorf("invalid URL: %v", err)
+		}
+		if u.Hostname() != "" {
+			return u.Hostname(), nil
+		}
+	}
+	return "", nil
+}
+
+// deviceLabel reads the model name of the box via DeviceInfo:1#GetInfo, empty if it is unavailable
+func deviceLabel(t target, client *http.Client) string {
+
+	if t.upnpURL == "" {
+		return ""
+	}
+	exporter := &upnp.Exporter{BaseURL: t.upnpURL, Username: t.username, Password: t.password, Client: client,
+		DiscoveryCacheFile: flagUpnpDiscoveryCacheFile}
+	err := exporter.LoadServices()
+	if err != nil {
+		fmt.Println("Error: reading gateway label: ", err)
+		return ""
+	}
+	info, err := exporter.Call(context.Background(), "urn:dslforum-org:service:DeviceInfo:1", "GetInfo")
+	if err != nil {
+		fmt.Println("Error: reading gateway label: ", err)
+		return ""
+	}
+	model, _ := info["ModelName"].(string)
+	return model
+}
+
+// loadMetricsFiles reads the configured metric files and packs of the target without the disabled groups,
+// nil if there are no metrics of the exporter type
+func loadMetricsFiles(t target, client *http.Client) (metricsFileLua *metric.MetricsFile, metricsFileUpnp *metric.MetricsFile, err error) {
+
+	packs, err := selectPacks(t, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if flagMetricsLuaFile != "" {
+		metricsFileLua, err = metric.ReadFiles(flagMetricsLuaFile)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+	err = loadPacks(packs, "lua", &metricsFileLua)
+	if err != nil {
+		return nil, nil, err
+	}
+	filterGroups(metricsFileLua)
 
-	// test mode
-	if *flagTest {
-		if luaCollector != nil {
-			luaCollector.Test(*flagResultFileLua)
+	if flagMetricsUpnpFile != "" {
+		metricsFileUpnp, err = metric.ReadFiles(flagMetricsUpnpFile)
+		if err != nil {
+			return nil, nil, err
 		}
-		if upnpCollector != nil {
-			upnpCollector.Test(*flagResultFileUpnp)
+	}
+	err = loadPacks(packs, "upnp", &metricsFileUpnp)
+	if err != nil {
+		return nil, nil, err
+	}
+	filterGroups(metricsFileUpnp)
+	return metricsFileLua, metricsFileUpnp, nil
+}
+
+// configuredMetrics returns the upnp and lua metrics configured for the default target, named as served
+func configuredMetrics() ([]*metric.Metric, error) {
+
+	client, err := newGatewayHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// the packs are only detected from the box with -metrics.packs auto and without metric files
+	metricsFileLua, metricsFileUpnp, err := loadMetricsFiles(defaultTarget(), client)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []*metric.Metric{}
+	for _, metricsFile := range []*metric.MetricsFile{metricsFileUpnp, metricsFileLua} {
+		if metricsFile != nil {
+			metrics = append(metrics, metricsFile.Metrics...)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("no metrics configured")
+	}
+	if flagNamingConventions {
+		for _, m := range metrics {
+			m.PromDesc.FqName = m.ConventionalName()
 		}
-		return
 	}
+	return metrics, nil
+}
+
+// newGatewayHTTPClient creates a client with a transport of its own for the connections to the FRITZ!Box
+func newGatewayHTTPClient() (*http.Client, error) {
 
-	// prometheus mode
-	if luaCollector != nil {
-		prometheus.MustRegister(luaCollector)
+	tlsConfig, err := newTLSConfig(flagUpnpTLSMinVersion, flagUpnpTLSCipherSuites)
+	if err != nil {
+		return nil, err
 	}
-	if upnpCollector != nil {
-		prometheus.MustRegister(upnpCollector)
+
+	client, err := httpclient.New(httpclient.Config{
+		TLS:                tlsConfig,
+		Insecure:           flagUpnpTLSInsecure,
+		CAFile:             flagUpnpCAFile,
+		Proxy:              flagGatewayProxy,
+		MaxIdleConns:       flagGatewayMaxIdleConns,
+		IdleConnTimeout:    flagGatewayIdleConnTimeout,
+		MaxConnectionAge:   flagGatewayMaxConnectionAge,
+		DNSResolveInterval: flagGatewayDNSResolveInterval,
+		CheckAddress:       checkTargetAddress,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	fmt.Printf("metrics available at http://%s/metrics\n", *flagAddress)
-	log.Fatal(http.ListenAndServe(*flagAddress, nil))
+	switch {
+	case flagCaptureReplayDir != "":
+		client.Transport = &capture.Replayer{Dir: flagCaptureReplayDir}
+	case flagCaptureRecordDir != "":
+		client.Transport = &capture.Recorder{Next: client.Transport, Dir: flagCaptureRecordDir}
+	}
 
+	if flagInjectFailures != "" {
+		failures, err := chaos.ParseFailures(flagInjectFailures)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("injecting failures: %v\n", failures)
+		client.Transport = &chaos.Transport{Next: client.Transport, Failures: failures}
+	}
+	return client, nil
 }
 
 func readAndParseFile(file string, v interface{}) error {
@@ -0,0 +1,75 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons of collection errors
+const (
+	ReasonAuth            = "auth"
+	ReasonTimeout         = "timeout"
+	ReasonNetwork         = "network"
+	ReasonHTTPStatus      = "http_status"
+	ReasonUnknownService  = "unknown_service"
+	ReasonUnknownAction   = "unknown_action"
+	ReasonSoapFault       = "soap_fault"
+	ReasonLuaError        = "lua_error"
+	ReasonInvalidResponse = "invalid_response"
+	ReasonMissingResult   = "missing_result"
+	ReasonOther           = "other"
+)
+
+// CollectErrors counts the failed requests of the upnp and lua collectors
+var CollectErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "fritzbox_exporter_collect_errors",
+	Help: "Number of collection errors by collector (upnp, lua), service, action (lua: page) and reason.",
+}, []string{"collector", "service", "action", "reason"})
+
+func init() {
+	prometheus.MustRegister(CollectErrors)
+}
+
+// ReasonError is an error with the reason counted by CollectErrors
+type ReasonError struct {
+	Reason string
+	Err    error
+}
+
+// NewReasonError wraps the error with the reason
+func NewReasonError(reason string, err error) error {
+	return &ReasonError{Reason: reason, Err: err}
+}
+
+func (e *ReasonError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReasonError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorReason classifies the error, timeouts take precedence over the reason of a ReasonError
+func ErrorReason(err error) string {
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ReasonTimeout
+	}
+	var reasonErr *ReasonError
+	if errors.As(err, &reasonErr) {
+		return reasonErr.Reason
+	}
+	if netErr != nil {
+		return ReasonNetwork
+	}
+	return ReasonOther
+}
+
+// CountError counts the error of the collector for the service and action
+func CountError(collector string, service string, action string, err error) {
+	CollectErrors.WithLabelValues(collector, service, action, ErrorReason(err)).Inc()
+}
@@ -0,0 +1,23 @@
+package metric
+
+// Groups are the known metric groups with their description, the metrics of a group can be switched
+// off with -collector.<group>=false, metrics without group are always collected
+var Groups = map[string]string{
+	"cable":     "DOCSIS channels of cable boxes",
+	"device":    "uptime and model of the device",
+	"dsl":       "DSL line status, data rates and errors",
+	"energy":    "energy consumption",
+	"hosts":     "host table, expensive on boxes with many hosts",
+	"lan":       "LAN interface statistics",
+	"lte":       "signal of the mobile connection",
+	"parental":  "blocked hosts and online time of the access profiles (parental controls)",
+	"powerline": "FRITZ!Powerline devices",
+	"smarthome": "smart home devices",
+	"storage":   "USB storage volumes and FRITZ!NAS",
+	"system":    "CPU, memory and temperature",
+	"telephony": "DECT handsets, deflections, call list and VoIP registration",
+	"tr069":     "TR-069 management server (ACS) of ISP managed boxes",
+	"vpn":       "VPN tunnels (WireGuard, IPSec) and connected VPN users",
+	"wan":       "WAN traffic, link properties and connection status",
+	"wlan":      "WLAN settings, associations and statistics",
+}
@@ -2,8 +2,20 @@ package metric
 
 import (
 	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aexel90/fritzbox_exporter/expr"
+)
+
+// policies for results without a label value
+const (
+	MissingLabelEmpty = "empty"
+	MissingLabelSkip  = "skip"
+	MissingLabelError = "error"
 )
 
 type PrometheusResult struct {
@@ -11,6 +23,8 @@ type PrometheusResult struct {
 	PromValueType prometheus.ValueType
 	Value         float64
 	LabelValues   []string
+	// Created is the start time of a counter, if known
+	Created time.Time
 }
 
 // PromDesc  struct
@@ -18,7 +32,7 @@ type PromDesc struct {
 	FqName      string            `json:"fqName"`
 	Help        string            `json:"help"`
 	VarLabels   []string          `json:"varLabels"`
-	FixedLabels map[string]string `json:"fixedLabels"`
+	FixedLabels map[string]string `json:"fixedLabels,omitempty"`
 }
 
 type ActionArg struct {
@@ -26,38 +40,135 @@ type ActionArg struct {
 	IsIndex        bool   `json:"IsIndex"`
 	ProviderAction string `json:"ProviderAction"`
 	Value          string `json:"Value"`
+	// IndexStart is the first index of the iteration (default 0)
+	IndexStart int `json:"IndexStart,omitempty"`
+	// IndexStep is the increment between two indexes (default 1)
+	IndexStep int `json:"IndexStep,omitempty"`
+	// StopOnError ends the iteration at the first failing index
+	StopOnError bool `json:"StopOnError,omitempty"`
+}
+
+// PreRequest is a lua page requested to set UI state of the session, its response is discarded
+type PreRequest struct {
+	Page   string            `json:"page"`
+	Params map[string]string `json:"params,omitempty"`
 }
 
 // Metric struct
 type Metric struct {
-	PromDesc       PromDesc   `json:"promDesc"`
-	PromType       string     `json:"promType"`
-	ResultKey      string     `json:"resultKey"`
-	OkValue        string     `json:"okValue"`
-	ResultPath     string     `json:"resultPath"`
-	Page           string     `json:"page"`
-	Service        string     `json:"service"`
-	Action         string     `json:"action"`
-	ActionArgument *ActionArg `json:"actionArgument"`
-
-	Desc        *prometheus.Desc
-	Type        prometheus.ValueType
-	Value       float64
+	PromDesc   PromDesc          `json:"promDesc"`
+	PromType   string            `json:"promType"`
+	Unit       string            `json:"unit,omitempty"`
+	Group      string            `json:"group,omitempty"`
+	ResultKey  string            `json:"resultKey,omitempty"`
+	OkValue    string            `json:"okValue,omitempty"`
+	ResultPath string            `json:"resultPath,omitempty"`
+	Page       string            `json:"page,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	// PreRequests are lua pages requested before the page in the same session, e.g. to select the WLAN band
+	// or the statistics interval the page reports
+	PreRequests    []PreRequest `json:"preRequests,omitempty"`
+	Service        string       `json:"service,omitempty"`
+	Action         string       `json:"action,omitempty"`
+	ActionArgument *ActionArg   `json:"actionArgument,omitempty"`
+	ListSeparator  string       `json:"listSeparator,omitempty"`
+	ListURLKey     string       `json:"listUrlKey,omitempty"`
+	// ListKey is a result containing the list document itself instead of its URL (e.g. NewDeflectionList)
+	ListKey     string `json:"listKey,omitempty"`
+	ListElement string `json:"listElement,omitempty"`
+	// ListFormat of the list document: xml (default, one entry per listElement) or csv (header line with the field names)
+	ListFormat string             `json:"listFormat,omitempty"`
+	DedupKey   string             `json:"dedupKey,omitempty"`
+	Derive     string             `json:"derive,omitempty"`
+	Aggregate  string             `json:"aggregate,omitempty"`
+	HighCost   bool               `json:"highCost,omitempty"`
+	Transform  string             `json:"transform,omitempty"`
+	ValueMap   map[string]float64 `json:"valueMap,omitempty"`
+	StateSet   bool               `json:"stateSet,omitempty"`
+	// Min and Max are sanity bounds, samples outside are dropped instead of exported (e.g. 2^64-1 during a resync)
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Precision rounds the values to this number of decimal places (default: unrounded)
+	Precision *int `json:"precision,omitempty"`
+	// SplitWords exports integer results as two series with word="high" (value / 2^32) and word="low"
+	// (value % 2^32), so counters above 2^53 stay exact although prometheus values are float64
+	SplitWords bool `json:"splitWords,omitempty"`
+	// Monotonic accumulates the counter across resets and 32 bit wraps of the box, so it never decreases
+	Monotonic bool `json:"monotonic,omitempty"`
+	// Extend32BitCounter extends a 32 bit counter of the box (e.g. TotalBytesSent of ui4 type) to 64 bit by accumulating
+	// its wraps at 4 GiB, resets of the box still restart the counter
+	Extend32BitCounter bool `json:"extend32BitCounter,omitempty"`
+	// CacheTTL serves the results of the last collection until they are older than this duration (e.g. "10m"),
+	// so expensive results like the host list are not fetched on every scrape
+	CacheTTL string `json:"cacheTTL,omitempty"`
+	// Labels reads the values of var labels from a gjson path (e.g. details.name) or
+	// a go template combining several results (e.g. {{.vendor}} {{.model}}) instead of the result of the same name
+	Labels map[string]string `json:"labels,omitempty"`
+	// MissingLabel is the policy for results without a label value: empty (default) exports an empty label value,
+	// skip drops the result and error fails the metric
+	MissingLabel string `json:"missingLabel,omitempty"`
+
+	TransformExpr  *expr.Expression              `json:"-"`
+	CacheDuration  time.Duration                 `json:"-"`
+	LabelTemplates map[string]*template.Template `json:"-"`
+
+	Desc        *prometheus.Desc     `json:"-"`
+	Type        prometheus.ValueType `json:"-"`
+	Value       float64              `json:"-"`
 	labelValues []string
 
-	MetricResult []map[string]interface{} //filled during collect
-	PromResult   []*PrometheusResult
+	MetricResult []map[string]interface{} `json:",omitempty"` //filled during collect
+	Err          error                    `json:"-"`          //failed requests of the collect, MetricResult may hold partial results
+	ResultTypes  map[string]string        `json:"-"`          //data types of the results if known by the exporter, e.g. ui4 of UPnP state variables
+	PromResult   []*PrometheusResult      `json:",omitempty"`
+}
+
+// LabelNames returns the lower case label names of the metric, including the state label of state sets
+func (m *Metric) LabelNames() []string {
+
+	labels := make([]string, len(m.PromDesc.VarLabels))
+	for i, l := range m.PromDesc.VarLabels {
+		labels[i] = strings.ToLower(l)
+	}
+	if m.StateSet {
+		labels = append(labels, "state")
+	}
+	if m.SplitWords {
+		labels = append(labels, "word")
+	}
+	return labels
+}
+
+// VarLabelNames returns the label names without gateway, which is a constant label of the collectors
+func (m *Metric) VarLabelNames() []string {
+
+	labels := []string{}
+	for _, l := range m.LabelNames() {
+		if l != "gateway" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// IsLabelTemplate reports whether the label source is a go template instead of a gjson path
+func IsLabelTemplate(source string) bool {
+	return strings.Contains(source, "{{")
 }
 
 // LabelRename struct
 type LabelRename struct {
-	MatchRegex  string `json:"matchRegex"`
-	RenameLabel string `json:"renameLabel"`
-	Pattern     regexp.Regexp
+	MatchRegex  string        `json:"matchRegex"`
+	RenameLabel string        `json:"renameLabel"`
+	Pattern     regexp.Regexp `json:"-"`
 }
 
 // MetricsFile struct
 type MetricsFile struct {
-	LabelRenames []*LabelRename `json:"labelRenames"`
-	Metrics      []*Metric      `json:"metrics"`
+	// SchemaVersion is the format of the file the metrics were read from (0 or 1 = this flat format, see ReadFile)
+	SchemaVersion int            `json:"schemaVersion,omitempty"`
+	LabelRenames  []*LabelRename `json:"labelRenames,omitempty"`
+	// ExtraLabels are attached as constant labels to every metric of the collector (e.g. site, device_role)
+	ExtraLabels map[string]string `json:"extraLabels,omitempty"`
+	Metrics     []*Metric         `json:"metrics"`
 }
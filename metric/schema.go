@@ -0,0 +1,300 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SchemaVersion is the current version of the metric definition format
+const SchemaVersion = 2
+
+// MetricsFileV2 is the metric definition format of schema version 2. Metrics are grouped, a group
+// holds the settings shared by its metrics, and files can include other files (e.g. shared definitions).
+type MetricsFileV2 struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Includes are the paths of further metric files, relative to this file
+	Includes     []string          `json:"includes,omitempty"`
+	LabelRenames []*LabelRename    `json:"labelRenames,omitempty"`
+	ExtraLabels  map[string]string `json:"extraLabels,omitempty"`
+	Groups       []*MetricGroup    `json:"groups,omitempty"`
+	// Metrics are the metrics without group
+	Metrics []*Metric `json:"metrics,omitempty"`
+}
+
+// MetricGroup is a group of metrics with the defaults of its metrics
+type MetricGroup struct {
+	Name     string    `json:"name"`
+	Service  string    `json:"service,omitempty"`
+	Page     string    `json:"page,omitempty"`
+	CacheTTL string    `json:"cacheTTL,omitempty"`
+	HighCost bool      `json:"highCost,omitempty"`
+	Metrics  []*Metric `json:"metrics"`
+}
+
+// ReadFile reads a metric file of any schema version, version 2 files are flattened
+// with their includes into the format of version 1
+func ReadFile(file string) (*MetricsFile, error) {
+	return readFile(file, map[string]bool{})
+}
+
+func readFile(file string, seen map[string]bool) (*MetricsFile, error) {
+
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if seen[path] {
+		return nil, fmt.Errorf("%s is included recursively", file)
+	}
+	seen[path] = true
+	defer delete(seen, path)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metric file: %v", err)
+	}
+	metricsFile, includes, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		included, err := readFile(include, seen)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", include, err)
+		}
+		metricsFile.merge(included)
+	}
+	return metricsFile, nil
+}
+
+// ExpandFiles returns the metric files of a comma separated list of files and glob patterns
+// (e.g. base.json,custom/*.json), the matches of a pattern in lexical order
+func ExpandFiles(list string) ([]string, error) {
+
+	files := []string{}
+	seen := map[string]bool{}
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no metric file matches %s", pattern)
+			}
+		}
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ReadFiles reads and merges the metric files of a comma separated list of files and glob patterns,
+// e.g. community maintained base definitions and custom metrics
+func ReadFiles(list string) (*MetricsFile, error) {
+
+	files, err := ExpandFiles(list)
+	if err != nil {
+		return nil, err
+	}
+	metricsFiles := []*MetricsFile{}
+	for _, file := range files {
+		metricsFile, err := ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		metricsFiles = append(metricsFiles, metricsFile)
+	}
+	merged, errs := Merge(files, metricsFiles)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return merged, nil
+}
+
+// Merge merges the metric files in the order given, the extra labels of earlier files take precedence.
+// A metric name defined in several files is reported as error, since the definitions would collide.
+func Merge(files []string, metricsFiles []*MetricsFile) (*MetricsFile, []error) {
+
+	if len(metricsFiles) == 1 {
+		return metricsFiles[0], nil
+	}
+
+	var errs []error
+	merged := &MetricsFile{}
+	definedIn := map[string]string{}
+	for i, metricsFile := range metricsFiles {
+		names := map[string]bool{}
+		for _, m := range metricsFile.Metrics {
+			name := m.PromDesc.FqName
+			if other, ok := definedIn[name]; ok && !names[name] {
+				errs = append(errs, fmt.Errorf("metric %s of %s is already defined in %s", name, files[i], other))
+			}
+			names[name] = true
+		}
+		for name := range names {
+			if _, ok := definedIn[name]; !ok {
+				definedIn[name] = files[i]
+			}
+		}
+		merged.merge(metricsFile)
+	}
+	return merged, errs
+}
+
+// Parse parses metric definitions of any schema version without includes, e.g. embedded ones
+func Parse(data []byte) (*MetricsFile, error) {
+
+	metricsFile, includes, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(includes) > 0 {
+		return nil, fmt.Errorf("includes are only supported in metric files")
+	}
+	return metricsFile, nil
+}
+
+func parse(data []byte) (*MetricsFile, []string, error) {
+
+	var version struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	err := json.Unmarshal(data, &version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing JSON: %v", err)
+	}
+
+	switch version.SchemaVersion {
+	case 0, 1:
+		var metricsFile *MetricsFile
+		err = json.Unmarshal(data, &metricsFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing JSON: %v", err)
+		}
+		return metricsFile, nil, nil
+	case 2:
+		var v2 MetricsFileV2
+		err = json.Unmarshal(data, &v2)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing JSON: %v", err)
+		}
+		metricsFile, err := v2.flatten()
+		return metricsFile, v2.Includes, err
+	default:
+		return nil, nil, fmt.Errorf("schema version %d is not supported, this exporter supports up to version %d", version.SchemaVersion, SchemaVersion)
+	}
+}
+
+// flatten applies the group settings to the metrics of the groups
+func (v2 *MetricsFileV2) flatten() (*MetricsFile, error) {
+
+	metricsFile := &MetricsFile{SchemaVersion: 2, LabelRenames: v2.LabelRenames, ExtraLabels: v2.ExtraLabels}
+	metricsFile.Metrics = append(metricsFile.Metrics, v2.Metrics...)
+	for _, g := range v2.Groups {
+		for _, m := range g.Metrics {
+			if m.Group != "" && m.Group != g.Name {
+				return nil, fmt.Errorf("metric %s of group '%s' declares group '%s'", m.PromDesc.FqName, g.Name, m.Group)
+			}
+			m.Group = g.Name
+			if m.Service == "" {
+				m.Service = g.Service
+			}
+			if m.Page == "" {
+				m.Page = g.Page
+			}
+			if m.CacheTTL == "" {
+				m.CacheTTL = g.CacheTTL
+			}
+			m.HighCost = m.HighCost || g.HighCost
+			metricsFile.Metrics = append(metricsFile.Metrics, m)
+		}
+	}
+	return metricsFile, nil
+}
+
+// merge adds the metrics and label renames of the included file, the extra labels of the file itself take precedence
+func (metricsFile *MetricsFile) merge(included *MetricsFile) {
+
+	metricsFile.Metrics = append(metricsFile.Metrics, included.Metrics...)
+	metricsFile.LabelRenames = append(metricsFile.LabelRenames, included.LabelRenames...)
+	for name, value := range included.ExtraLabels {
+		if _, ok := metricsFile.ExtraLabels[name]; ok {
+			continue
+		}
+		if metricsFile.ExtraLabels == nil {
+			metricsFile.ExtraLabels = make(map[string]string)
+		}
+		metricsFile.ExtraLabels[name] = value
+	}
+}
+
+// Upgrade converts the metrics to the format of the current schema version. The metrics are grouped in
+// the order of their first appearance, settings shared by all metrics of a group move from the metrics to the group.
+func (metricsFile *MetricsFile) Upgrade() *MetricsFileV2 {
+
+	v2 := &MetricsFileV2{SchemaVersion: SchemaVersion, LabelRenames: metricsFile.LabelRenames, ExtraLabels: metricsFile.ExtraLabels}
+	groups := map[string]*MetricGroup{}
+	for _, m := range metricsFile.Metrics {
+		if m.Group == "" {
+			v2.Metrics = append(v2.Metrics, m)
+			continue
+		}
+		g, ok := groups[m.Group]
+		if !ok {
+			g = &MetricGroup{Name: m.Group}
+			groups[m.Group] = g
+			v2.Groups = append(v2.Groups, g)
+		}
+		g.Metrics = append(g.Metrics, m)
+	}
+
+	for _, g := range v2.Groups {
+		first := g.Metrics[0]
+		g.Service, g.Page, g.CacheTTL, g.HighCost = first.Service, first.Page, first.CacheTTL, first.HighCost
+		for _, m := range g.Metrics {
+			if m.Service != g.Service {
+				g.Service = ""
+			}
+			if m.Page != g.Page {
+				g.Page = ""
+			}
+			if m.CacheTTL != g.CacheTTL {
+				g.CacheTTL = ""
+			}
+			g.HighCost = g.HighCost && m.HighCost
+		}
+		for _, m := range g.Metrics {
+			m.Group = ""
+			if g.Service != "" {
+				m.Service = ""
+			}
+			if g.Page != "" {
+				m.Page = ""
+			}
+			if g.CacheTTL != "" {
+				m.CacheTTL = ""
+			}
+			if g.HighCost {
+				m.HighCost = false
+			}
+		}
+	}
+	return v2
+}
@@ -0,0 +1,234 @@
+package metric
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/expr"
+)
+
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	promTypes = map[string]bool{"": true, "CounterValue": true, "GaugeValue": true, "UntypedValue": true}
+)
+
+// Validate checks the metric definitions of a file for the given exporter type ("lua" or "upnp")
+func (metricsFile *MetricsFile) Validate(exporterType string) []error {
+
+	var errs []error
+
+	for _, rename := range metricsFile.LabelRenames {
+		_, err := regexp.Compile(rename.MatchRegex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("labelRename '%s': %v", rename.MatchRegex, err))
+		}
+	}
+
+	for label := range metricsFile.ExtraLabels {
+		if !labelNameRegex.MatchString(label) || label == "gateway" {
+			errs = append(errs, fmt.Errorf("invalid extra label name '%s'", label))
+		}
+	}
+
+	for i, m := range metricsFile.Metrics {
+		for name := range metricsFile.ExtraLabels {
+			if containsLabel(m.VarLabelNames(), name) {
+				errs = append(errs, NewMetricError(i, m, fmt.Errorf("extra label '%s' is a var label", name)))
+			}
+		}
+		for _, err := range m.validate(exporterType) {
+			errs = append(errs, NewMetricError(i, m, err))
+		}
+	}
+	return errs
+}
+
+// MetricError is a problem of the metric definition with the index in the metric file
+type MetricError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+// NewMetricError wraps the error of the metric with the index in the metric file
+func NewMetricError(index int, m *Metric, err error) error {
+	return &MetricError{Index: index, Name: m.PromDesc.FqName, Err: err}
+}
+
+func (e *MetricError) Error() string {
+	return fmt.Sprintf("metric #%d (%s): %v", e.Index, e.Name, e.Err)
+}
+
+func (e *MetricError) Unwrap() error {
+	return e.Err
+}
+
+func (m *Metric) validate(exporterType string) []error {
+
+	var errs []error
+
+	if !metricNameRegex.MatchString(m.PromDesc.FqName) {
+		errs = append(errs, fmt.Errorf("invalid fqName '%s'", m.PromDesc.FqName))
+	}
+	for _, label := range m.PromDesc.VarLabels {
+		if !labelNameRegex.MatchString(strings.ToLower(label)) {
+			errs = append(errs, fmt.Errorf("invalid label name '%s'", label))
+		}
+	}
+	for label := range m.PromDesc.FixedLabels {
+		if !labelNameRegex.MatchString(label) {
+			errs = append(errs, fmt.Errorf("invalid fixed label name '%s'", label))
+		}
+	}
+	if m.Unit != "" && !labelNameRegex.MatchString(m.Unit) {
+		errs = append(errs, fmt.Errorf("invalid unit '%s'", m.Unit))
+	}
+	if m.Transform != "" {
+		if _, err := expr.Parse(m.Transform); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for label, source := range m.Labels {
+		if !containsLabel(m.PromDesc.VarLabels, label) {
+			errs = append(errs, fmt.Errorf("labels: '%s' is no var label", label))
+		}
+		if IsLabelTemplate(source) {
+			if _, err := template.New(label).Parse(source); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if m.CacheTTL != "" {
+		if ttl, err := time.ParseDuration(m.CacheTTL); err != nil || ttl <= 0 {
+			errs = append(errs, fmt.Errorf("invalid cacheTTL '%s'", m.CacheTTL))
+		}
+	}
+	if m.Min != nil && m.Max != nil && *m.Min > *m.Max {
+		errs = append(errs, fmt.Errorf("min %v is greater than max %v", *m.Min, *m.Max))
+	}
+	if m.Precision != nil && (*m.Precision < 0 || *m.Precision > 15) {
+		errs = append(errs, fmt.Errorf("precision must be between 0 and 15"))
+	}
+	if m.SplitWords && (m.StateSet || m.Transform != "" || m.Derive != "" || m.Aggregate != "") {
+		errs = append(errs, fmt.Errorf("splitWords can't be combined with stateSet, transform, derive or aggregate"))
+	}
+	if m.Monotonic && m.PromType != "CounterValue" {
+		errs = append(errs, fmt.Errorf("monotonic metrics must be of promType CounterValue"))
+	}
+	if m.Monotonic && (m.StateSet || m.SplitWords || m.Derive != "") {
+		errs = append(errs, fmt.Errorf("monotonic can't be combined with stateSet, splitWords or derive"))
+	}
+	if m.Extend32BitCounter && m.PromType != "CounterValue" {
+		errs = append(errs, fmt.Errorf("extend32BitCounter metrics must be of promType CounterValue"))
+	}
+	if m.Extend32BitCounter && (m.Monotonic || m.StateSet || m.SplitWords || m.Derive != "") {
+		errs = append(errs, fmt.Errorf("extend32BitCounter can't be combined with monotonic, stateSet, splitWords or derive"))
+	}
+	if m.StateSet && len(m.ValueMap) == 0 {
+		errs = append(errs, fmt.Errorf("stateSet requires a valueMap"))
+	}
+	if _, ok := Groups[m.Group]; m.Group != "" && !ok {
+		errs = append(errs, fmt.Errorf("unknown group '%s'", m.Group))
+	}
+	if !promTypes[m.PromType] {
+		errs = append(errs, fmt.Errorf("unknown promType '%s'", m.PromType))
+	}
+	if m.Aggregate != "" && m.Aggregate != "count" && m.Aggregate != "sum" {
+		errs = append(errs, fmt.Errorf("unknown aggregate '%s'", m.Aggregate))
+	}
+
+	if m.MissingLabel != "" && m.MissingLabel != MissingLabelEmpty && m.MissingLabel != MissingLabelSkip && m.MissingLabel != MissingLabelError {
+		errs = append(errs, fmt.Errorf("unknown missingLabel '%s'", m.MissingLabel))
+	}
+
+	if m.Derive != "" && m.Derive != "rate" {
+		errs = append(errs, fmt.Errorf("unknown derive '%s'", m.Derive))
+	}
+	if m.Derive != "" && m.PromType != "GaugeValue" {
+		errs = append(errs, fmt.Errorf("derived metrics must be of promType GaugeValue"))
+	}
+
+	switch exporterType {
+	case "lua":
+		if m.Page == "" {
+			errs = append(errs, fmt.Errorf("page missing"))
+		}
+		for i, pre := range m.PreRequests {
+			if pre.Page == "" {
+				errs = append(errs, fmt.Errorf("page of preRequest #%d missing", i))
+			}
+		}
+	case "upnp":
+		if m.Service == "" || m.Action == "" {
+			errs = append(errs, fmt.Errorf("service or action missing"))
+		}
+		if len(m.Params) > 0 {
+			errs = append(errs, fmt.Errorf("params are only supported for lua pages"))
+		}
+		if len(m.PreRequests) > 0 {
+			errs = append(errs, fmt.Errorf("preRequests are only supported for lua pages"))
+		}
+		if m.ActionArgument != nil && m.ActionArgument.Name == "" {
+			errs = append(errs, fmt.Errorf("actionArgument name missing"))
+		}
+		if m.ActionArgument != nil && m.ActionArgument.IndexStep < 0 {
+			errs = append(errs, fmt.Errorf("actionArgument IndexStep must not be negative"))
+		}
+		if m.ListURLKey != "" && m.ListKey != "" {
+			errs = append(errs, fmt.Errorf("listUrlKey and listKey must not be set together"))
+		}
+		if m.ListFormat != "" && m.ListFormat != "xml" && m.ListFormat != "csv" {
+			errs = append(errs, fmt.Errorf("unknown listFormat '%s'", m.ListFormat))
+		}
+		if m.ListFormat != "csv" {
+			if m.ListURLKey != "" && m.ListElement == "" {
+				errs = append(errs, fmt.Errorf("listElement missing for listUrlKey '%s'", m.ListURLKey))
+			}
+			if m.ListKey != "" && m.ListElement == "" {
+				errs = append(errs, fmt.Errorf("listElement missing for listKey '%s'", m.ListKey))
+			}
+		}
+	}
+	return errs
+}
+
+// ConventionalName returns the name of the metric following the prometheus naming conventions:
+// base unit as suffix and _total suffix for counters
+func (m *Metric) ConventionalName() string {
+
+	name := strings.TrimSuffix(m.PromDesc.FqName, "_total")
+	if m.Unit != "" && !strings.HasSuffix(name, "_"+m.Unit) {
+		name += "_" + m.Unit
+	}
+	if m.PromType == "CounterValue" {
+		name += "_total"
+	}
+	return name
+}
+
+// NamingWarnings reports metrics whose names don't match their declared type and unit
+func (metricsFile *MetricsFile) NamingWarnings() []string {
+
+	var warnings []string
+	for i, m := range metricsFile.Metrics {
+		if name := m.ConventionalName(); name != m.PromDesc.FqName {
+			warnings = append(warnings, fmt.Sprintf("metric #%d (%s): name should be '%s' for type %s and unit '%s'", i, m.PromDesc.FqName, name, m.PromType, m.Unit))
+		}
+	}
+	return warnings
+}
+
+func containsLabel(labels []string, label string) bool {
+
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricsHandler serves the metrics, to scrapers requesting OpenMetrics with the # UNIT lines of the
+// metric definitions and the _created samples of counters. With collect[] query parameters only the
+// metrics of these groups are collected and served.
+func metricsHandler(set *collectorSet) http.Handler {
+
+	textHandler := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+		handler := textHandler
+		if groups := r.URL.Query()["collect[]"]; len(groups) > 0 {
+			registry, err := set.registryOf(groups)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			gatherer = registry
+			handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		families, err := gatherer.Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		units := set.units()
+		for _, family := range families {
+			if unit, ok := units[family.GetName()]; ok {
+				family.Unit = &unit
+			}
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		encoder := expfmt.NewEncoder(w, format, expfmt.WithCreatedLines(), expfmt.WithUnit())
+		for _, family := range families {
+			err = encoder.Encode(family)
+			if err != nil {
+				fmt.Println("Error: encoding metrics: ", err)
+				return
+			}
+		}
+		if closer, ok := encoder.(expfmt.Closer); ok {
+			closer.Close()
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+// packFiles holds the embedded metric packs, named <pack>-<exporter type>.json
+//
+//go:embed packs/*.json
+var packFiles embed.FS
+
+// packNames lists the available metric packs
+var packNames = []string{"base", "router", "dsl", "cable", "lte", "repeater", "telephony", "smarthome", "energy", "system", "vpn", "tr069", "storage", "ipv6", "powerline", "parental"}
+
+const (
+	wanCommonService    = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+	onTelService        = "urn:dslforum-org:service:X_AVM-DE_OnTel:1"
+	homeautoService     = "urn:dslforum-org:service:X_AVM-DE_Homeauto:1"
+	remoteAccessService = "urn:dslforum-org:service:X_AVM-DE_RemoteAccess:1"
+	managementService   = "urn:dslforum-org:service:ManagementServer:1"
+	storageService      = "urn:dslforum-org:service:X_AVM-DE_Storage:1"
+	wanIPService        = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	homeplugService     = "urn:dslforum-org:service:X_AVM-DE_Homeplug:1"
+	hostFilterService   = "urn:dslforum-org:service:X_AVM-DE_HostFilter:1"
+)
+
+// selectPacks returns the metric packs to enable for the target, detecting them if configured to auto
+func selectPacks(t target, client *http.Client) ([]string, error) {
+
+	switch flagMetricsPacks {
+	case "", "none":
+		return nil, nil
+	case "auto":
+		// metric files given explicitly replace the automatic selection
+		if flagMetricsLuaFile != "" || flagMetricsUpnpFile != "" {
+			return nil, nil
+		}
+		if flagReplayFile != "" {
+			return nil, fmt.Errorf("metric packs can't be detected from a recording, pass -metrics.packs or metric files")
+		}
+		packs, err := detectPacks(t, client)
+		if err != nil {
+			return nil, fmt.Errorf("detecting metric packs: %v", err)
+		}
+		fmt.Printf("enabled metric packs for %s: %s\n", t.upnpURL, strings.Join(packs, ","))
+		return packs, nil
+	}
+
+	packs := strings.Split(flagMetricsPacks, ",")
+	for i, p := range packs {
+		packs[i] = strings.TrimSpace(p)
+		if !isPack(packs[i]) {
+			return nil, fmt.Errorf("unknown metric pack %q, expected one of %s", packs[i], strings.Join(packNames, ","))
+		}
+	}
+	return packs, nil
+}
+
+// detectPacks selects the metric packs by the model name, the WAN access type and the services of the FRITZ!Box
+func detectPacks(t target, client *http.Client) ([]string, error) {
+
+	exporter := &upnp.Exporter{BaseURL: t.upnpURL, Username: t.username, Password: t.password, Client: client,
+		DiscoveryCacheFile: flagUpnpDiscoveryCacheFile}
+	err := exporter.LoadServices()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	info, err := exporter.Call(ctx, "urn:dslforum-org:service:DeviceInfo:1", "GetInfo")
+	if err != nil {
+		return nil, err
+	}
+	model, _ := info["ModelName"].(string)
+
+	var accessType string
+	if _, ok := exporter.Services[wanCommonService]; ok {
+		link, err := exporter.Call(ctx, wanCommonService, "GetCommonLinkProperties")
+		if err != nil {
+			return nil, err
+		}
+		accessType, _ = link["WANAccessType"].(string)
+	}
+
+	packs := []string{"base"}
+	switch {
+	case strings.Contains(model, "Repeater"):
+		packs = append(packs, "repeater")
+	case accessType != "":
+		packs = append(packs, "router", "energy", "system")
+	}
+	switch {
+	case accessType == "DSL":
+		packs = append(packs, "dsl")
+	case strings.Contains(accessType, "Cable") || strings.Contains(model, "Cable"):
+		packs = append(packs, "cable")
+	case strings.Contains(accessType, "LTE") || strings.Contains(accessType, "UMTS") ||
+		strings.Contains(model, "LTE") || strings.Contains(model, "5G"):
+		packs = append(packs, "lte")
+	}
+	if _, ok := exporter.Services[onTelService]; ok {
+		packs = append(packs, "telephony")
+	}
+	if _, ok := exporter.Services[homeautoService]; ok {
+		packs = append(packs, "smarthome")
+	}
+	if _, ok := exporter.Services[remoteAccessService]; ok {
+		packs = append(packs, "vpn")
+	}
+	if _, ok := exporter.Services[storageService]; ok {
+		packs = append(packs, "storage")
+	}
+	if _, ok := exporter.Services[homeplugService]; ok {
+		packs = append(packs, "powerline")
+	}
+	if _, ok := exporter.Services[hostFilterService]; ok {
+		packs = append(packs, "parental")
+	}
+	if service, ok := exporter.Services[wanIPService]; ok {
+		if _, ok := service.Actions["X_AVM_DE_GetIPv6Prefix"]; ok {
+			packs = append(packs, "ipv6")
+		}
+	}
+	// only boxes managed by the ISP have an ACS configured
+	if _, ok := exporter.Services[managementService]; ok {
+		management, err := exporter.Call(ctx, managementService, "GetInfo")
+		if err != nil {
+			return nil, err
+		}
+		if acsURL, _ := management["URL"].(string); acsURL != "" {
+			packs = append(packs, "tr069")
+		}
+	}
+	return packs, nil
+}
+
+func isPack(name string) bool {
+
+	for _, p := range packNames {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPacks merges the metrics of the packs for the exporter type (lua or upnp) into the metrics file,
+// which is created if it is nil and any pack has metrics of that type
+func loadPacks(packs []string, exporterType string, metricsFile **metric.MetricsFile) error {
+
+	for _, p := range packs {
+		data, err := packFiles.ReadFile("packs/" + p + "-" + exporterType + ".json")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var pack metric.MetricsFile
+		err = json.Unmarshal(data, &pack)
+		if err != nil {
+			return fmt.Errorf("error parsing metric pack %s: %v", p, err)
+		}
+		if *metricsFile == nil {
+			*metricsFile = &metric.MetricsFile{}
+		}
+		(*metricsFile).Metrics = append((*metricsFile).Metrics, pack.Metrics...)
+		(*metricsFile).LabelRenames = append((*metricsFile).LabelRenames, pack.LabelRenames...)
+	}
+	return nil
+}
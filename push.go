@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/namsral/flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/aexel90/fritzbox_exporter/remotewrite"
+)
+
+const pushTimeout = 30 * time.Second
+
+var (
+	flagPushURL      string
+	flagPushMode     string
+	flagPushInterval time.Duration
+	flagPushJob      string
+)
+
+func addPushFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagPushURL, "push.url", "", "The URL of the Pushgateway or Prometheus remote write endpoint to push the metrics to, empty to disable. Basic auth credentials can be part of the URL.")
+	fs.StringVar(&flagPushMode, "push.mode", "pushgateway", "The protocol of the push URL: pushgateway or remote-write")
+	fs.DurationVar(&flagPushInterval, "push.interval", 60*time.Second, "The interval of the pushes.")
+	fs.StringVar(&flagPushJob, "push.job", "fritzbox_exporter", "The job label of the pushed metrics.")
+}
+
+func validatePushFlags() error {
+
+	if flagPushURL != "" && flagPushMode != "pushgateway" && flagPushMode != "remote-write" {
+		return fmt.Errorf("invalid push mode %q, expected pushgateway or remote-write", flagPushMode)
+	}
+	return nil
+}
+
+// pushMetrics gathers the metrics served on /metrics and pushes them every interval until ctx is done
+func pushMetrics(ctx context.Context) {
+
+	client := &http.Client{Timeout: pushTimeout}
+	pusher := push.New(flagPushURL, flagPushJob).Gatherer(prometheus.DefaultGatherer).Client(client)
+
+	ticker := time.NewTicker(flagPushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		if flagPushMode == "remote-write" {
+			err = pushRemoteWrite(ctx, client)
+		} else {
+			err = pusher.PushContext(ctx)
+		}
+		if err != nil {
+			fmt.Println("Error: push failed: ", err)
+		}
+	}
+}
+
+func pushRemoteWrite(ctx context.Context, client *http.Client) error {
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		// the families gathered despite errors are pushed anyway, like /metrics serves them
+		fmt.Println("Error: ", err)
+	}
+	return remotewrite.Push(ctx, client, flagPushURL, flagPushJob, families, time.Now())
+}
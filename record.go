@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+	"github.com/aexel90/fritzbox_exporter/fixture"
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+var (
+	flagRecordOutput string
+	flagReplayFile   string
+)
+
+func registerRecordCommand() {
+
+	cmd := newCommand("record", "record the results of the configured metrics for -replay.file", record)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	cmd.flags.StringVar(&flagRecordOutput, "output", "fritzbox-recording.json", "The JSON file where to store the recorded results")
+}
+
+func record() error {
+
+	if flagReplayFile != "" {
+		return fmt.Errorf("-replay.file can't be recorded again")
+	}
+
+	luaCollector, upnpCollector, err := newCollectors()
+	if err != nil {
+		return err
+	}
+
+	recording := &fixture.Recording{}
+	for _, c := range []*collector.Collector{luaCollector, upnpCollector} {
+		if c == nil {
+			continue
+		}
+		err = c.Record(context.Background(), recording)
+		if err != nil {
+			fmt.Println("Error: ", err)
+		}
+	}
+	err = recording.Save(flagRecordOutput)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("recorded %d results to %s\n", len(recording.Results), flagRecordOutput)
+	return nil
+}
+
+// newReplayCollector creates a collector of the lua and upnp metrics serving the results of the recording
+func newReplayCollector(metricsFileLua *metric.MetricsFile, metricsFileUpnp *metric.MetricsFile, gateway string, opts []collector.Option) (*collector.Collector, error) {
+
+	recording, err := fixture.Load(flagReplayFile)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsFile := &metric.MetricsFile{}
+	for _, f := range []*metric.MetricsFile{metricsFileLua, metricsFileUpnp} {
+		if f == nil {
+			continue
+		}
+		metricsFile.Metrics = append(metricsFile.Metrics, f.Metrics...)
+		metricsFile.LabelRenames = append(metricsFile.LabelRenames, f.LabelRenames...)
+		for name, value := range f.ExtraLabels {
+			if metricsFile.ExtraLabels == nil {
+				metricsFile.ExtraLabels = make(map[string]string)
+			}
+			metricsFile.ExtraLabels[name] = value
+		}
+	}
+	opts = append(opts[:len(opts):len(opts)], collector.WithDeviceUp(true))
+	return collector.NewCollector(metricsFile, &fixture.Exporter{Recording: recording}, "file", gateway, opts...)
+}
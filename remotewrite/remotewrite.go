@@ -0,0 +1,178 @@
+// Package remotewrite sends metric families to a Prometheus remote write endpoint. The WriteRequest is
+// encoded directly with protowire and snappy framed as literals only, so no further dependencies are needed.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// label is a label of a time series
+type label struct {
+	name  string
+	value string
+}
+
+// series is a time series with a single sample
+type series struct {
+	labels []label
+	value  float64
+}
+
+// Push sends the metric families as samples of the time now to the remote write URL,
+// the job label is added to series without one (optional)
+func Push(ctx context.Context, client *http.Client, url string, job string, families []*dto.MetricFamily, now time.Time) error {
+
+	body := snappyEncode(encodeWriteRequest(toSeries(families, job), now.UnixNano()/int64(time.Millisecond)))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("remote write response not OK: %v %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// toSeries converts the metric families into time series, histograms and summaries are split into
+// their _bucket / quantile, _sum and _count series like in the text exposition
+func toSeries(families []*dto.MetricFamily, job string) []series {
+
+	var all []series
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.Metric {
+			labels := []label{}
+			hasJob := false
+			for _, pair := range m.Label {
+				labels = append(labels, label{pair.GetName(), pair.GetValue()})
+				hasJob = hasJob || pair.GetName() == "job"
+			}
+			if job != "" && !hasJob {
+				labels = append(labels, label{"job", job})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				all = append(all, newSeries(name, labels, m.Counter.GetValue()))
+			case dto.MetricType_GAUGE:
+				all = append(all, newSeries(name, labels, m.Gauge.GetValue()))
+			case dto.MetricType_UNTYPED:
+				all = append(all, newSeries(name, labels, m.Untyped.GetValue()))
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.Summary.Quantile {
+					all = append(all, newSeries(name, withLabel(labels, "quantile", formatFloat(q.GetQuantile())), q.GetValue()))
+				}
+				all = append(all, newSeries(name+"_sum", labels, m.Summary.GetSampleSum()))
+				all = append(all, newSeries(name+"_count", labels, float64(m.Summary.GetSampleCount())))
+			case dto.MetricType_HISTOGRAM:
+				infBucket := false
+				for _, b := range m.Histogram.Bucket {
+					all = append(all, newSeries(name+"_bucket", withLabel(labels, "le", formatFloat(b.GetUpperBound())), float64(b.GetCumulativeCount())))
+					infBucket = math.IsInf(b.GetUpperBound(), 1)
+				}
+				if !infBucket {
+					all = append(all, newSeries(name+"_bucket", withLabel(labels, "le", "+Inf"), float64(m.Histogram.GetSampleCount())))
+				}
+				all = append(all, newSeries(name+"_sum", labels, m.Histogram.GetSampleSum()))
+				all = append(all, newSeries(name+"_count", labels, float64(m.Histogram.GetSampleCount())))
+			}
+		}
+	}
+	return all
+}
+
+// newSeries adds the metric name and sorts the labels, as required by the remote write protocol
+func newSeries(name string, labels []label, value float64) series {
+
+	all := append([]label{{"__name__", name}}, labels...)
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return series{labels: all, value: value}
+}
+
+func withLabel(labels []label, name string, value string) []label {
+	return append(labels[:len(labels):len(labels)], label{name, value})
+}
+
+func formatFloat(f float64) string {
+
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the prometheus.WriteRequest protobuf message:
+// WriteRequest{1: repeated TimeSeries}, TimeSeries{1: repeated Label, 2: repeated Sample},
+// Label{1: name, 2: value}, Sample{1: double value, 2: int64 timestamp}
+func encodeWriteRequest(all []series, timestamp int64) []byte {
+
+	var request []byte
+	for _, s := range all {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
+
+// maxLiteral is the longest literal written with a two byte length
+const maxLiteral = 1 << 16
+
+// snappyEncode encodes the data in the snappy block format without compression, as a sequence of literals
+func snappyEncode(data []byte) []byte {
+
+	encoded := protowire.AppendVarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		// literal tag 61: the length - 1 follows in two bytes, little endian
+		encoded = append(encoded, 61<<2, byte(n-1), byte((n-1)>>8))
+		encoded = append(encoded, data[:n]...)
+		data = data[n:]
+	}
+	return encoded
+}
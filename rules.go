@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aexel90/fritzbox_exporter/rules"
+)
+
+var (
+	flagRulesOutput     string
+	flagRulesThresholds rules.Thresholds
+)
+
+func registerRulesCommand() {
+
+	cmd := newCommand("rules", "generate prometheus alerting rules for the configured metrics", generateRules)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	cmd.flags.StringVar(&flagRulesOutput, "output", "", "The YAML file where to store the generated rules (default stdout)")
+	cmd.flags.IntVar(&flagRulesThresholds.DSLResyncs, "rules.dsl-resyncs", 3, "Alert if the DSL link resyncs more often within an hour.")
+	cmd.flags.Float64Var(&flagRulesThresholds.CRCErrors, "rules.crc-errors", 100, "Alert if the DSL link has more CRC errors per minute.")
+	cmd.flags.IntVar(&flagRulesThresholds.LoginFailures, "rules.login-failures", 5, "Alert if more logins fail within 15 minutes (needs -upnp.login-events).")
+}
+
+func generateRules() error {
+
+	metrics, err := configuredMetrics()
+	if err != nil {
+		return err
+	}
+
+	// every collector exports the device and scrape status
+	extras := []string{"fritzbox_device_up", "fritzbox_scrape_success"}
+	if flagUpnpLoginEvents {
+		extras = append(extras, "fritzbox_login_failures_total")
+	}
+	if flagUpnpFirmwareUpdate {
+		extras = append(extras, "fritzbox_firmware_update_available")
+	}
+
+	ruleFile := rules.Format(rules.Generate(metrics, extras, flagRulesThresholds))
+	if flagRulesOutput == "" {
+		fmt.Print(ruleFile)
+		return nil
+	}
+	return ioutil.WriteFile(flagRulesOutput, []byte(ruleFile), 0644)
+}
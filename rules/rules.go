@@ -0,0 +1,151 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// Thresholds parameterize the generated alerts
+type Thresholds struct {
+	// DSLResyncs per hour
+	DSLResyncs int
+	// CRCErrors per minute
+	CRCErrors float64
+	// LoginFailures within 15 minutes
+	LoginFailures int
+}
+
+// Rule is a prometheus alerting rule
+type Rule struct {
+	Alert    string
+	Expr     string
+	For      string
+	Severity string
+	Summary  string
+}
+
+// Generate returns the alerting rules for the metrics, the metrics are found by their source
+// (service and result), so renamed metrics are covered as well. Extras are the names of the
+// enabled metrics of the exporter itself (e.g. fritzbox_device_up).
+func Generate(metrics []*metric.Metric, extras []string, thresholds Thresholds) []*Rule {
+
+	rules := []*Rule{}
+	if contains(extras, "fritzbox_device_up") {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxDown",
+			Expr:     "fritzbox_device_up == 0",
+			For:      "5m",
+			Severity: "critical",
+			Summary:  "The FRITZ!Box {{ $labels.gateway }} is not reachable by the exporter",
+		})
+	}
+	if contains(extras, "fritzbox_scrape_success") {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxScrapeFailed",
+			Expr:     "fritzbox_scrape_success == 0",
+			For:      "10m",
+			Severity: "warning",
+			Summary:  "All metrics of the FRITZ!Box {{ $labels.gateway }} fail to be collected",
+		})
+	}
+
+	for _, name := range names(metrics, isWANStatus) {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxWANDown",
+			Expr:     fmt.Sprintf("%s == 0", name),
+			For:      "5m",
+			Severity: "critical",
+			Summary:  "The WAN connection of the FRITZ!Box {{ $labels.gateway }} is down",
+		})
+	}
+	// a resync is seen as two changes of the link status (down and up again)
+	for _, name := range names(metrics, isDSLStatus) {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxDSLResyncs",
+			Expr:     fmt.Sprintf("changes(%s[1h]) / 2 > %d", name, thresholds.DSLResyncs),
+			Severity: "warning",
+			Summary:  "The DSL link of the FRITZ!Box {{ $labels.gateway }} resynced more than " + strconv.Itoa(thresholds.DSLResyncs) + " times within an hour",
+		})
+	}
+	for _, name := range names(metrics, isCRCErrors) {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxDSLCRCErrors",
+			Expr:     fmt.Sprintf("rate(%s[10m]) * 60 > %v", name, thresholds.CRCErrors),
+			For:      "15m",
+			Severity: "warning",
+			Summary:  "The DSL link of the FRITZ!Box {{ $labels.gateway }} has more than " + strconv.FormatFloat(thresholds.CRCErrors, 'f', -1, 64) + " CRC errors per minute ({{ $labels.direction }})",
+		})
+	}
+	if contains(extras, "fritzbox_login_failures_total") {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxLoginFailures",
+			Expr:     fmt.Sprintf("increase(fritzbox_login_failures_total[15m]) > %d", thresholds.LoginFailures),
+			Severity: "warning",
+			Summary:  "More than " + strconv.Itoa(thresholds.LoginFailures) + " failed logins to the FRITZ!Box {{ $labels.gateway }} within 15 minutes",
+		})
+	}
+	if contains(extras, "fritzbox_firmware_update_available") {
+		rules = append(rules, &Rule{
+			Alert:    "FritzBoxFirmwareUpdate",
+			Expr:     "fritzbox_firmware_update_available == 1",
+			Severity: "info",
+			Summary:  "FRITZ!OS {{ $labels.new_version }} is available for the FRITZ!Box {{ $labels.gateway }} (running {{ $labels.current_version }})",
+		})
+	}
+	return rules
+}
+
+// Format formats the rules as prometheus rules file in YAML
+func Format(rules []*Rule) string {
+
+	var b strings.Builder
+	b.WriteString("groups:\n  - name: fritzbox\n    rules:\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "      - alert: %s\n", r.Alert)
+		fmt.Fprintf(&b, "        expr: %s\n", strconv.Quote(r.Expr))
+		if r.For != "" {
+			fmt.Fprintf(&b, "        for: %s\n", r.For)
+		}
+		fmt.Fprintf(&b, "        labels:\n          severity: %s\n", r.Severity)
+		fmt.Fprintf(&b, "        annotations:\n          summary: %s\n", strconv.Quote(r.Summary))
+	}
+	return b.String()
+}
+
+func isWANStatus(m *metric.Metric) bool {
+	return (strings.Contains(m.Service, "WANIPConnection") || strings.Contains(m.Service, "WANPPPConnection")) &&
+		m.ResultKey == "ConnectionStatus" && m.OkValue != ""
+}
+
+func isDSLStatus(m *metric.Metric) bool {
+	return strings.Contains(m.Service, "WANDSLInterfaceConfig") && m.ResultKey == "Status" && m.OkValue != ""
+}
+
+func isCRCErrors(m *metric.Metric) bool {
+	return strings.Contains(m.ResultKey, "CRCErrors") && m.PromType == "CounterValue"
+}
+
+// names returns the distinct names of the metrics matching
+func names(metrics []*metric.Metric, match func(*metric.Metric) bool) []string {
+
+	result := []string{}
+	for _, m := range metrics {
+		if match(m) && !contains(result, m.PromDesc.FqName) {
+			result = append(result, m.PromDesc.FqName)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
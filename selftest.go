@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/simulator"
+)
+
+// selftestFiles holds the metric definitions for the simulator and the expected expositions
+//
+//go:embed selftest
+var selftestFiles embed.FS
+
+var flagSelftestPrint bool
+
+func registerSelftestCommand() {
+
+	cmd := newCommand("selftest", "verify the build end-to-end against the embedded FRITZ!Box simulator", selftest)
+	cmd.flags.BoolVar(&flagSelftestPrint, "print", false, "print the rendered exposition of the collectors")
+}
+
+func selftest() error {
+
+	sim := simulator.New("selftest", "selftest-password")
+	url, err := sim.Start()
+	if err != nil {
+		return &exitError{exitFatal, fmt.Errorf("starting simulator: %v", err)}
+	}
+	defer sim.Close()
+
+	opts := []collector.Option{collector.WithRoundLog(false)}
+
+	failed := false
+	for _, exporterType := range []string{"upnp", "lua"} {
+
+		var metricsFile *metric.MetricsFile
+		data, err := selftestFiles.ReadFile("selftest/metrics-" + exporterType + ".json")
+		if err == nil {
+			err = json.Unmarshal(data, &metricsFile)
+		}
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+
+		var c *collector.Collector
+		if exporterType == "upnp" {
+			c, err = collector.NewUpnpCollector(metricsFile, url, sim.Username, sim.Password, "simulator", opts...)
+		} else {
+			c, err = collector.NewLuaCollector(metricsFile, url, sim.Username, sim.Password, "simulator", opts...)
+		}
+		if err != nil {
+			return &exitError{exitFatal, fmt.Errorf("%s collector: %v", exporterType, err)}
+		}
+
+		exposition, err := render(c)
+		if err != nil {
+			return &exitError{exitFatal, fmt.Errorf("%s collector: %v", exporterType, err)}
+		}
+		if flagSelftestPrint {
+			fmt.Print(exposition)
+		}
+
+		golden, err := selftestFiles.ReadFile("selftest/golden-" + exporterType + ".prom")
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+		if exposition == string(golden) {
+			fmt.Printf("%s: ok\n", exporterType)
+			continue
+		}
+		failed = true
+		fmt.Printf("%s: exposition differs from golden file\n", exporterType)
+		printDiff(string(golden), exposition)
+	}
+
+	if failed {
+		return &exitError{exitFatal, fmt.Errorf("selftest failed")}
+	}
+	return nil
+}
+
+// render collects the collector once and returns the text exposition of its metrics
+func render(c *collector.Collector) (string, error) {
+
+	registry := prometheus.NewRegistry()
+	err := registry.Register(c)
+	if err != nil {
+		return "", err
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		// timestamps differ on every run
+		if strings.HasSuffix(family.GetName(), "_timestamp_seconds") {
+			for _, m := range family.Metric {
+				zero := 0.0
+				m.Gauge.Value = &zero
+			}
+		}
+		_, err = expfmt.MetricFamilyToText(&buf, family)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// printDiff prints the lines missing from (-) or unexpected in (+) the exposition
+func printDiff(expected string, actual string) {
+
+	expectedLines := map[string]bool{}
+	for _, line := range strings.Split(expected, "\n") {
+		expectedLines[line] = true
+	}
+	actualLines := map[string]bool{}
+	for _, line := range strings.Split(actual, "\n") {
+		actualLines[line] = true
+		if !expectedLines[line] {
+			fmt.Printf("  + %s\n", line)
+		}
+	}
+	for _, line := range strings.Split(expected, "\n") {
+		if !actualLines[line] {
+			fmt.Printf("  - %s\n", line)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+	"github.com/aexel90/fritzbox_exporter/history"
+)
+
+const shutdownTimeout = 10 * time.Second
+const loginRetryInterval = 10 * time.Second
+
+var (
+	flagAddress          string
+	flagReadTimeout      time.Duration
+	flagWriteTimeout     time.Duration
+	flagHealthEndpoints  bool
+	flagCollectInterval  time.Duration
+	flagCounterState     string
+	flagNativeHistograms bool
+)
+
+// counterStore accumulates the monotonic metrics of all collectors, so they survive reloads
+var counterStore *collector.CounterStore
+
+func registerServeCommand() {
+
+	cmd := newCommand("serve", "serve the configured metrics for prometheus", serve)
+	addGatewayFlags(cmd.flags)
+	addGatewaysFileFlag(cmd.flags)
+	addAutoDiscoverFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	cmd.flags.StringVar(&flagAddress, "listen-address", "127.0.0.1:9042", "The address to listen on for HTTP requests.")
+	cmd.flags.DurationVar(&flagReadTimeout, "web.read-timeout", 10*time.Second, "Maximum duration for reading an HTTP request.")
+	cmd.flags.DurationVar(&flagWriteTimeout, "web.write-timeout", 60*time.Second, "Maximum duration for writing an HTTP response, must exceed the scrape duration.")
+	cmd.flags.BoolVar(&flagHealthEndpoints, "web.health-endpoints", false, "Serve /healthz and /ready endpoints.")
+	cmd.flags.DurationVar(&flagCollectInterval, "collect.interval", 0, "Collect in the background in this interval and serve the last result on scrapes (0 = collect on every scrape).")
+	cmd.flags.StringVar(&flagCounterState, "metrics.counter-state-file", "", "The JSON file where to persist the accumulated counters of monotonic metrics, so they survive restarts of the exporter.")
+	cmd.flags.BoolVar(&flagNativeHistograms, "enable-native-histograms", false, "Expose the request and stage duration histograms as native histograms (sparse buckets) in addition to the classic buckets, served to scrapers negotiating protobuf.")
+	addWebSecurityFlags(cmd.flags)
+	addAdminFlags(cmd.flags)
+	addGraphiteFlags(cmd.flags)
+	addHistoryFlags(cmd.flags)
+	addEventFlags(cmd.flags)
+	addWebhookFlags(cmd.flags)
+	addPushFlags(cmd.flags)
+}
+
+func serve() error {
+
+	err := validateWebSecurityFlags()
+	if err != nil {
+		return err
+	}
+	err = validatePushFlags()
+	if err != nil {
+		return err
+	}
+
+	if flagNativeHistograms {
+		collector.EnableNativeHistograms()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = loadWebhookRules()
+	if err != nil {
+		return err
+	}
+
+	counterStore, err = collector.NewCounterStore(flagCounterState)
+	if err != nil {
+		return err
+	}
+
+	set := &collectorSet{ctx: ctx}
+	err = set.load()
+	if err != nil {
+		return err
+	}
+	prometheus.MustRegister(newBuildInfo())
+
+	// service discovery already succeeded while creating the upnp collector
+	var ready atomic.Bool
+	go waitForLogin(ctx, set, &ready)
+
+	if flagGraphiteAddress != "" {
+		go pushGraphite(ctx, set)
+	}
+
+	if flagPushURL != "" {
+		go pushMetrics(ctx)
+	}
+
+	if flagUpnpEvents != "" {
+		go func() {
+			err := subscribeEvents(ctx, defaultTarget())
+			if err != nil {
+				fmt.Println("Error: upnp events: ", err)
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", protect(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler(set))))
+	mux.Handle("/", protect(landingPage(set)))
+	if flagHistoryRetention > 0 {
+		buffer := history.New(flagHistoryRetention, flagHistoryInterval)
+		go recordHistory(ctx, set, buffer)
+		mux.Handle("/api/v1/range", protect(rangeHandler(buffer)))
+	}
+	if flagHealthEndpoints {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+			if !ready.Load() {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ready")
+		})
+	}
+
+	server := &http.Server{
+		Addr:         flagAddress,
+		Handler:      mux,
+		ReadTimeout:  flagReadTimeout,
+		WriteTimeout: flagWriteTimeout,
+	}
+
+	servers := []*http.Server{server}
+	errCh := make(chan error, 2)
+	go func() {
+		scheme := "http"
+		if flagTLSCert != "" {
+			scheme = "https"
+		}
+		fmt.Printf("metrics available at %s://%s/metrics\n", scheme, flagAddress)
+		errCh <- listenAndServe(server)
+	}()
+
+	if flagAdminAddress != "" {
+		adminServer := &http.Server{
+			Addr:         flagAdminAddress,
+			Handler:      newAdminMux(set),
+			ReadTimeout:  flagReadTimeout,
+			WriteTimeout: flagWriteTimeout,
+		}
+		servers = append(servers, adminServer)
+		go func() {
+			fmt.Printf("admin endpoints available at %s\n", flagAdminAddress)
+			errCh <- listenAndServe(adminServer)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		err = s.Shutdown(shutdownCtx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForLogin retries the initial lua login until it succeeds and marks the exporter as ready
+func waitForLogin(ctx context.Context, set *collectorSet, ready *atomic.Bool) {
+
+	for {
+		err := set.login(ctx)
+		if err == nil {
+			break
+		}
+		fmt.Println("Error: initial login failed: ", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(loginRetryInterval):
+		}
+	}
+	ready.Store(true)
+}
@@ -0,0 +1,702 @@
+// Package simulator serves a minimal FRITZ!Box: the TR-064 and IGD services via SOAP (with digest auth
+// for TR-064 like the box), the lua login, data.lua pages and the AHA-HTTP interface. It allows end-to-end tests of the exporter
+// without a device.
+package simulator
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	realm      = "F!Box SOAP-Auth"
+	invalidSID = "0000000000000000"
+)
+
+// Variable is a state variable returned by an action
+type Variable struct {
+	Name     string
+	DataType string
+	Value    string
+}
+
+// Action is an action with the out arguments New<Variable>. Actions with an In argument (e.g. an index)
+// return the out values of Entries[index] instead, the values of Out are unused then.
+type Action struct {
+	Name    string
+	In      *Variable
+	Out     []Variable
+	Entries [][]string
+}
+
+// Service is a service of the igddesc.xml or tr64desc.xml description
+type Service struct {
+	Description string
+	ServiceType string
+	ServiceID   string
+	ControlURL  string
+	SCPDURL     string
+	// Auth requires digest authentication for the control URL
+	Auth    bool
+	Actions []Action
+}
+
+// Simulator is a FRITZ!Box served on a local port
+type Simulator struct {
+	Username string
+	Password string
+	Services []Service
+	// Pages are the JSON responses of data.lua by page
+	Pages map[string]string
+	// HomeAutomation are the XML responses of the AHA-HTTP interface by switchcmd
+	HomeAutomation map[string]string
+	// Files are further documents by path, e.g. the host list of X_AVM-DE_GetHostListPath
+	Files map[string]string
+
+	listener net.Listener
+	server   *http.Server
+
+	initNonce sync.Once
+	mutex     sync.Mutex
+	nonce     string
+	challenge string
+	sid       string
+}
+
+// New creates a simulator of a DSL box with device info, WAN counters, DSL line info, a PPP connection with two port mappings, two WLANs with three clients, two powerline devices, a firmware update, a host list with a blocked host, USB storage, remote access, a TR-069 management server and the energy, ecoStat, kidPro, shareVpn and usbOv pages and two thermostats
+func New(username string, password string) *Simulator {
+
+	return &Simulator{
+		Username: username,
+		Password: password,
+		Services: []Service{
+			{
+				Description: "igddesc.xml",
+				ServiceType: "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1",
+				ServiceID:   "urn:upnp-org:serviceId:WANCommonIFC1",
+				ControlURL:  "/igdupnp/control/WANCommonIFC1",
+				SCPDURL:     "/igdicfgSCPD.xml",
+				Actions: []Action{
+					{Name: "GetAddonInfos", Out: []Variable{
+						{"ByteSendRate", "ui4", "1250"},
+						{"ByteReceiveRate", "ui4", "25000"},
+						{"TotalBytesSent", "ui4", "123456789"},
+						{"TotalBytesReceived", "ui4", "987654321"},
+					}},
+					{Name: "GetCommonLinkProperties", Out: []Variable{
+						{"WANAccessType", "string", "DSL"},
+						{"Layer1UpstreamMaxBitRate", "ui4", "40000000"},
+						{"Layer1DownstreamMaxBitRate", "ui4", "100000000"},
+						{"PhysicalLinkStatus", "string", "Up"},
+					}},
+				},
+			},
+			{
+				Description: "igddesc.xml",
+				ServiceType: "urn:schemas-upnp-org:service:WANPPPConnection:1",
+				ServiceID:   "urn:upnp-org:serviceId:WANPPPConn1",
+				ControlURL:  "/igdupnp/control/WANPPPConn1",
+				SCPDURL:     "/igdconnSCPD.xml",
+				Actions: []Action{
+					{Name: "GetStatusInfo", Out: []Variable{
+						{"ConnectionStatus", "string", "Connected"},
+						{"LastConnectionError", "string", "ERROR_NONE"},
+						{"Uptime", "ui4", "3600"},
+					}},
+					{Name: "GetExternalIPAddress", Out: []Variable{
+						{"ExternalIPAddress", "string", "198.51.100.7"},
+					}},
+					{Name: "GetGenericPortMappingEntry", In: &Variable{"PortMappingIndex", "ui2", ""}, Out: []Variable{
+						{"RemoteHost", "string", ""},
+						{"ExternalPort", "ui2", ""},
+						{"PortMappingProtocol", "string", ""},
+						{"InternalPort", "ui2", ""},
+						{"InternalClient", "string", ""},
+						{"PortMappingEnabled", "boolean", ""},
+						{"PortMappingDescription", "string", ""},
+						{"PortMappingLeaseDuration", "ui4", ""},
+					}, Entries: [][]string{
+						{"", "51413", "TCP", "51413", "192.168.178.20", "1", "Transmission at 51413", "0"},
+						{"", "3074", "UDP", "3074", "192.168.178.31", "0", "Xbox", "3600"},
+					}},
+				},
+			},
+			{
+				Description: "igddesc.xml",
+				ServiceType: "urn:schemas-upnp-org:service:WANIPConnection:1",
+				ServiceID:   "urn:upnp-org:serviceId:WANIPConn1",
+				ControlURL:  "/igdupnp/control/WANIPConn1",
+				SCPDURL:     "/igdipconnSCPD.xml",
+				Actions: []Action{
+					{Name: "GetExternalIPAddress", Out: []Variable{
+						{"ExternalIPAddress", "string", "198.51.100.7"},
+					}},
+					{Name: "X_AVM_DE_GetExternalIPv6Address", Out: []Variable{
+						{"ExternalIPv6Address", "string", "2001:db8:1234:5600::1"},
+						{"PrefixLength", "ui1", "64"},
+						{"ValidLifetime", "ui4", "7200"},
+						{"PreferedLifetime", "ui4", "3600"},
+					}},
+					{Name: "X_AVM_DE_GetIPv6Prefix", Out: []Variable{
+						{"IPv6Prefix", "string", "2001:db8:1234:5600::"},
+						{"PrefixLength", "ui1", "56"},
+						{"ValidLifetime", "ui4", "86400"},
+						{"PreferedLifetime", "ui4", "14400"},
+					}},
+					{Name: "X_AVM_DE_GetDsliteStatus", Out: []Variable{
+						{"X_AVM_DE_DsliteStatus", "boolean", "1"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:DeviceInfo:1",
+				ServiceID:   "urn:DeviceInfo-com:serviceId:DeviceInfo1",
+				ControlURL:  "/upnp/control/deviceinfo",
+				SCPDURL:     "/deviceinfoSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetInfo", Out: []Variable{
+						{"ModelName", "string", "FRITZ!Box 7590"},
+						{"SoftwareVersion", "string", "154.07.57"},
+						{"SerialNumber", "string", "SIMULATOR0001"},
+						{"UpTime", "ui4", "86400"},
+						{"ProvisioningCode", "string", "001.000.000.000"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:WLANConfiguration:1",
+				ServiceID:   "urn:WLANConfiguration-com:serviceId:WLANConfiguration1",
+				ControlURL:  "/upnp/control/wlanconfig1",
+				SCPDURL:     "/wlanconfigSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetGenericAssociatedDeviceInfo", In: &Variable{"AssociatedDeviceIndex", "ui2", ""}, Out: []Variable{
+						{"AssociatedDeviceMACAddress", "string", ""},
+						{"AssociatedDeviceIPAddress", "string", ""},
+						{"AssociatedDeviceAuthState", "boolean", ""},
+						{"X_AVM-DE_Speed", "ui4", ""},
+						{"X_AVM-DE_SignalStrength", "ui1", ""},
+					}, Entries: [][]string{
+						{"AA:BB:CC:00:00:01", "192.168.178.40", "1", "72", "48"},
+					}},
+					{Name: "GetTotalAssociations", Out: []Variable{
+						{"TotalAssociations", "ui2", "1"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:WLANConfiguration:2",
+				ServiceID:   "urn:WLANConfiguration-com:serviceId:WLANConfiguration2",
+				ControlURL:  "/upnp/control/wlanconfig2",
+				SCPDURL:     "/wlanconfigSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetGenericAssociatedDeviceInfo", In: &Variable{"AssociatedDeviceIndex", "ui2", ""}, Out: []Variable{
+						{"AssociatedDeviceMACAddress", "string", ""},
+						{"AssociatedDeviceIPAddress", "string", ""},
+						{"AssociatedDeviceAuthState", "boolean", ""},
+						{"X_AVM-DE_Speed", "ui4", ""},
+						{"X_AVM-DE_SignalStrength", "ui1", ""},
+					}, Entries: [][]string{
+						{"AA:BB:CC:00:00:02", "192.168.178.41", "1", "866", "61"},
+						{"AA:BB:CC:00:00:03", "192.168.178.42", "0", "0", "12"},
+					}},
+					{Name: "GetTotalAssociations", Out: []Variable{
+						{"TotalAssociations", "ui2", "2"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:WANDSLInterfaceConfig:1",
+				ServiceID:   "urn:WANDSLIfConfig-com:serviceId:WANDSLInterfaceConfig1",
+				ControlURL:  "/upnp/control/wandslifconfig1",
+				SCPDURL:     "/wandslifconfigSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "X_AVM-DE_GetDSLInfo", Out: []Variable{
+						{"X_AVM-DE_SNRGds", "ui4", "1"},
+						{"X_AVM-DE_SNRGus", "ui4", "1"},
+						{"X_AVM-DE_SNRpsds", "string", "62,79,95"},
+						{"X_AVM-DE_SNRpsus", "string", "34,45"},
+						{"X_AVM-DE_SNRMTds", "ui4", "2"},
+						{"X_AVM-DE_SNRMTus", "ui4", "2"},
+						{"X_AVM-DE_LATNds", "string", "125,225,315"},
+						{"X_AVM-DE_LATNus", "string", "71,175"},
+						{"X_AVM-DE_FECErrors", "ui4", "1524"},
+						{"X_AVM-DE_CRCErrors", "ui4", "37"},
+						{"X_AVM-DE_LinkStatus", "string", "Up"},
+						{"X_AVM-DE_ModulationType", "string", "VDSL2"},
+						{"X_AVM-DE_CurrentProfile", "string", "17a"},
+						{"UpstreamCurrRate", "ui4", "40000"},
+						{"DownstreamCurrRate", "ui4", "100000"},
+						{"UpstreamMaxRate", "ui4", "46720"},
+						{"DownstreamMaxRate", "ui4", "116790"},
+						{"UpstreamNoiseMargin", "ui4", "90"},
+						{"DownstreamNoiseMargin", "ui4", "110"},
+						{"UpstreamAttenuation", "ui4", "80"},
+						{"DownstreamAttenuation", "ui4", "140"},
+						{"ATURVendor", "string", "BDCM"},
+						{"ATURCountry", "string", "0000"},
+						{"X_AVM-DE_UpstreamPower", "ui4", "72"},
+						{"X_AVM-DE_DownstreamPower", "ui4", "145"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:Hosts:1",
+				ServiceID:   "urn:LanDeviceHosts-com:serviceId:Hosts1",
+				ControlURL:  "/upnp/control/hosts",
+				SCPDURL:     "/hostsSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetHostNumberOfEntries", Out: []Variable{
+						{"HostNumberOfEntries", "ui2", "2"},
+					}},
+					{Name: "X_AVM-DE_GetHostListPath", Out: []Variable{
+						{"X_AVM-DE_HostListPath", "string", "/devicehostlist.lua"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:X_AVM-DE_HostFilter:1",
+				ServiceID:   "urn:X_AVM-DE_HostFilter-com:serviceId:X_AVM-DE_HostFilter1",
+				ControlURL:  "/upnp/control/x_hostfilter",
+				SCPDURL:     "/x_hostfilterSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetTicketIDStatus", Out: []Variable{
+						{"TicketIDStatus", "string", "unused"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:UserInterface:1",
+				ServiceID:   "urn:UserInterface-com:serviceId:UserInterface1",
+				ControlURL:  "/upnp/control/userif",
+				SCPDURL:     "/userifSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetInfo", Out: []Variable{
+						{"UpgradeAvailable", "boolean", "1"},
+						{"PasswordRequired", "boolean", "0"},
+						{"PasswordUserSelectable", "boolean", "1"},
+						{"WarrantyDate", "dateTime", "0001-01-01T00:00:00"},
+						{"X_AVM-DE_Version", "string", "154.08.00"},
+						{"X_AVM-DE_DownloadURL", "string", "http://download.avm.de/fritzbox/fritzbox-7590/deutschland/fritz.os/FRITZ.Box_7590-08.00.image"},
+						{"X_AVM-DE_InfoURL", "string", "http://download.avm.de/fritzbox/fritzbox-7590/deutschland/fritz.os/info_de.txt"},
+						{"X_AVM-DE_UpdateState", "string", "Stopped"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:X_AVM-DE_Homeplug:1",
+				ServiceID:   "urn:X_AVM-DE_Homeplug-com:serviceId:X_AVM-DE_Homeplug1",
+				ControlURL:  "/upnp/control/x_homeplug",
+				SCPDURL:     "/x_homeplugSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetNumberOfDeviceEntries", Out: []Variable{
+						{"NumberOfEntries", "ui4", "2"},
+					}},
+					{Name: "GetGenericDeviceEntry", In: &Variable{"Index", "ui4", ""}, Out: []Variable{
+						{"MACAddress", "string", ""},
+						{"Active", "boolean", ""},
+						{"Name", "string", ""},
+						{"Model", "string", ""},
+						{"UpdateAvailable", "boolean", ""},
+						{"UpdateSuccessful", "string", ""},
+					}, Entries: [][]string{
+						{"AA:BB:CC:00:10:01", "1", "Powerline Office", "FRITZ!Powerline 1260E", "0", "succeeded"},
+						{"AA:BB:CC:00:10:02", "0", "Powerline Garage", "FRITZ!Powerline 540E", "1", "unknown"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:X_AVM-DE_Storage:1",
+				ServiceID:   "urn:X_AVM-DE_Storage-com:serviceId:X_AVM-DE_Storage1",
+				ControlURL:  "/upnp/control/x_storage",
+				SCPDURL:     "/x_storageSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetInfo", Out: []Variable{
+						{"FTPEnable", "boolean", "1"},
+						{"FTPStatus", "string", "FTP_ENABLED"},
+						{"SMBEnable", "boolean", "1"},
+						{"FTPWANEnable", "boolean", "0"},
+						{"FTPWANSSLOnly", "boolean", "1"},
+						{"FTPWANPort", "ui2", "21"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:X_AVM-DE_RemoteAccess:1",
+				ServiceID:   "urn:X_AVM-DE_RemoteAccess-com:serviceId:X_AVM-DE_RemoteAccess1",
+				ControlURL:  "/upnp/control/x_remote",
+				SCPDURL:     "/x_remoteSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetInfo", Out: []Variable{
+						{"Enabled", "boolean", "1"},
+						{"Port", "string", "43210"},
+					}},
+				},
+			},
+			{
+				Description: "tr64desc.xml",
+				ServiceType: "urn:dslforum-org:service:ManagementServer:1",
+				ServiceID:   "urn:ManagementServer-com:serviceId:ManagementServer1",
+				ControlURL:  "/upnp/control/mgmsrv",
+				SCPDURL:     "/mgmsrvSCPD.xml",
+				Auth:        true,
+				Actions: []Action{
+					{Name: "GetInfo", Out: []Variable{
+						{"URL", "string", "https://acs.example.net/cwmp"},
+						{"PeriodicInformEnable", "boolean", "1"},
+						{"PeriodicInformInterval", "ui4", "86400"},
+						{"ParameterKey", "string", "provisioned-1"},
+						{"ConnectionRequestURL", "string", "http://192.0.2.1:8089/tr069"},
+						{"UpgradesManaged", "boolean", "0"},
+					}},
+				},
+			},
+		},
+		Pages: map[string]string{
+			"energy":   `{"data":{"drain":[{"name":"Gesamtsystem","actPerc":42,"lan":[{"class":"green"},{"class":""}]},{"name":"WLAN","actPerc":17}]}}`,
+			"ecoStat":  `{"data":{"cputemp":{"series":[[50,51,55]]},"cpuutil":{"series":[[10,20,12]]},"ramusage":{"series":[[30,31],[20,22],[50,47]]}}}`,
+			"usbOv":    `{"data":{"usbOverview":{"nasEnabled":true,"devices":[{"name":"SanDisk Ultra","deviceType":"storage","partitions":[{"name":"SanDisk-Ultra-01","fileSystem":"ext4","totalStorageInBytes":61524148224,"usedStorageInBytes":12884901888}]}]}}}`,
+			"kidPro":   `{"data":{"kidProfiles":{"profiles":[{"id":"filtprof1","name":"Standard","unlimited":true},{"id":"filtprof3","name":"Kinder","unlimited":false,"budgetMinutes":120,"usedMinutes":45}]}}}`,
+			"shareVpn": `{"data":{"vpnInfo":{"boxConnections":[{"name":"office","type":"wireguard","active":true,"connected":true,"bytesIn":123456,"bytesOut":654321},{"name":"parents","type":"ipsec","active":true,"connected":false,"bytesIn":0,"bytesOut":0}],"userConnections":[{"name":"alice","type":"wireguard","active":true,"connected":true},{"name":"bob","type":"ipsec","active":true,"connected":false}]}}}`,
+		},
+		Files: map[string]string{
+			"/devicehostlist.lua": `<?xml version="1.0" encoding="utf-8"?><List>` +
+				`<Item><Index>1</Index><IPAddress>192.168.178.20</IPAddress><MACAddress>AA:BB:CC:00:00:20</MACAddress><Active>1</Active><HostName>nas</HostName><X_AVM-DE_WANAccess>granted</X_AVM-DE_WANAccess><X_AVM-DE_Disallow>0</X_AVM-DE_Disallow></Item>` +
+				`<Item><Index>2</Index><IPAddress>192.168.178.41</IPAddress><MACAddress>AA:BB:CC:00:00:02</MACAddress><Active>1</Active><HostName>kids-tablet</HostName><X_AVM-DE_WANAccess>denied</X_AVM-DE_WANAccess><X_AVM-DE_Disallow>1</X_AVM-DE_Disallow></Item>` +
+				`</List>`,
+		},
+		HomeAutomation: map[string]string{
+			"getdevicelistinfos": `<devicelist version="1">` +
+				`<device identifier="09995 0123456" id="16" functionbitmask="320" fwversion="05.08" manufacturer="AVM" productname="FRITZ!DECT 301">` +
+				`<present>1</present><name>Bathroom</name><battery>80</battery><batterylow>0</batterylow>` +
+				`<hkr><tist>42</tist><tsoll>44</tsoll><absenk>32</absenk><komfort>44</komfort><errorcode>0</errorcode>` +
+				`<windowopenactiv>1</windowopenactiv><boostactive>0</boostactive><holidayactive>0</holidayactive><summeractive>0</summeractive></hkr></device>` +
+				`<device identifier="09995 0654321" id="17" functionbitmask="320" fwversion="03.54" manufacturer="AVM" productname="Comet DECT">` +
+				`<present>1</present><name>Kitchen</name>` +
+				`<hkr><tist>40</tist><tsoll>253</tsoll><absenk>32</absenk><komfort>42</komfort><errorcode>0</errorcode>` +
+				`<windowopenactiv>0</windowopenactiv><holidayactive>1</holidayactive><summeractive>0</summeractive><batterylow>1</batterylow><battery>10</battery></hkr></device>` +
+				`</devicelist>`,
+		},
+	}
+}
+
+// Start listens on a random local port and returns the base URL of the simulator
+func (s *Simulator) Start() (string, error) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.listener = listener
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(listener)
+	return "http://" + listener.Addr().String(), nil
+}
+
+// Close stops the simulator
+func (s *Simulator) Close() error {
+
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// ServeHTTP implements http.Handler, so the simulator can also be served by httptest.NewServer in tests
+// of programs embedding the collectors
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	s.initNonce.Do(func() {
+		s.nonce = randomHex(8)
+	})
+
+	switch r.URL.Path {
+	case "/igddesc.xml", "/tr64desc.xml":
+		s.serveDescription(w, r.URL.Path[1:])
+		return
+	case "/login_sid.lua":
+		s.serveLogin(w, r)
+		return
+	case "/data.lua":
+		s.serveData(w, r)
+		return
+	case "/webservices/homeautoswitch.lua":
+		s.serveHomeAutomation(w, r)
+		return
+	}
+	if file, ok := s.Files[r.URL.Path]; ok {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(file))
+		return
+	}
+
+	for i := range s.Services {
+		service := &s.Services[i]
+		switch r.URL.Path {
+		case service.SCPDURL:
+			serveSCPD(w, service)
+			return
+		case service.ControlURL:
+			if service.Auth && !s.authorized(r) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5, qop="auth"`, realm, s.nonce))
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			serveControl(w, r, service)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Simulator) serveDescription(w http.ResponseWriter, description string) {
+
+	var b strings.Builder
+	b.WriteString(xml.Header + `<root xmlns="urn:schemas-upnp-org:device-1-0"><device>` +
+		`<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>` +
+		`<friendlyName>FRITZ!Box 7590 (simulator)</friendlyName><manufacturer>AVM</manufacturer>` +
+		`<modelName>FRITZ!Box 7590</modelName><serviceList>`)
+	for _, service := range s.Services {
+		if service.Description != description {
+			continue
+		}
+		fmt.Fprintf(&b, `<service><serviceType>%s</serviceType><serviceId>%s</serviceId><controlURL>%s</controlURL>`+
+			`<eventSubURL>%s</eventSubURL><SCPDURL>%s</SCPDURL></service>`,
+			service.ServiceType, service.ServiceID, service.ControlURL, service.ControlURL, service.SCPDURL)
+	}
+	b.WriteString(`</serviceList></device></root>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(b.String()))
+}
+
+func serveSCPD(w http.ResponseWriter, service *Service) {
+
+	var b strings.Builder
+	b.WriteString(xml.Header + `<scpd xmlns="urn:dslforum-org:service-1-0"><actionList>`)
+	for _, action := range service.Actions {
+		fmt.Fprintf(&b, `<action><name>%s</name><argumentList>`, action.Name)
+		if action.In != nil {
+			fmt.Fprintf(&b, `<argument><name>New%s</name><direction>in</direction><relatedStateVariable>%s</relatedStateVariable></argument>`, action.In.Name, action.In.Name)
+		}
+		for _, v := range action.Out {
+			fmt.Fprintf(&b, `<argument><name>New%s</name><direction>out</direction><relatedStateVariable>%s</relatedStateVariable></argument>`, v.Name, v.Name)
+		}
+		b.WriteString(`</argumentList></action>`)
+	}
+	b.WriteString(`</actionList><serviceStateTable>`)
+	for _, action := range service.Actions {
+		if action.In != nil {
+			fmt.Fprintf(&b, `<stateVariable><name>%s</name><dataType>%s</dataType></stateVariable>`, action.In.Name, action.In.DataType)
+		}
+		for _, v := range action.Out {
+			fmt.Fprintf(&b, `<stateVariable><name>%s</name><dataType>%s</dataType></stateVariable>`, v.Name, v.DataType)
+		}
+	}
+	b.WriteString(`</serviceStateTable></scpd>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(b.String()))
+}
+
+func serveControl(w http.ResponseWriter, r *http.Request, service *Service) {
+
+	soapAction := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	name := soapAction[strings.LastIndex(soapAction, "#")+1:]
+
+	for _, action := range service.Actions {
+		if r.Method != http.MethodPost || action.Name != name {
+			continue
+		}
+
+		values := make([]string, len(action.Out))
+		for i, v := range action.Out {
+			values[i] = v.Value
+		}
+		if action.In != nil {
+			index, ok := indexArgument(r, "New"+action.In.Name)
+			if !ok || index >= len(action.Entries) {
+				serveFault(w, 713, "SpecifiedArrayIndexInvalid")
+				return
+			}
+			values = action.Entries[index]
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, xml.Header+`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+			`<s:Body><u:%sResponse xmlns:u="%s">`, action.Name, service.ServiceType)
+		for i, v := range action.Out {
+			fmt.Fprintf(&b, "<New%s>", v.Name)
+			xml.EscapeText(&b, []byte(values[i]))
+			fmt.Fprintf(&b, "</New%s>", v.Name)
+		}
+		fmt.Fprintf(&b, `</u:%sResponse></s:Body></s:Envelope>`, action.Name)
+
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write([]byte(b.String()))
+		return
+	}
+
+	serveFault(w, 401, "Invalid Action")
+}
+
+func serveFault(w http.ResponseWriter, code int, description string) {
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, xml.Header+`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:dslforum-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, description)
+}
+
+// indexArgument reads the integer argument of the SOAP request. The request is not parsed as XML,
+// since the box also accepts the sloppy envelopes of some clients.
+func indexArgument(r *http.Request, name string) (int, bool) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return 0, false
+	}
+	match := regexp.MustCompile(`<` + name + `>\s*(\d+)\s*</` + name + `>`).FindSubmatch(body)
+	if match == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(string(match[1]))
+	return index, err == nil
+}
+
+// authorized checks the digest response of the request against the current nonce
+func (s *Simulator) authorized(r *http.Request) bool {
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return false
+	}
+
+	d := map[string]string{}
+	for _, param := range strings.Split(auth[7:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			d[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if d["username"] != s.Username || d["realm"] != realm || d["nonce"] != s.nonce || d["uri"] != r.URL.RequestURI() {
+		return false
+	}
+
+	ha1 := md5Hex(s.Username + ":" + realm + ":" + s.Password)
+	ha2 := md5Hex(r.Method + ":" + d["uri"])
+	return d["response"] == md5Hex(strings.Join([]string{ha1, d["nonce"], d["nc"], d["cnonce"], d["qop"], ha2}, ":"))
+}
+
+// serveLogin answers the challenge, or creates a session if the response of the challenge is correct
+func (s *Simulator) serveLogin(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sid := invalidSID
+	response := r.URL.Query().Get("response")
+	if response != "" {
+		if r.URL.Query().Get("username") == s.Username && response == s.challenge+"-"+utf16leMd5Hex(s.challenge+"-"+s.Password) {
+			s.sid = randomHex(8)
+			sid = s.sid
+		}
+	}
+	s.challenge = randomHex(4)
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, xml.Header+`<SessionInfo><SID>%s</SID><Challenge>%s</Challenge><BlockTime>0</BlockTime></SessionInfo>`, sid, s.challenge)
+}
+
+func (s *Simulator) serveData(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	sid := s.sid
+	s.mutex.Unlock()
+
+	if r.Method != http.MethodPost || sid == "" || r.PostFormValue("sid") != sid {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	page, ok := s.Pages[r.PostFormValue("page")]
+	if !ok {
+		fmt.Fprintf(w, `{"sid":"%s","error":"unknown page"}`, sid)
+		return
+	}
+	w.Write([]byte(page))
+}
+
+func (s *Simulator) serveHomeAutomation(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	sid := s.sid
+	s.mutex.Unlock()
+
+	if sid == "" || r.URL.Query().Get("sid") != sid {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	response, ok := s.HomeAutomation[r.URL.Query().Get("switchcmd")]
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(response))
+}
+
+func md5Hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+func utf16leMd5Hex(s string) string {
+
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return fmt.Sprintf("%x", md5.Sum(b))
+}
+
+func randomHex(n int) string {
+
+	b := make([]byte, n)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
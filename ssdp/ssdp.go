@@ -0,0 +1,115 @@
+// Package ssdp finds UPnP devices on the LAN with SSDP M-SEARCH requests
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// FritzBoxTarget is the search target of the TR-064 device of a FRITZ!Box
+const FritzBoxTarget = "urn:dslforum-org:device:InternetGatewayDevice:1"
+
+// multicastAddress is the SSDP multicast group
+var multicastAddress = "239.255.255.250:1900"
+
+// Device is a response to the search
+type Device struct {
+	// Location is the URL of the device description, e.g. http://192.168.178.1:49000/tr64desc.xml
+	Location string `json:"location"`
+	Server   string `json:"server"`
+	USN      string `json:"usn"`
+	// Address is the IP address the response was sent from
+	Address string `json:"address"`
+}
+
+// BaseURL returns scheme, host and port of the description location, the upnp URL of the device
+func (d Device) BaseURL() string {
+
+	u, err := url.Parse(d.Location)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Host returns the host of the device without port
+func (d Device) Host() string {
+
+	u, err := url.Parse(d.Location)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// Search sends an M-SEARCH request for the target and collects the responses until the timeout,
+// each device (location) is returned once
+func Search(ctx context.Context, target string, timeout time.Duration) ([]Device, error) {
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	addr, err := net.ResolveUDPAddr("udp4", multicastAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// devices answer within MX seconds
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\n\r\n", multicastAddress, mx, target)
+	_, err = conn.WriteTo([]byte(request), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	devices := []Device{}
+	seen := map[string]bool{}
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return devices, nil
+			}
+			return devices, err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			// not an SSDP response
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ST") != target {
+			continue
+		}
+
+		device := Device{Location: resp.Header.Get("LOCATION"), Server: resp.Header.Get("SERVER"), USN: resp.Header.Get("USN")}
+		if udpAddr, ok := from.(*net.UDPAddr); ok {
+			device.Address = udpAddr.IP.String()
+		}
+		if device.Location == "" || seen[device.Location] {
+			continue
+		}
+		seen[device.Location] = true
+		devices = append(devices, device)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+)
+
+var (
+	flagResultFileLua  string
+	flagResultFileUpnp string
+)
+
+func registerTestCommand() {
+
+	cmd := newCommand("test", "test configured metrics (exit code 0 = ok, 1 = partial, 2 = fatal)", test)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	addOutputFlag(cmd.flags)
+	cmd.flags.StringVar(&flagResultFileLua, "result-file-lua", "", "The JSON file where to store lua export results during test")
+	cmd.flags.StringVar(&flagResultFileUpnp, "result-file-upnp", "", "The JSON file where to store upnp export results during test")
+}
+
+func test() error {
+
+	err := validateOutputFlag()
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if flagOutputFormat == "json" {
+		out = ioutil.Discard
+	}
+
+	luaCollector, upnpCollector, err := newCollectors()
+	if err != nil {
+		return &exitError{exitFatal, err}
+	}
+
+	reports := []*collector.TestReport{}
+	if luaCollector != nil {
+		reports = append(reports, luaCollector.Test(flagResultFileLua, out))
+	}
+	if upnpCollector != nil {
+		reports = append(reports, upnpCollector.Test(flagResultFileUpnp, out))
+	}
+
+	if flagOutputFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(reports)
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+	}
+
+	code := exitOK
+	for _, report := range reports {
+		if report.Failed() {
+			code = exitFatal
+		} else if report.Partial() && code == exitOK {
+			code = exitPartial
+		}
+	}
+	if code != exitOK {
+		return &exitError{code: code}
+	}
+	return nil
+}
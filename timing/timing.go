@@ -0,0 +1,77 @@
+package timing
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// stages of the collection pipeline
+const (
+	Discovery = "discovery"
+	Auth      = "auth"
+	Fetch     = "fetch"
+	Parse     = "parse"
+	Map       = "map"
+)
+
+// Stages sums up the time spent in each stage of the collection pipeline.
+// All methods can be called on a nil *Stages, which does not measure anything.
+type Stages struct {
+	mutex     sync.Mutex
+	durations map[string]time.Duration
+}
+
+// Add adds the duration to the stage
+func (s *Stages) Add(stage string, d time.Duration) {
+
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.durations == nil {
+		s.durations = make(map[string]time.Duration)
+	}
+	s.durations[stage] += d
+}
+
+// Since adds the time elapsed since start to the stage
+func (s *Stages) Since(stage string, start time.Time) {
+	s.Add(stage, time.Since(start))
+}
+
+// Get returns the summed up duration of the stage
+func (s *Stages) Get(stage string) time.Duration {
+
+	if s == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.durations[stage]
+}
+
+// Take returns the summed up durations of all stages and starts over
+func (s *Stages) Take() map[string]time.Duration {
+
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	durations := s.durations
+	s.durations = nil
+	return durations
+}
+
+// Sort sorts the stage names in pipeline order
+func Sort(stages []string) {
+
+	order := map[string]int{Discovery: 0, Auth: 1, Fetch: 2, Parse: 3, Map: 4}
+	sort.Slice(stages, func(i, j int) bool {
+		return order[stages[i]] < order[stages[j]]
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig creates a TLS config with the minimum version (e.g. 1.2) and the cipher suites
+// (comma separated names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), empty values keep the go defaults
+func newTLSConfig(minVersion string, cipherSuites string) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{}
+
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", minVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if cipherSuites != "" {
+		ids := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			ids[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(cipherSuites, ",") {
+			name = strings.TrimSpace(name)
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+func registerUpgradeCommand() {
+
+	cmd := newCommand("upgrade", "rewrite metric definition files in the format of the current schema version", upgrade)
+	cmd.flags.StringVar(&flagMetricsLuaFile, "metrics-lua", "", "The JSON files with the lua metric definitions, a comma separated list of files and glob patterns.")
+	cmd.flags.StringVar(&flagMetricsUpnpFile, "metrics-upnp", "", "The JSON files with the upnp metric definitions, a comma separated list of files and glob patterns.")
+}
+
+func upgrade() error {
+
+	for _, list := range []string{flagMetricsLuaFile, flagMetricsUpnpFile} {
+		files, err := metric.ExpandFiles(list)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			err = upgradeFile(file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upgradeFile rewrites the file in the current format, the original is kept as <file>.bak
+func upgradeFile(file string) error {
+
+	metricsFile, err := metric.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if metricsFile.SchemaVersion >= metric.SchemaVersion {
+		fmt.Printf("%s: already schema version %d\n", file, metricsFile.SchemaVersion)
+		return nil
+	}
+
+	original, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	before, err := metricDefinitions(metricsFile)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(metricsFile.Upgrade(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// the upgraded file must define exactly the same metrics, the metrics without group move to the top
+	upgraded, err := metric.Parse(data)
+	if err != nil {
+		return err
+	}
+	after, err := metricDefinitions(upgraded)
+	if err != nil {
+		return err
+	}
+	if strings.Join(before, "\n") != strings.Join(after, "\n") {
+		return fmt.Errorf("%s: the upgraded metric definitions differ, the file was not changed", file)
+	}
+
+	err = ioutil.WriteFile(file+".bak", original, 0644)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: upgraded to schema version %d (original kept in %s.bak)\n", file, metric.SchemaVersion, file)
+	return nil
+}
+
+// metricDefinitions returns the sorted JSON definitions of the metrics
+func metricDefinitions(metricsFile *metric.MetricsFile) ([]string, error) {
+
+	definitions := []string{}
+	for _, m := range metricsFile.Metrics {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, string(data))
+	}
+	sort.Strings(definitions)
+	return definitions, nil
+}
@@ -0,0 +1,185 @@
+package upnp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+// CheckMetric checks the service, action, result and var labels of the metric against the
+// service descriptions (SCPD) of the services
+func CheckMetric(services map[string]*Service, m *metric.Metric) []error {
+
+	serviceTypes := []string{m.Service}
+	if strings.HasSuffix(m.Service, ":*") {
+		serviceTypes = instancesOf(services, m.Service)
+		if len(serviceTypes) == 0 {
+			return []error{fmt.Errorf("no instance of service '%s'", m.Service)}
+		}
+	}
+
+	var errs []error
+	for _, serviceType := range serviceTypes {
+		service, action, err := lookup(services, serviceType, m.Action)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if a := m.ActionArgument; a != nil {
+			arg, ok := action.ArgumentMap[a.Name]
+			if !ok || arg.Direction != "in" {
+				errs = append(errs, fmt.Errorf("action '%s' has no in argument '%s'", m.Action, a.Name))
+			}
+			if a.ProviderAction != "" {
+				provider, ok := service.Actions[a.ProviderAction]
+				if !ok {
+					errs = append(errs, fmt.Errorf("service '%s' has no provider action '%s'", serviceType, a.ProviderAction))
+				} else if !returns(provider, a.Value) {
+					errs = append(errs, fmt.Errorf("provider action '%s' has no result '%s'", a.ProviderAction, a.Value))
+				}
+			}
+		}
+
+		// the results of lists are the fields of the list entries, which are not described
+		if m.ListURLKey != "" || m.ListKey != "" {
+			key := m.ListURLKey + m.ListKey
+			if !returns(action, key) {
+				errs = append(errs, fmt.Errorf("action '%s' has no result '%s'", m.Action, key))
+			}
+			continue
+		}
+		if m.ResultKey != "" && !returns(action, m.ResultKey) {
+			errs = append(errs, fmt.Errorf("action '%s' has no result '%s'", m.Action, m.ResultKey))
+		}
+		for _, label := range ResultLabels(m) {
+			if !returns(action, label) {
+				errs = append(errs, fmt.Errorf("label '%s' is no result of action '%s'", label, m.Action))
+			}
+		}
+	}
+	return errs
+}
+
+// Unsupported reports why the service or action of the metric is missing on the box, nil if it is supported
+func Unsupported(services map[string]*Service, m *metric.Metric) error {
+
+	if strings.HasSuffix(m.Service, ":*") {
+		serviceTypes := instancesOf(services, m.Service)
+		if len(serviceTypes) == 0 {
+			return fmt.Errorf("no instance of service '%s'", m.Service)
+		}
+		_, _, err := lookup(services, serviceTypes[0], m.Action)
+		return err
+	}
+	_, _, err := lookup(services, m.Service, m.Action)
+	return err
+}
+
+// lookup returns the service and action, the error suggests the nearest names if one is missing
+func lookup(services map[string]*Service, serviceType string, actionName string) (*Service, *Action, error) {
+
+	service, ok := services[serviceType]
+	if !ok {
+		candidates := []string{}
+		for name := range services {
+			candidates = append(candidates, name)
+		}
+		return nil, nil, fmt.Errorf("unknown service '%s'%s", serviceType, suggestion(serviceType, candidates))
+	}
+	action, ok := service.Actions[actionName]
+	if !ok {
+		candidates := []string{}
+		for name := range service.Actions {
+			candidates = append(candidates, name)
+		}
+		return nil, nil, fmt.Errorf("service '%s' has no action '%s'%s", serviceType, actionName, suggestion(actionName, candidates))
+	}
+	return service, action, nil
+}
+
+// suggestion returns the candidate nearest to the name as hint, empty if none is similar
+func suggestion(name string, candidates []string) string {
+
+	sort.Strings(candidates)
+	best := ""
+	bestDistance := len(name)/3 + 1
+	for _, candidate := range candidates {
+		d := distance(name, candidate)
+		if d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean '%s'?)", best)
+}
+
+// distance is the Levenshtein distance of the strings
+func distance(a string, b string) int {
+
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// ResultLabels returns the var labels of the metric read from the result of the same name,
+// without the labels set by the exporter itself (gateway, index, instance) or read via labels
+func ResultLabels(m *metric.Metric) []string {
+
+	labels := []string{}
+	for _, label := range m.PromDesc.VarLabels {
+		if _, ok := m.Labels[label]; ok {
+			continue
+		}
+		switch {
+		case strings.ToLower(label) == "gateway":
+		case label == "index" && m.ActionArgument != nil && m.ActionArgument.IsIndex:
+		case label == "instance" && strings.HasSuffix(m.Service, ":*"):
+		default:
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// returns reports if the action returns a result of the name, which is the related state variable of an out argument
+func returns(action *Action, name string) bool {
+
+	for _, arg := range action.Arguments {
+		if arg.Direction == "out" && arg.RelatedStateVariable == name {
+			return true
+		}
+	}
+	return false
+}
+
+func instancesOf(services map[string]*Service, service string) []string {
+
+	prefix := strings.TrimSuffix(service, "*")
+	serviceTypes := []string{}
+	for serviceType := range services {
+		if strings.HasPrefix(serviceType, prefix) {
+			serviceTypes = append(serviceTypes, serviceType)
+		}
+	}
+	sort.Strings(serviceTypes)
+	return serviceTypes
+}
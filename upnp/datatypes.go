@@ -0,0 +1,141 @@
+package upnp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataType converts the results of a UPnP data type, the results of Numeric types can be exported as
+// value by generated metrics
+type DataType struct {
+	Convert func(val string) (interface{}, error)
+	Numeric bool
+}
+
+// dataTypesMutex guards dataTypes, data types may be registered while collectors are running
+var dataTypesMutex sync.RWMutex
+
+// dataTypes are the known UPnP data types, types missing here (string, char, uuid, uri, bin.base64,
+// time, unknown vendor types) are kept as string, so they can be used as labels
+var dataTypes = map[string]DataType{
+	"boolean": {Convert: convertBoolean, Numeric: true},
+
+	"ui1": {Convert: convertUnsigned, Numeric: true},
+	"ui2": {Convert: convertUnsigned, Numeric: true},
+	"ui4": {Convert: convertUnsigned, Numeric: true},
+	"ui8": {Convert: convertUnsigned, Numeric: true},
+
+	"i1":  {Convert: convertSigned, Numeric: true},
+	"i2":  {Convert: convertSigned, Numeric: true},
+	"i4":  {Convert: convertSigned, Numeric: true},
+	"i8":  {Convert: convertSigned, Numeric: true},
+	"int": {Convert: convertSigned, Numeric: true},
+
+	"r4":         {Convert: convertFloat, Numeric: true},
+	"r8":         {Convert: convertFloat, Numeric: true},
+	"number":     {Convert: convertFloat, Numeric: true},
+	"float":      {Convert: convertFloat, Numeric: true},
+	"fixed.14.4": {Convert: convertFloat, Numeric: true},
+
+	"date":        {Convert: convertDateTime, Numeric: true},
+	"dateTime":    {Convert: convertDateTime, Numeric: true},
+	"dateTime.tz": {Convert: convertDateTime, Numeric: true},
+
+	"bin.hex": {Convert: convertHex},
+}
+
+// dateTimeLayouts are the ISO 8601 formats of date, dateTime and dateTime.tz. Values without time zone are taken
+// as local time of the exporter host (time.Local), so the exporter has to run in the time zone of the box.
+var dateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// RegisterDataType adds or replaces the conversion of a data type, e.g. for vendor types of new firmware versions
+func RegisterDataType(name string, dataType DataType) {
+
+	dataTypesMutex.Lock()
+	defer dataTypesMutex.Unlock()
+	dataTypes[name] = dataType
+}
+
+// convertResult converts the value by the UPnP data type of the argument
+func convertResult(val string, arg *Argument) (interface{}, error) {
+
+	dataType := arg.StateVariable.DataType
+
+	// AVM returns empty values for numbers which are not available, e.g. without a connection
+	if val == "" && dataType != "string" {
+		return val, nil
+	}
+
+	dataTypesMutex.RLock()
+	t, ok := dataTypes[dataType]
+	dataTypesMutex.RUnlock()
+	if !ok {
+		return val, nil
+	}
+	res, err := t.Convert(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", dataType, val, err)
+	}
+	return res, nil
+}
+
+func convertBoolean(val string) (interface{}, error) {
+	return bool(val == "1" || val == "true" || val == "yes"), nil
+}
+
+func convertUnsigned(val string) (interface{}, error) {
+
+	// type ui4 can contain values greater than 2^32!
+	res, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		// some AVM actions return -1 for unsigned types
+		signed, signedErr := strconv.ParseInt(val, 10, 64)
+		if signedErr != nil {
+			return nil, err
+		}
+		return int64(signed), nil
+	}
+	return uint64(res), nil
+}
+
+func convertSigned(val string) (interface{}, error) {
+
+	res, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return int64(res), nil
+}
+
+func convertFloat(val string) (interface{}, error) {
+	return strconv.ParseFloat(val, 64)
+}
+
+// convertDateTime converts dates to unix timestamps, the zero date AVM returns for unset dates stays empty
+func convertDateTime(val string) (interface{}, error) {
+
+	for _, layout := range dateTimeLayouts {
+		t, err := time.ParseInLocation(layout, val, time.Local)
+		if err != nil {
+			continue
+		}
+		if t.Year() <= 1 {
+			return "", nil
+		}
+		return t.Unix(), nil
+	}
+	return nil, fmt.Errorf("no ISO 8601 date")
+}
+
+// convertHex converts hex values fitting into 64 bits to numbers, longer values (keys, hashes) stay strings
+func convertHex(val string) (interface{}, error) {
+
+	res, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(val), "0x"), 16, 64)
+	if err != nil {
+		return val, nil
+	}
+	return uint64(res), nil
+}
@@ -0,0 +1,202 @@
+package upnp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aexel90/fritzbox_exporter/httpclient"
+	"github.com/aexel90/fritzbox_exporter/timing"
+)
+
+// DigestTransport is a RoundTripper answering the digest auth challenges of the box for any request.
+// The nonce of the last challenge is reused with an incrementing nonce count for subsequent requests,
+// so a request only has to be repeated once the box declares the nonce stale or issues a new one.
+type DigestTransport struct {
+	// Next is the transport doing the requests (default transport of httpclient.Default)
+	Next     http.RoundTripper
+	Username string
+	Password string
+	// Stages receives the time spent on auth challenges (optional)
+	Stages *timing.Stages
+
+	mutex      sync.Mutex
+	challenge  map[string]string
+	nonceCount int
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *DigestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	authReq, nonce, err := t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := t.next().RoundTrip(authReq)
+	if err != nil {
+		return nil, err
+	}
+
+	wwwAuth := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || wwwAuth == "" || t.Username == "" || t.Password == "" {
+		return resp, nil
+	}
+	// the request can only be repeated, if its body can be read again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	challenge, err := parseChallenge(wwwAuth)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	// a rejected nonce which is not stale means wrong credentials, repeating the request won't help
+	if nonce != "" && challenge["stale"] != "true" && challenge["nonce"] == nonce {
+		return resp, nil
+	}
+	resp.Body.Close()
+	t.setChallenge(challenge)
+
+	authReq, _, err = t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	// the rejected request and the challenge are attributed to auth, the repeated request to the caller
+	t.Stages.Since(timing.Auth, start)
+	return t.next().RoundTrip(authReq)
+}
+
+// Reset drops the last challenge, so the next request is authenticated from scratch
+func (t *DigestTransport) Reset() {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.challenge = nil
+}
+
+func (t *DigestTransport) next() http.RoundTripper {
+
+	if t.Next != nil {
+		return t.Next
+	}
+	return httpclient.Default().Transport
+}
+
+// parseChallenge parses the parameters of a digest WWW-Authenticate header, quoted values may contain commas
+func parseChallenge(wwwAuth string) (map[string]string, error) {
+
+	if !strings.HasPrefix(wwwAuth, "Digest ") {
+		return nil, fmt.Errorf("WWW-Authentication header is not Digest: '%s'", wwwAuth)
+	}
+
+	d := map[string]string{}
+	rest := wwwAuth[7:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, ", ")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in WWW-Authenticate header: '%s'", wwwAuth)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		d[key] = value
+	}
+
+	if d["algorithm"] == "" {
+		d["algorithm"] = "MD5"
+	} else if d["algorithm"] != "MD5" {
+		return nil, fmt.Errorf("digest algorithm not supported: %s != MD5", d["algorithm"])
+	}
+
+	// the server may offer several qop values, e.g. "auth,auth-int"
+	qopAuth := false
+	for _, qop := range strings.Split(d["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			qopAuth = true
+		}
+	}
+	if !qopAuth {
+		return nil, fmt.Errorf("digest qop not supported: %s != auth", d["qop"])
+	}
+	d["qop"] = "auth"
+	d["stale"] = strings.ToLower(d["stale"])
+	return d, nil
+}
+
+// setChallenge replaces the last challenge, the nonce count starts again for the new nonce
+func (t *DigestTransport) setChallenge(d map[string]string) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.challenge = d
+	t.nonceCount = 0
+}
+
+// authorize returns a copy of the request with the digest auth header for the last challenge and its nonce
+func (t *DigestTransport) authorize(req *http.Request) (*http.Request, string, error) {
+
+	authReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, "", err
+		}
+		authReq.Body = body
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	d := t.challenge
+	if d == nil {
+		return authReq, "", nil
+	}
+	t.nonceCount++
+
+	uri := req.URL.RequestURI()
+
+	// calc h1 and h2
+	ha1 := fmt.Sprintf("%x", md5.Sum([]byte(t.Username+":"+d["realm"]+":"+t.Password)))
+	ha2 := fmt.Sprintf("%x", md5.Sum([]byte(req.Method+":"+uri)))
+
+	cn := make([]byte, 8)
+	rand.Read(cn)
+	cnonce := fmt.Sprintf("%x", cn)
+
+	nc := fmt.Sprintf("%08x", t.nonceCount)
+
+	ds := strings.Join([]string{ha1, d["nonce"], nc, cnonce, d["qop"], ha2}, ":")
+	response := fmt.Sprintf("%x", md5.Sum([]byte(ds)))
+
+	header := fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", cnonce=\"%s\", nc=%s, qop=%s, response=\"%s\", algorithm=%s",
+		t.Username, d["realm"], d["nonce"], uri, cnonce, nc, d["qop"], response, d["algorithm"])
+	if opaque, ok := d["opaque"]; ok {
+		header += fmt.Sprintf(", opaque=\"%s\"", opaque)
+	}
+	authReq.Header.Set("Authorization", header)
+	return authReq, d["nonce"], nil
+}
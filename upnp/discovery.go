@@ -0,0 +1,107 @@
+package upnp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// minRediscoveryInterval limits the discoveries caused by unknown services, e.g. due to a typo in the metric definitions
+const minRediscoveryInterval = 5 * time.Minute
+
+// discoveryCache is the content of the cache file
+type discoveryCache struct {
+	BaseURL  string              `json:"baseUrl"`
+	Services map[string]*Service `json:"services"`
+}
+
+// needsRediscovery reports if the discovered services are outdated or an unknown service was requested
+func (exporter *Exporter) needsRediscovery() bool {
+
+	age := time.Since(exporter.discovered)
+	if exporter.DiscoveryInterval > 0 && age > exporter.DiscoveryInterval {
+		return true
+	}
+	return exporter.servicesStale && age > minRediscoveryInterval
+}
+
+// loadCachedServices loads the services from the cache file, if it exists and is not outdated
+func (exporter *Exporter) loadCachedServices() bool {
+
+	if exporter.DiscoveryCacheFile == "" {
+		return false
+	}
+
+	info, err := os.Stat(exporter.DiscoveryCacheFile)
+	if err != nil {
+		return false
+	}
+	if exporter.DiscoveryInterval > 0 && time.Since(info.ModTime()) > exporter.DiscoveryInterval {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(exporter.DiscoveryCacheFile)
+	if err != nil {
+		fmt.Println("Error: reading discovery cache: ", err)
+		return false
+	}
+
+	var cache discoveryCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		fmt.Println("Error: parsing discovery cache: ", err)
+		return false
+	}
+	// the cache belongs to another box
+	if cache.BaseURL != exporter.BaseURL {
+		return false
+	}
+	services := cache.Services
+
+	// restore the references, which are not part of the cache
+	for _, service := range services {
+		for _, action := range service.Actions {
+			action.service = service
+		}
+	}
+
+	exporter.Services = services
+	exporter.discovered = info.ModTime()
+	exporter.servicesStale = false
+	return true
+}
+
+func (exporter *Exporter) saveCachedServices() {
+
+	if exporter.DiscoveryCacheFile == "" {
+		return
+	}
+
+	data, err := json.Marshal(discoveryCache{BaseURL: exporter.BaseURL, Services: exporter.Services})
+	if err != nil {
+		fmt.Println("Error: writing discovery cache: ", err)
+		return
+	}
+	err = ioutil.WriteFile(exporter.DiscoveryCacheFile, data, 0644)
+	if err != nil {
+		fmt.Println("Error: writing discovery cache: ", err)
+	}
+}
+
+// LoadSnapshot loads the services from a discovery cache file, e.g. to check metric definitions offline
+func LoadSnapshot(file string) (map[string]*Service, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache discoveryCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing discovery cache: %v", err)
+	}
+	return cache.Services, nil
+}
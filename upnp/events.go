@@ -0,0 +1,201 @@
+package upnp
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSubscriptionTimeout is requested for event subscriptions without configured timeout
+const defaultSubscriptionTimeout = 30 * time.Minute
+
+// Subscriber subscribes to the events (GENA) of services and receives their NOTIFY callbacks,
+// so state changes are pushed by the box instead of being polled
+type Subscriber struct {
+	Exporter *Exporter
+	// CallbackURL is the URL of the subscriber's handler, which has to be reachable by the box
+	CallbackURL string
+	// Timeout requested for the subscriptions (default 30m), they are renewed after half of the timeout granted by the box
+	Timeout time.Duration
+	// Notify is called with the evented state variables of a service
+	Notify func(serviceType string, variables map[string]string)
+
+	mutex         sync.Mutex
+	subscriptions map[string]*Service // by SID
+}
+
+type propertySet struct {
+	Properties []struct {
+		Variables []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"property"`
+}
+
+// Run subscribes to the services and keeps the subscriptions alive until ctx is done
+func (s *Subscriber) Run(ctx context.Context, serviceTypes []string) error {
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultSubscriptionTimeout
+	}
+
+	services := []*Service{}
+	for _, serviceType := range serviceTypes {
+		service, ok := s.Exporter.Services[serviceType]
+		if !ok || service.EventSubURL == "" {
+			return fmt.Errorf("service %s has no events", serviceType)
+		}
+		services = append(services, service)
+	}
+
+	sids := make([]string, len(services))
+	for {
+		// the subscriptions are renewed after half of the shortest timeout granted by the box
+		renewal := timeout / 2
+		for i, service := range services {
+			sid, granted, err := s.subscribe(ctx, service, sids[i], timeout)
+			if err != nil && sids[i] != "" {
+				// the subscription expired, e.g. after a reboot of the box, so subscribe again
+				sid, granted, err = s.subscribe(ctx, service, "", timeout)
+			}
+			if err != nil {
+				fmt.Printf("Error: subscribing to events of %s: %v\n", service.ServiceType, err)
+			}
+			sids[i] = sid
+			if err == nil && granted/2 < renewal {
+				renewal = granted / 2
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for i, service := range services {
+				if sids[i] != "" {
+					s.unsubscribe(service, sids[i])
+				}
+			}
+			return nil
+		case <-time.After(renewal):
+		}
+	}
+}
+
+// subscribe creates a subscription, or renews it if sid is given, and returns its SID and the timeout granted by the box
+func (s *Subscriber) subscribe(ctx context.Context, service *Service, sid string, timeout time.Duration) (string, time.Duration, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "SUBSCRIBE", s.Exporter.BaseURL+service.EventSubURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if sid == "" {
+		req.Header.Set("CALLBACK", "<"+s.CallbackURL+">")
+		req.Header.Set("NT", "upnp:event")
+	} else {
+		req.Header.Set("SID", sid)
+	}
+	req.Header.Set("TIMEOUT", "Second-"+strconv.Itoa(int(timeout.Seconds())))
+
+	// the box sends the initial event right after the response, so its handler has to wait for the SID
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resp, err := s.Exporter.client().Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("SUBSCRIBE failed: %s", resp.Status)
+	}
+
+	newSID := resp.Header.Get("SID")
+	if newSID == "" {
+		return "", 0, fmt.Errorf("SUBSCRIBE response without SID")
+	}
+	granted, ok := parseSubscriptionTimeout(resp.Header.Get("TIMEOUT"))
+	if !ok {
+		granted = timeout
+	}
+
+	if s.subscriptions == nil {
+		s.subscriptions = map[string]*Service{}
+	}
+	if sid != newSID {
+		delete(s.subscriptions, sid)
+	}
+	s.subscriptions[newSID] = service
+	return newSID, granted, nil
+}
+
+// parseSubscriptionTimeout parses the TIMEOUT header of a SUBSCRIBE response (Second-N), infinite or invalid
+// timeouts are not ok
+func parseSubscriptionTimeout(header string) (time.Duration, bool) {
+
+	seconds, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(header), "Second-"))
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+func (s *Subscriber) unsubscribe(service *Service, sid string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "UNSUBSCRIBE", s.Exporter.BaseURL+service.EventSubURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("SID", sid)
+	resp, err := s.Exporter.client().Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.subscriptions, sid)
+}
+
+// ServeHTTP receives the NOTIFY callbacks of the box
+func (s *Subscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "NOTIFY" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mutex.Lock()
+	service, ok := s.subscriptions[r.Header.Get("SID")]
+	s.mutex.Unlock()
+	if !ok {
+		// unknown subscriptions make the box cancel them
+		http.Error(w, "unknown subscription", http.StatusPreconditionFailed)
+		return
+	}
+
+	var set propertySet
+	err := xml.NewDecoder(r.Body).Decode(&set)
+	if err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	variables := map[string]string{}
+	for _, property := range set.Properties {
+		for _, variable := range property.Variables {
+			variables[variable.XMLName.Local] = strings.TrimSpace(variable.Value)
+		}
+	}
+	if s.Notify != nil {
+		s.Notify(service.ServiceType, variables)
+	}
+}
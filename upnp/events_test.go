@@ -0,0 +1,27 @@
+package upnp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSubscriptionTimeout(t *testing.T) {
+
+	tests := []struct {
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"Second-1800", 30 * time.Minute, true},
+		{" Second-300 ", 5 * time.Minute, true},
+		{"Second-infinite", 0, false},
+		{"Second-0", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSubscriptionTimeout(tt.header)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseSubscriptionTimeout(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
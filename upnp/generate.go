@@ -0,0 +1,100 @@
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+)
+
+var (
+	serviceNameRegex = regexp.MustCompile(`:service:([^:]+):(\d+)$`)
+	camelCaseRegex   = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	nonAlphaNumRegex = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// GenerateMetrics creates metric definitions for all numeric results of the available get-only actions
+func GenerateMetrics(URL string, username string, password string, client *http.Client) (*metric.MetricsFile, error) {
+
+	upnpExporter := Exporter{BaseURL: URL, Username: username, Password: password, Client: client}
+
+	err := upnpExporter.LoadServices()
+	if err != nil {
+		return nil, err
+	}
+
+	metricsFile := &metric.MetricsFile{}
+
+	serviceKeys := []string{}
+	for serviceKey := range upnpExporter.Services {
+		serviceKeys = append(serviceKeys, serviceKey)
+	}
+	sort.Strings(serviceKeys)
+
+	for _, serviceKey := range serviceKeys {
+		service := upnpExporter.Services[serviceKey]
+
+		actionKeys := []string{}
+		for actionKey := range service.Actions {
+			actionKeys = append(actionKeys, actionKey)
+		}
+		sort.Strings(actionKeys)
+
+		for _, actionKey := range actionKeys {
+			action := service.Actions[actionKey]
+			if !action.IsGetOnly() {
+				continue
+			}
+
+			for _, argument := range action.Arguments {
+				if argument.StateVariable == nil || !isNumericDataType(argument.StateVariable.DataType) {
+					continue
+				}
+				metricsFile.Metrics = append(metricsFile.Metrics, generateMetric(service, action, argument.StateVariable))
+			}
+		}
+	}
+	return metricsFile, nil
+}
+
+func generateMetric(service *Service, action *Action, stateVariable *StateVariable) *metric.Metric {
+
+	serviceName := service.ServiceType
+	if match := serviceNameRegex.FindStringSubmatch(service.ServiceType); match != nil {
+		serviceName = match[1]
+	}
+
+	promType := "GaugeValue"
+	if strings.HasPrefix(stateVariable.Name, "Total") {
+		promType = "CounterValue"
+	}
+
+	return &metric.Metric{
+		Service:   service.ServiceType,
+		Action:    action.Name,
+		ResultKey: stateVariable.Name,
+		PromDesc: metric.PromDesc{
+			FqName:    "gateway_" + toSnakeCase(serviceName) + "_" + toSnakeCase(stateVariable.Name),
+			Help:      fmt.Sprintf("%s from %s.%s", stateVariable.Name, serviceName, action.Name),
+			VarLabels: []string{"gateway"},
+		},
+		PromType: promType,
+	}
+}
+
+func isNumericDataType(dataType string) bool {
+
+	dataTypesMutex.RLock()
+	defer dataTypesMutex.RUnlock()
+	return dataTypes[dataType].Numeric
+}
+
+func toSnakeCase(s string) string {
+
+	s = camelCaseRegex.ReplaceAllString(s, "${1}_${2}")
+	s = nonAlphaNumRegex.ReplaceAllString(strings.ToLower(s), "_")
+	return strings.Trim(s, "_")
+}
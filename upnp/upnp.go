@@ -2,9 +2,8 @@ package upnp
 
 import (
 	"bytes"
-	"crypto/md5"
-	"crypto/rand"
-	"crypto/tls"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
@@ -15,9 +14,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/aexel90/fritzbox_exporter/httpclient"
 	"github.com/aexel90/fritzbox_exporter/metric"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/aexel90/fritzbox_exporter/timing"
 )
 
 const textXML = `text/xml; charset="utf-8"`
@@ -29,12 +31,40 @@ const soapActionXML = `<?xml version="1.0" encoding="utf-8"?>` +
 
 // Exporter struct
 type Exporter struct {
-	BaseURL    string
-	Username   string
-	Password   string
-	Device     Device `xml:"device"`
-	Services   map[string]*Service
-	AuthHeader string
+	BaseURL  string
+	Username string
+	Password string
+	Device   Device `xml:"device"`
+	Services map[string]*Service
+	// Client used for all requests to the box (default httpclient.Default)
+	Client *http.Client
+	// Stages receives the durations of the collection pipeline stages (optional)
+	Stages *timing.Stages
+
+	// DiscoveryInterval discovers the services again after this duration, e.g. to pick up
+	// services of a firmware update (0 = only at startup and after unknown service errors)
+	DiscoveryInterval time.Duration
+	// DiscoveryCacheFile persists the discovered services, so a restart needs no discovery (optional)
+	DiscoveryCacheFile string
+
+	discovered    time.Time
+	servicesStale bool
+
+	clientOnce sync.Once
+	authClient *http.Client
+	digest     *DigestTransport
+
+	// LatencyThreshold skips high cost metrics of a collection round, as soon as the
+	// average request latency of the round exceeds it (0 = disabled)
+	LatencyThreshold time.Duration
+	// Degraded reports if high cost metrics were skipped during the last collection round
+	Degraded bool
+	// CacheHits is the number of action results of the last collection round shared by several metrics
+	CacheHits int
+
+	roundLatency time.Duration
+	roundCalls   int
+	roundErr     error
 }
 
 // Device struct
@@ -129,6 +159,15 @@ type FaultError struct {
 	ErrorDescription string `xml:"errorDescription"`
 }
 
+type listEntry struct {
+	Fields []listField `xml:",any"`
+}
+
+type listField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
 type collectEntry struct {
 	Service   string                 `json:"service"`
 	Action    string                 `json:"action"`
@@ -136,12 +175,14 @@ type collectEntry struct {
 	Result    map[string]interface{} `json:"result"`
 }
 
-var (
-	collectErrors = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "fritzbox_exporter_collect_errors",
-		Help: "Number of collection errors.",
-	})
-)
+// countError counts the error of the action and remembers the first error of the collection round
+func (exporter *Exporter) countError(service string, action string, err error) {
+
+	metric.CountError("upnp", service, action, err)
+	if exporter.roundErr == nil {
+		exporter.roundErr = err
+	}
+}
 
 // IsGetOnly Returns if the action seems to be a query for information.
 // This is determined by checking if the action has no input arguments and at least one output argument.
@@ -154,13 +195,51 @@ func (action *Action) IsGetOnly() bool {
 	return len(action.Arguments) > 0
 }
 
-// LoadServices loads the services tree from device
+// client returns the configured client wrapped by the digest transport for the credentials of the exporter
+func (exporter *Exporter) client() *http.Client {
+
+	exporter.clientOnce.Do(func() {
+		client := *httpclient.Default()
+		if exporter.Client != nil {
+			client = *exporter.Client
+		}
+		exporter.digest = &DigestTransport{Next: client.Transport, Username: exporter.Username, Password: exporter.Password, Stages: exporter.Stages}
+		client.Transport = exporter.digest
+		exporter.authClient = &client
+	})
+	return exporter.authClient
+}
+
+// ResetAuth drops the cached digest challenge, so the next request is authenticated from scratch
+func (exporter *Exporter) ResetAuth() {
+
+	if exporter.digest != nil {
+		exporter.digest.Reset()
+	}
+}
+
+// Invalidate drops the cached digest challenge and loads the services tree from the device again
+func (exporter *Exporter) Invalidate() error {
+	exporter.ResetAuth()
+	return exporter.Rediscover()
+}
+
+// LoadServices loads the services tree from the cache file or the device
 func (exporter *Exporter) LoadServices() error {
 
-	// disable certificate validation, since fritz.box uses self signed cert
-	if strings.HasPrefix(exporter.BaseURL, "https://") {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if exporter.loadCachedServices() {
+		return nil
 	}
+	return exporter.Rediscover()
+}
+
+// Rediscover loads the services tree from the device
+func (exporter *Exporter) Rediscover() error {
+
+	defer exporter.Stages.Since(timing.Discovery, time.Now())
+
+	// the descriptions are decoded into the exporter, so drop the previous ones
+	exporter.Device = Device{}
 
 	//igddesc.xml
 	err := exporter.load("igddesc.xml")
@@ -175,18 +254,24 @@ func (exporter *Exporter) LoadServices() error {
 	}
 
 	// fill services
+	services := exporter.Services
 	exporter.Services = make(map[string]*Service)
 	err = exporter.fillServicesForDevice(&exporter.Device)
 	if err != nil {
+		exporter.Services = services
 		return err
 	}
+
+	exporter.discovered = time.Now()
+	exporter.servicesStale = false
+	exporter.saveCachedServices()
 	return nil
 }
 
 // CollectAll available upnp metrics
-func CollectAll(URL string, username string, password string, resultFile string) {
+func CollectAll(URL string, username string, password string, client *http.Client, resultFile string) {
 
-	upnpExporter := Exporter{BaseURL: URL, Username: username, Password: password}
+	upnpExporter := Exporter{BaseURL: URL, Username: username, Password: password, Client: client}
 
 	err := upnpExporter.LoadServices()
 	if err != nil {
@@ -223,7 +308,7 @@ func CollectAll(URL string, username string, password string, resultFile string)
 				result["error"] = errorResult
 
 			} else {
-				result, err = upnpExporter.call(action, nil)
+				result, err = upnpExporter.call(context.Background(), action, nil)
 				if err != nil {
 					result = make(map[string]interface{})
 					var errorResult = fmt.Sprintf("FAILED:%s", err.Error())
@@ -258,27 +343,141 @@ func CollectAll(URL string, username string, password string, resultFile string)
 
 // Collect func
 func (exporter *Exporter) Collect(metrics []*metric.Metric) error {
+	return exporter.CollectWithContext(context.Background(), metrics)
+}
+
+// CollectWithContext collects the metrics and aborts as soon as ctx is done
+func (exporter *Exporter) CollectWithContext(ctx context.Context, metrics []*metric.Metric) error {
+
+	if exporter.needsRediscovery() {
+		err := exporter.Rediscover()
+		if err != nil {
+			return err
+		}
+	}
 
 	var cachedResults = make(map[string]map[string]interface{})
 
-	for _, metric := range metrics {
+	exporter.Degraded = false
+	exporter.CacheHits = 0
+	exporter.roundLatency = 0
+	exporter.roundCalls = 0
+	exporter.roundErr = nil
 
-		metric.MetricResult = nil // remove already collected metrics
+	// a failing metric must not affect the others, the round fails only if no metric got a result
+	collected, failed := 0, 0
 
-		result, err := exporter.request(cachedResults, metric)
-		if err != nil {
-			fmt.Println(err.Error())
-			collectErrors.Inc()
-			return err
+	// collect high cost metrics last, so they can be skipped if the box is under load
+	for _, highCost := range []bool{false, true} {
+		for _, metric := range metrics {
+			if metric.HighCost != highCost {
+				continue
+			}
+
+			// remove already collected metrics
+			metric.MetricResult = nil
+			metric.Err = nil
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if highCost && exporter.isOverloaded() {
+				exporter.Degraded = true
+				continue
+			}
+
+			result, err := exporter.request(ctx, cachedResults, metric)
+			metric.MetricResult = result
+			metric.ResultTypes = exporter.resultTypes(metric)
+			metric.Err = err
+			if err != nil {
+				exporter.countErrors(metric, err)
+			}
+
+			switch {
+			case len(result) > 0:
+				collected++
+			case err != nil:
+				failed++
+			}
+		}
+	}
+
+	if collected == 0 && failed > 0 {
+		if exporter.roundErr == nil {
+			return fmt.Errorf("all %d metrics failed", failed)
 		}
-		metric.MetricResult = result
+		return fmt.Errorf("all %d metrics failed: %w", failed, exporter.roundErr)
 	}
 	return nil
 }
 
+// resultTypes returns the data types of the action results of the metric, keyed by state variable like the results
+func (exporter *Exporter) resultTypes(m *metric.Metric) map[string]string {
+
+	serviceTypes := []string{m.Service}
+	if strings.HasSuffix(m.Service, ":*") {
+		serviceTypes = instancesOf(exporter.Services, m.Service)
+	}
+	types := make(map[string]string)
+	for _, serviceType := range serviceTypes {
+		for name, dataType := range exporter.ResultTypes(serviceType, m.Action) {
+			types[name] = dataType
+		}
+	}
+	return types
+}
+
+// ResultTypes returns the data types of the action results (e.g. ui4), keyed by state variable like the results
+func (exporter *Exporter) ResultTypes(serviceType string, actionName string) map[string]string {
+
+	types := make(map[string]string)
+	service, ok := exporter.Services[serviceType]
+	if !ok {
+		return types
+	}
+	action, ok := service.Actions[actionName]
+	if !ok {
+		return types
+	}
+	for _, argument := range action.Arguments {
+		if argument.Direction == "out" && argument.StateVariable != nil {
+			types[argument.StateVariable.Name] = argument.StateVariable.DataType
+		}
+	}
+	return types
+}
+
+// countErrors counts the failed actions of the metric, each failed index of iterated actions
+func (exporter *Exporter) countErrors(m *metric.Metric, err error) {
+
+	var indexErrs IndexErrors
+	var actionErr *ActionError
+	switch {
+	case errors.As(err, &indexErrs):
+		for _, e := range indexErrs {
+			exporter.countError(e.Service, e.Action, e.Err)
+		}
+	case errors.As(err, &actionErr):
+		exporter.countError(actionErr.Service, actionErr.Action, actionErr.Err)
+	default:
+		exporter.countError(m.Service, m.Action, err)
+	}
+}
+
+// isOverloaded reports if the average request latency of the current round exceeds the threshold
+func (exporter *Exporter) isOverloaded() bool {
+
+	if exporter.LatencyThreshold == 0 || exporter.roundCalls == 0 {
+		return false
+	}
+	return exporter.roundLatency/time.Duration(exporter.roundCalls) > exporter.LatencyThreshold
+}
+
 func (exporter *Exporter) load(path string) error {
 
-	HTTPResponse, err := http.Get(fmt.Sprintf("%s/%s", exporter.BaseURL, path))
+	HTTPResponse, err := exporter.client().Get(fmt.Sprintf("%s/%s", exporter.BaseURL, path))
 	if err != nil {
 		return err
 	}
@@ -296,7 +495,7 @@ func (exporter *Exporter) fillServicesForDevice(device *Device) error {
 
 	for _, service := range device.Services {
 
-		HTTPResponse, err := http.Get(exporter.BaseURL + service.SCPDUrl)
+		HTTPResponse, err := exporter.client().Get(exporter.BaseURL + service.SCPDUrl)
 		if err != nil {
 			return err
 		}
@@ -340,64 +539,342 @@ func (exporter *Exporter) fillServicesForDevice(device *Device) error {
 	return nil
 }
 
-func (exporter *Exporter) request(cachedResults map[string]map[string]interface{}, m *metric.Metric) ([]map[string]interface{}, error) {
+// ActionError is a failed action of a metric, Index is the index of iterated actions (-1 otherwise)
+type ActionError struct {
+	Service string
+	Action  string
+	Index   int
+	Err     error
+}
+
+func (e *ActionError) Error() string {
+
+	if e.Index >= 0 {
+		return fmt.Sprintf("%s.%s index %d: %v", e.Service, e.Action, e.Index, e.Err)
+	}
+	return fmt.Sprintf("%s.%s: %v", e.Service, e.Action, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// IndexErrors are the failed indices of an iterated action, the results of the other indices are
+// returned along with them
+type IndexErrors []*ActionError
+
+func (e IndexErrors) Error() string {
+
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d indices failed, first %v", len(e), e[0])
+}
+
+func (e IndexErrors) Unwrap() []error {
+
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// request returns the results of the metric. Failed actions return an ActionError without results, failed
+// indices of iterated actions are skipped and returned as IndexErrors along with the other results.
+func (exporter *Exporter) request(ctx context.Context, cachedResults map[string]map[string]interface{}, m *metric.Metric) ([]map[string]interface{}, error) {
+
+	if strings.HasSuffix(m.Service, ":*") {
+		return exporter.requestInstances(ctx, cachedResults, m)
+	}
 
 	var allResults []map[string]interface{}
+	var err error
+	if m.ActionArgument != nil && m.ActionArgument.IsIndex {
+		allResults, err = exporter.requestIndices(ctx, cachedResults, m)
+		if len(allResults) == 0 {
+			return nil, err
+		}
+	} else {
+		var actArg *ActionArgument
+		if m.ActionArgument != nil {
+			value, argErr := exporter.argumentValue(ctx, cachedResults, m)
+			if argErr != nil {
+				return nil, argErr
+			}
+			actArg = &ActionArgument{Name: m.ActionArgument.Name, Value: value}
+		}
+		result, callErr := exporter.getActionResult(ctx, cachedResults, m.Service, m.Action, actArg)
+		if callErr != nil {
+			return nil, &ActionError{Service: m.Service, Action: m.Action, Index: -1, Err: callErr}
+		}
+		allResults = append(allResults, result)
+	}
 
-	var actArg *ActionArgument
-	if m.ActionArgument != nil {
-		a := m.ActionArgument
-		var value interface{}
-		value = a.Value
+	if m.ListURLKey != "" || m.ListKey != "" {
+		lists, listErr := exporter.fetchList(ctx, allResults, m)
+		if listErr != nil {
+			return nil, listErr
+		}
+		return lists, err
+	}
+	return allResults, err
+}
 
-		if a.ProviderAction != "" {
-			providerResult, err := exporter.getActionResult(cachedResults, m.Service, a.ProviderAction, nil)
+// argumentValue is the value of the action argument, for provider actions the result of the provider action
+func (exporter *Exporter) argumentValue(ctx context.Context, cachedResults map[string]map[string]interface{}, m *metric.Metric) (interface{}, error) {
 
-			if err != nil {
-				fmt.Printf("Error getting provider action %s result for %s.%s: %s\n", a.ProviderAction, m.Service, m.Action, err.Error())
-				collectErrors.Inc()
+	a := m.ActionArgument
+	if a.ProviderAction == "" {
+		return a.Value, nil
+	}
+	providerResult, err := exporter.getActionResult(ctx, cachedResults, m.Service, a.ProviderAction, nil)
+	if err != nil {
+		return nil, &ActionError{Service: m.Service, Action: a.ProviderAction, Index: -1, Err: err}
+	}
+	// Value contains the result name for provider actions
+	value, ok := providerResult[a.Value]
+	if !ok {
+		return nil, &ActionError{Service: m.Service, Action: a.ProviderAction, Index: -1,
+			Err: metric.NewReasonError(metric.ReasonMissingResult, fmt.Errorf("no result %s", a.Value))}
+	}
+	return value, nil
+}
+
+// requestIndices iterates the action over the number of indices given by the argument value
+func (exporter *Exporter) requestIndices(ctx context.Context, cachedResults map[string]map[string]interface{}, m *metric.Metric) ([]map[string]interface{}, error) {
+
+	a := m.ActionArgument
+	value, err := exporter.argumentValue(ctx, cachedResults, m)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(fmt.Sprintf("%v", value))
+	if err != nil {
+		return nil, &ActionError{Service: m.Service, Action: a.ProviderAction, Index: -1,
+			Err: metric.NewReasonError(metric.ReasonInvalidResponse, fmt.Errorf("invalid number of indices: %v", err))}
+	}
+
+	step := a.IndexStep
+	if step == 0 {
+		step = 1
+	}
+
+	var results []map[string]interface{}
+	var errs IndexErrors
+	for n := 0; n < count; n++ {
+		i := a.IndexStart + n*step
+		result, err := exporter.getActionResult(ctx, cachedResults, m.Service, m.Action, &ActionArgument{Name: a.Name, Value: i})
+		if err != nil {
+			// with stopOnError the first failing index ends the list, e.g. if the count is just an upper bound
+			if a.StopOnError {
+				break
+			}
+			errs = append(errs, &ActionError{Service: m.Service, Action: m.Action, Index: i, Err: err})
+			if ctx.Err() != nil {
+				break
 			}
+			continue
+		}
+		result["index"] = i
+		results = append(results, result)
+	}
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+// requestInstances requests the metric from all instances of a service type ending with ":*"
+// (e.g. WLANConfiguration:* for all WLANs of the box). The results get the instance number as "instance".
+func (exporter *Exporter) requestInstances(ctx context.Context, cachedResults map[string]map[string]interface{}, m *metric.Metric) ([]map[string]interface{}, error) {
+
+	prefix := strings.TrimSuffix(m.Service, "*")
+	serviceTypes := instancesOf(exporter.Services, m.Service)
 
-			var ok bool
-			value, ok = providerResult[a.Value] // Value contains the result name for provider actions
+	var allResults []map[string]interface{}
+	var errs IndexErrors
+	for _, serviceType := range serviceTypes {
+		instance := *m
+		instance.Service = serviceType
+
+		results, err := exporter.request(ctx, cachedResults, &instance)
+		var indexErrs IndexErrors
+		if err != nil && !errors.As(err, &indexErrs) {
+			return nil, err
+		}
+		errs = append(errs, indexErrs...)
+		for _, result := range results {
+			result["instance"] = strings.TrimPrefix(serviceType, prefix)
+		}
+		allResults = append(allResults, results...)
+	}
+	if len(errs) > 0 {
+		return allResults, errs
+	}
+	return allResults, nil
+}
+
+// fetchList replaces each result by the entries of its list, either the document referenced by the result's
+// ListURLKey or the document embedded in the result's ListKey
+func (exporter *Exporter) fetchList(ctx context.Context, results []map[string]interface{}, m *metric.Metric) ([]map[string]interface{}, error) {
+
+	var allEntries []map[string]interface{}
+
+	for _, result := range results {
+		var entries []map[string]interface{}
+		var err error
+		key := m.ListKey
+		if m.ListURLKey != "" {
+			key = m.ListURLKey
+			listURL, ok := result[m.ListURLKey].(string)
+			if !ok || listURL == "" {
+				return nil, fmt.Errorf("%s.%s has no list URL result %s", m.Service, m.Action, m.ListURLKey)
+			}
+			entries, err = exporter.loadList(ctx, listURL, m.ListFormat, m.ListElement)
+		} else {
+			list, ok := result[m.ListKey].(string)
 			if !ok {
-				fmt.Printf("provider action %s for %s.%s has no result %s", m.Service, m.Action, a.Value, providerResult)
-				collectErrors.Inc()
+				return nil, fmt.Errorf("%s.%s has no list result %s", m.Service, m.Action, m.ListKey)
 			}
+			entries, err = parseList([]byte(list), m.ListFormat, m.ListElement)
+		}
+		if err != nil {
+			return nil, err
 		}
 
-		if a.IsIndex {
-			sval := fmt.Sprintf("%v", value)
-			count, err := strconv.Atoi(sval)
-			if err != nil {
-				fmt.Println(err.Error())
-				collectErrors.Inc()
+		for _, entry := range entries {
+			for k, value := range result {
+				if _, exists := entry[k]; !exists && k != key {
+					entry[k] = value
+				}
 			}
+		}
+		allEntries = append(allEntries, entries...)
+	}
+	return allEntries, nil
+}
 
-			for i := 0; i < count; i++ {
-				actArg = &ActionArgument{Name: a.Name, Value: i}
-				result, err := exporter.getActionResult(cachedResults, m.Service, m.Action, actArg)
+func (exporter *Exporter) loadList(ctx context.Context, listURL string, format string, element string) ([]map[string]interface{}, error) {
 
-				if err != nil {
-					fmt.Println(err.Error())
-					collectErrors.Inc()
-				}
-				allResults = append(allResults, result)
+	// some lists are returned as path on the box, e.g. the host list of X_AVM-DE_GetHostListPath
+	if strings.HasPrefix(listURL, "/") {
+		listURL = exporter.BaseURL + listURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	HTTPResponse, err := exporter.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer HTTPResponse.Body.Close()
+
+	if HTTPResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list request response not OK: %v", HTTPResponse.Status)
+	}
+
+	body, err := ioutil.ReadAll(HTTPResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+	exporter.Stages.Since(timing.Fetch, start)
+	defer exporter.Stages.Since(timing.Parse, time.Now())
+
+	return parseList(body, format, element)
+}
+
+// parseList returns the entries of an XML list (one per element) or a CSV list (one per line after the header)
+func parseList(body []byte, format string, element string) ([]map[string]interface{}, error) {
+
+	if format == "csv" {
+		return parseCSVList(body)
+	}
+
+	var entries []map[string]interface{}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == element {
+			var entry listEntry
+			err = decoder.DecodeElement(&entry, &se)
+			if err != nil {
+				return nil, err
 			}
+
+			result := make(map[string]interface{})
+			for _, field := range entry.Fields {
+				result[field.XMLName.Local] = strings.TrimSpace(field.Value)
+			}
+			entries = append(entries, result)
 		}
-	} else {
+	}
+}
 
-		result, err := exporter.getActionResult(cachedResults, m.Service, m.Action, actArg)
-		if err != nil {
-			fmt.Println(err.Error())
-			collectErrors.Inc()
+// parseCSVList parses a CSV list with the field names in the first line, separated by commas or semicolons
+func parseCSVList(body []byte) ([]map[string]interface{}, error) {
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	firstLine := body
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		firstLine = body[:i]
+	}
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV list: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	var entries []map[string]interface{}
+	for _, record := range records[1:] {
+		result := make(map[string]interface{})
+		for i, value := range record {
+			if i < len(header) {
+				result[header[i]] = value
+			}
 		}
-		allResults = append(allResults, result)
+		entries = append(entries, result)
 	}
-	return allResults, nil
+	return entries, nil
+}
+
+// Request collects the results of a single metric definition outside of a collection round, failed indices
+// of iterated actions are returned as IndexErrors along with the other results
+func (exporter *Exporter) Request(ctx context.Context, m *metric.Metric) ([]map[string]interface{}, error) {
+	return exporter.request(ctx, make(map[string]map[string]interface{}), m)
+}
+
+// Call calls an action without arguments and returns its result
+func (exporter *Exporter) Call(ctx context.Context, serviceType string, actionName string) (map[string]interface{}, error) {
+	return exporter.getActionResult(ctx, make(map[string]map[string]interface{}), serviceType, actionName, nil)
+}
+
+// CallWithArgument calls an action with a single argument, e.g. the index of a table entry, and returns its result
+func (exporter *Exporter) CallWithArgument(ctx context.Context, serviceType string, actionName string, argument *ActionArgument) (map[string]interface{}, error) {
+	return exporter.getActionResult(ctx, make(map[string]map[string]interface{}), serviceType, actionName, argument)
 }
 
-func (exporter *Exporter) getActionResult(cachedResults map[string]map[string]interface{}, serviceType string, actionName string, actionArg *ActionArgument) (map[string]interface{}, error) {
+func (exporter *Exporter) getActionResult(ctx context.Context, cachedResults map[string]map[string]interface{}, serviceType string, actionName string, actionArg *ActionArgument) (map[string]interface{}, error) {
 
 	key := serviceType + "|" + actionName
 
@@ -411,75 +888,64 @@ func (exporter *Exporter) getActionResult(cachedResults map[string]map[string]in
 	if cacheEntry == nil {
 		service, ok := exporter.Services[serviceType]
 		if !ok {
-			return nil, fmt.Errorf("service %s not found", serviceType)
+			exporter.servicesStale = true
+			return nil, metric.NewReasonError(metric.ReasonUnknownService, fmt.Errorf("service %s not found", serviceType))
 		}
 
 		action, ok := service.Actions[actionName]
 		if !ok {
-			return nil, fmt.Errorf("action %s not found in service %s", actionName, serviceType)
+			exporter.servicesStale = true
+			return nil, metric.NewReasonError(metric.ReasonUnknownAction, fmt.Errorf("action %s not found in service %s", actionName, serviceType))
 		}
 
 		var err error
-		cacheEntry, err = exporter.call(action, actionArg)
+		cacheEntry, err = exporter.call(ctx, action, actionArg)
 
 		if err != nil {
 			return nil, err
 		}
 
 		cachedResults[key] = cacheEntry
+	} else {
+		exporter.CacheHits++
 	}
 
 	return cacheEntry, nil
 }
 
-func (exporter *Exporter) call(action *Action, actionArg *ActionArgument) (map[string]interface{}, error) {
+func (exporter *Exporter) call(ctx context.Context, action *Action, actionArg *ActionArgument) (map[string]interface{}, error) {
 
-	req, err := exporter.createCallHTTPRequest(action, actionArg)
+	req, err := exporter.createCallHTTPRequest(ctx, action, actionArg)
 	if err != nil {
 		return nil, err
 	}
 
-	// reuse prior authHeader, to avoid unnecessary authentication
-	if exporter.AuthHeader != "" {
-		req.Header.Set("Authorization", exporter.AuthHeader)
-	}
+	start := time.Now()
+	defer func() {
+		exporter.roundLatency += time.Since(start)
+		exporter.roundCalls++
+	}()
 
-	// first try call without auth header
-	resp, err := http.DefaultClient.Do(req)
+	// the digest transport of the client answers the auth challenges of the box
+	authBefore := exporter.Stages.Get(timing.Auth)
+	resp, err := exporter.client().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", action.Name, err)
 	}
 
-	wwwAuth := resp.Header.Get("WWW-Authenticate")
-	if resp.StatusCode == http.StatusUnauthorized {
-		resp.Body.Close() // close now, since we make a new request below or fail
-
-		if wwwAuth != "" && exporter.Username != "" && exporter.Password != "" {
-			// call failed, but we have a password so calculate header and try again
-			err = exporter.getDigestAuthHeader(action, wwwAuth, exporter.Username, exporter.Password)
-			if err != nil {
-				return nil, fmt.Errorf("%s: %s", action.Name, err.Error())
-			}
-
-			req, err = exporter.createCallHTTPRequest(action, actionArg)
-			if err != nil {
-				return nil, fmt.Errorf("%s: %s", action.Name, err.Error())
-			}
-
-			req.Header.Set("Authorization", exporter.AuthHeader)
-			resp, err = http.DefaultClient.Do(req)
-			if err != nil {
-				return nil, fmt.Errorf("%s: %s", action.Name, err.Error())
-			}
-		} else {
-			return nil, fmt.Errorf("%s: Unauthorized, but no username and password given", action.Name)
-		}
+	if resp.StatusCode == http.StatusUnauthorized && (exporter.Username == "" || exporter.Password == "") {
+		resp.Body.Close()
+		return nil, metric.NewReasonError(metric.ReasonAuth, fmt.Errorf("%s: Unauthorized, but no username and password given", action.Name))
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		errMsg := fmt.Sprintf("%s (%d)", http.StatusText(resp.StatusCode), resp.StatusCode)
+		reason := metric.ReasonHTTPStatus
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			reason = metric.ReasonAuth
+		}
 		if resp.StatusCode == http.StatusInternalServerError {
 			buf := new(strings.Builder)
 			io.Copy(buf, resp.Body)
@@ -490,6 +956,7 @@ func (exporter *Exporter) call(action *Action, actionArg *ActionArgument) (map[s
 			if err != nil {
 				errMsg = fmt.Sprintf("error decoding SOAPFault: %s", err.Error())
 			} else {
+				reason = metric.ReasonSoapFault
 				soapFault := soapEnv.Body.Fault
 
 				if soapFault.FaultString == "UPnPError" {
@@ -500,58 +967,20 @@ func (exporter *Exporter) call(action *Action, actionArg *ActionArgument) (map[s
 				}
 			}
 		}
-		return nil, fmt.Errorf("%s: %s", action.Name, errMsg)
+		return nil, metric.NewReasonError(reason, fmt.Errorf("%s: %s", action.Name, errMsg))
 	}
-	return action.parseSoapResponse(resp.Body)
-}
-
-func (exporter *Exporter) getDigestAuthHeader(a *Action, wwwAuth string, username string, password string) error {
 
-	if !strings.HasPrefix(wwwAuth, "Digest ") {
-		return fmt.Errorf("WWW-Authentication header is not Digest: '%s'", wwwAuth)
-	}
-
-	s := wwwAuth[7:]
-	d := map[string]string{}
-	for _, kv := range strings.Split(s, ",") {
-		parts := strings.SplitN(kv, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		d[strings.Trim(parts[0], "\" ")] = strings.Trim(parts[1], "\" ")
-	}
-
-	if d["algorithm"] == "" {
-		d["algorithm"] = "MD5"
-	} else if d["algorithm"] != "MD5" {
-		return fmt.Errorf("digest algorithm not supported: %s != MD5", d["algorithm"])
-	}
-
-	if d["qop"] != "auth" {
-		return fmt.Errorf("digest qop not supported: %s != auth", d["qop"])
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action.Name, err)
 	}
+	exporter.Stages.Add(timing.Fetch, time.Since(start)-(exporter.Stages.Get(timing.Auth)-authBefore))
 
-	// calc h1 and h2
-	ha1 := fmt.Sprintf("%x", md5.Sum([]byte(username+":"+d["realm"]+":"+password)))
-	ha2 := fmt.Sprintf("%x", md5.Sum([]byte("POST:"+a.service.ControlURL)))
-
-	cn := make([]byte, 8)
-	rand.Read(cn)
-	cnonce := fmt.Sprintf("%x", cn)
-
-	nCounter := 1
-	nc := fmt.Sprintf("%08x", nCounter)
-
-	ds := strings.Join([]string{ha1, d["nonce"], nc, cnonce, d["qop"], ha2}, ":")
-	response := fmt.Sprintf("%x", md5.Sum([]byte(ds)))
-
-	exporter.AuthHeader = fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", cnonce=\"%s\", nc=%s, qop=%s, response=\"%s\", algorithm=%s",
-		username, d["realm"], d["nonce"], a.service.ControlURL, cnonce, nc, d["qop"], response, d["algorithm"])
-
-	return nil
+	defer exporter.Stages.Since(timing.Parse, time.Now())
+	return action.parseSoapResponse(bytes.NewReader(body))
 }
 
-func (exporter *Exporter) createCallHTTPRequest(a *Action, actionArg *ActionArgument) (*http.Request, error) {
+func (exporter *Exporter) createCallHTTPRequest(ctx context.Context, a *Action, actionArg *ActionArgument) (*http.Request, error) {
 	argsString := ""
 	if actionArg != nil {
 		var buf bytes.Buffer
@@ -564,7 +993,7 @@ func (exporter *Exporter) createCallHTTPRequest(a *Action, actionArg *ActionArgu
 	url := exporter.BaseURL + a.service.ControlURL
 	body := strings.NewReader(bodystr)
 
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -619,36 +1048,3 @@ func (action *Action) parseSoapResponse(reader io.Reader) (map[string]interface{
 		}
 	}
 }
-
-func convertResult(val string, arg *Argument) (interface{}, error) {
-	switch arg.StateVariable.DataType {
-
-	case "string":
-		return val, nil
-
-	case "boolean":
-		return bool(val == "1"), nil
-
-	case "ui1", "ui2", "ui4":
-		// type ui4 can contain values greater than 2^32!
-		res, err := strconv.ParseUint(val, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		return uint64(res), nil
-
-	case "i4":
-		res, err := strconv.ParseInt(val, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		return int64(res), nil
-
-	case "dateTime", "uuid":
-		// data types we don't convert yet
-		return val, nil
-
-	default:
-		return nil, fmt.Errorf("unknown datatype: %s (%s)", arg.StateVariable.DataType, val)
-	}
-}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aexel90/fritzbox_exporter/metric"
+	"github.com/aexel90/fritzbox_exporter/upnp"
+)
+
+var (
+	flagValidateLive     bool
+	flagValidateSnapshot string
+)
+
+// validationResult is the result of validating a single metric file
+type validationResult struct {
+	File     string   `json:"file"`
+	Exporter string   `json:"exporter"`
+	Metrics  int      `json:"metrics"`
+	Error    string   `json:"error,omitempty"`
+	Problems []string `json:"problems"`
+	Warnings []string `json:"warnings"`
+
+	metricsFile *metric.MetricsFile
+	// lines are the line numbers of the metric definitions in the file
+	lines []int
+}
+
+func registerValidateCommand() {
+
+	cmd := newCommand("validate", "validate the metric definition files (exit code 0 = ok, 1 = problems, 2 = fatal)", validate)
+	addGatewayFlags(cmd.flags)
+	addMetricsFlags(cmd.flags)
+	addOutputFlag(cmd.flags)
+	cmd.flags.BoolVar(&flagValidateLive, "validate.live", false, "Check the upnp metrics against the service descriptions (SCPD) and sample results of the FRITZ!Box.")
+	cmd.flags.StringVar(&flagValidateSnapshot, "validate.scpd-snapshot", "", "Check the upnp metrics offline against the service descriptions of a discovery cache file (see -upnp.discovery-cache).")
+}
+
+func validate() error {
+
+	err := validateOutputFlag()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"lua":  flagMetricsLuaFile,
+		"upnp": flagMetricsUpnpFile,
+	}
+
+	var exporter *upnp.Exporter
+	services := map[string]*upnp.Service{}
+	if flagValidateLive {
+		client, err := newGatewayHTTPClient()
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+		t := defaultTarget()
+		exporter = &upnp.Exporter{BaseURL: t.upnpURL, Username: t.username, Password: t.password, Client: client}
+		err = exporter.LoadServices()
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+		services = exporter.Services
+
+		// only the packs matching the FRITZ!Box are enabled
+		if flagMetricsPacks == "auto" {
+			packs, err := detectPacks(t, client)
+			if err != nil {
+				return &exitError{exitFatal, fmt.Errorf("detecting metric packs: %v", err)}
+			}
+			flagMetricsPacks = strings.Join(packs, ",")
+		}
+	} else if flagValidateSnapshot != "" {
+		services, err = upnp.LoadSnapshot(flagValidateSnapshot)
+		if err != nil {
+			return &exitError{exitFatal, fmt.Errorf("reading SCPD snapshot: %v", err)}
+		}
+	}
+
+	results := []*validationResult{}
+	for _, exporterType := range []string{"lua", "upnp"} {
+		list, err := metric.ExpandFiles(files[exporterType])
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+		fileResults := []*validationResult{}
+		for _, file := range list {
+			fileResults = append(fileResults, validateFile(file, exporterType))
+		}
+		checkDuplicates(fileResults)
+		results = append(results, fileResults...)
+	}
+
+	// the embedded packs can only be detected with -validate.live, otherwise auto validates all of them
+	packs := packNames
+	switch flagMetricsPacks {
+	case "", "none":
+		packs = nil
+	case "auto":
+	default:
+		packs = strings.Split(flagMetricsPacks, ",")
+	}
+	for _, p := range packs {
+		p = strings.TrimSpace(p)
+		if !isPack(p) {
+			results = append(results, &validationResult{File: "embedded pack " + p, Error: fmt.Sprintf("unknown metric pack %q", p), Problems: []string{}, Warnings: []string{}})
+			continue
+		}
+		for _, exporterType := range []string{"lua", "upnp"} {
+			result := validatePack(p, exporterType)
+			if result != nil {
+				results = append(results, result)
+			}
+		}
+	}
+
+	if len(services) > 0 {
+		for _, result := range results {
+			if result.Exporter == "upnp" && result.Error == "" {
+				checkServices(result, services, exporter)
+			}
+		}
+	}
+
+	if flagOutputFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(results)
+		if err != nil {
+			return &exitError{exitFatal, err}
+		}
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s: %s\n", result.File, result.Error)
+				continue
+			}
+			for _, problem := range result.Problems {
+				fmt.Printf("%s: %s\n", result.File, problem)
+			}
+			for _, warning := range result.Warnings {
+				fmt.Printf("%s: warning: %s\n", result.File, warning)
+			}
+			fmt.Printf("%s: %d metrics, %d problems\n", result.File, result.Metrics, len(result.Problems))
+		}
+	}
+
+	problems := 0
+	for _, result := range results {
+		if result.Error != "" {
+			return &exitError{code: exitFatal}
+		}
+		problems += len(result.Problems)
+	}
+	if problems > 0 {
+		if flagOutputFormat == "json" {
+			return &exitError{code: exitPartial}
+		}
+		return &exitError{exitPartial, fmt.Errorf("validation failed with %d problems", problems)}
+	}
+	return nil
+}
+
+func validateFile(file string, exporterType string) *validationResult {
+
+	result := &validationResult{File: file, Exporter: exporterType, Problems: []string{}, Warnings: []string{}}
+
+	metricsFile, err := metric.ReadFile(file)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		result.Error = fmt.Sprintf("error reading metric file: %v", err)
+		return result
+	}
+
+	validateMetricsFile(result, metricsFile, data)
+	return result
+}
+
+// validatePack validates the embedded metric pack for the exporter type, nil if the pack has no such metrics
+func validatePack(pack string, exporterType string) *validationResult {
+
+	file := "packs/" + pack + "-" + exporterType + ".json"
+	result := &validationResult{File: "embedded " + file, Exporter: exporterType, Problems: []string{}, Warnings: []string{}}
+
+	data, err := packFiles.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	var metricsFile *metric.MetricsFile
+	err = json.Unmarshal(data, &metricsFile)
+	if err != nil {
+		result.Error = fmt.Sprintf("error parsing JSON: %v", err)
+		return result
+	}
+
+	validateMetricsFile(result, metricsFile, data)
+	return result
+}
+
+func validateMetricsFile(result *validationResult, metricsFile *metric.MetricsFile, data []byte) {
+
+	result.Metrics = len(metricsFile.Metrics)
+	result.metricsFile = metricsFile
+	result.lines = metricLines(data)
+	for _, err := range metricsFile.Validate(result.Exporter) {
+		result.addProblem(err)
+	}
+	result.Warnings = append(result.Warnings, metricsFile.NamingWarnings()...)
+}
+
+// checkDuplicates reports metrics defined in several of the files, which are merged into one collector
+func checkDuplicates(results []*validationResult) {
+
+	files := []string{}
+	metricsFiles := []*metric.MetricsFile{}
+	for _, result := range results {
+		if result.Error == "" {
+			files = append(files, result.File)
+			metricsFiles = append(metricsFiles, result.metricsFile)
+		}
+	}
+	_, errs := metric.Merge(files, metricsFiles)
+	for _, err := range errs {
+		for _, result := range results {
+			if strings.Contains(err.Error(), " of "+result.File+" ") {
+				result.Problems = append(result.Problems, err.Error())
+			}
+		}
+	}
+}
+
+// checkServices checks the upnp metrics against the service descriptions and, if the exporter
+// is given, that the var labels are contained in sample results of the FRITZ!Box
+func checkServices(result *validationResult, services map[string]*upnp.Service, exporter *upnp.Exporter) {
+
+	for i, m := range result.metricsFile.Metrics {
+		if m.Service == "" || m.Action == "" {
+			continue
+		}
+		errs := upnp.CheckMetric(services, m)
+		for _, err := range errs {
+			result.addProblem(metric.NewMetricError(i, m, err))
+		}
+		if exporter == nil || len(errs) > 0 {
+			continue
+		}
+
+		samples, err := exporter.Request(context.Background(), m)
+		if err != nil {
+			result.addProblem(metric.NewMetricError(i, m, fmt.Errorf("sample request failed: %v", err)))
+			continue
+		}
+		for _, label := range upnp.ResultLabels(m) {
+			if !sampled(samples, label) {
+				result.addProblem(metric.NewMetricError(i, m, fmt.Errorf("label '%s' is not contained in the sample results", label)))
+			}
+		}
+	}
+}
+
+// sampled reports if one of the sample results contains the name
+func sampled(samples []map[string]interface{}, name string) bool {
+
+	for _, sample := range samples {
+		if _, ok := sample[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addProblem adds the error, prefixed by the line of the metric definition if known
+func (result *validationResult) addProblem(err error) {
+
+	var metricErr *metric.MetricError
+	if errors.As(err, &metricErr) && metricErr.Index < len(result.lines) {
+		result.Problems = append(result.Problems, fmt.Sprintf("line %d: %v", result.lines[metricErr.Index], err))
+		return
+	}
+	result.Problems = append(result.Problems, err.Error())
+}
+
+// metricLines returns the line numbers of the metric definitions in the JSON data in the order of
+// MetricsFile.Metrics: the metrics without group first, then the metrics of the groups (schema version 2).
+// The metrics of included files have no line. Returns nil if the data can't be scanned.
+func metricLines(data []byte) []int {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	lines := []int{}
+	groupLines := []int{}
+	err := scanObject(decoder, func(key string) error {
+		switch key {
+		case "metrics":
+			return scanArray(decoder, func() error {
+				line, err := elementLine(decoder, data)
+				lines = append(lines, line)
+				return err
+			})
+		case "groups":
+			return scanArray(decoder, func() error {
+				return scanObject(decoder, func(key string) error {
+					if key != "metrics" {
+						return skipValue(decoder)
+					}
+					return scanArray(decoder, func() error {
+						line, err := elementLine(decoder, data)
+						groupLines = append(groupLines, line)
+						return err
+					})
+				})
+			})
+		}
+		return skipValue(decoder)
+	})
+	if err != nil {
+		return nil
+	}
+	return append(lines, groupLines...)
+}
+
+// scanObject calls scanValue for each key of the next JSON object, which has to consume the value
+func scanObject(decoder *json.Decoder, scanValue func(key string) error) error {
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("object expected")
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		err = scanValue(fmt.Sprint(key))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// scanArray calls scanElement for each element of the next JSON array, which has to consume the element
+func scanArray(decoder *json.Decoder, scanElement func() error) error {
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("array expected")
+	}
+	for decoder.More() {
+		err = scanElement()
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// elementLine consumes the next array element and returns the line it starts at
+func elementLine(decoder *json.Decoder, data []byte) (int, error) {
+
+	// the offset is behind the previous token, the element starts after the whitespace and comma
+	offset := int(decoder.InputOffset())
+	for offset < len(data) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1, skipValue(decoder)
+}
+
+func skipValue(decoder *json.Decoder) error {
+
+	var value json.RawMessage
+	return decoder.Decode(&value)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// build information, set during build via -ldflags "-X main.version=... -X main.revision=..."
+var (
+	version  = "dev"
+	revision = "unknown"
+)
+
+func registerVersionCommand() {
+
+	newCommand("version", "print the version of the exporter", printVersion)
+}
+
+func printVersion() error {
+
+	fmt.Printf("fritzbox_exporter %s (revision %s, %s, %s/%s)\n", version, revision, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// newBuildInfo creates the fritzbox_exporter_build_info metric with constant value 1
+func newBuildInfo() prometheus.Collector {
+
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fritzbox_exporter_build_info",
+		Help: "Build information of the exporter (constant 1).",
+	}, []string{"version", "revision", "goversion"})
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+	return buildInfo
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/namsral/flag"
+)
+
+var (
+	flagTLSCert           string
+	flagTLSKey            string
+	flagBasicAuthUsername string
+	flagBasicAuthPassword string
+	flagTLSMinVersion     string
+	flagTLSCipherSuites   string
+	flagBearerToken       string
+	flagBearerTokenFile   string
+)
+
+func addWebSecurityFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagTLSCert, "web.tls-cert", "", "The certificate file for serving HTTPS.")
+	fs.StringVar(&flagTLSKey, "web.tls-key", "", "The key file for serving HTTPS.")
+	fs.StringVar(&flagTLSMinVersion, "web.tls-min-version", "", "The minimum TLS version for serving HTTPS (1.0, 1.1, 1.2, 1.3).")
+	fs.StringVar(&flagTLSCipherSuites, "web.tls-cipher-suites", "", "Comma separated TLS cipher suites allowed for serving HTTPS (TLS 1.0 - 1.2 only).")
+	fs.StringVar(&flagBasicAuthUsername, "web.basic-auth-username", "", "The username required for basic auth.")
+	fs.StringVar(&flagBasicAuthPassword, "web.basic-auth-password", "", "The password required for basic auth.")
+	fs.StringVar(&flagBearerToken, "web.bearer-token", "", "The token required as 'Authorization: Bearer <token>' header.")
+	fs.StringVar(&flagBearerTokenFile, "web.bearer-token-file", "", "The file containing the token required as 'Authorization: Bearer <token>' header.")
+}
+
+func validateWebSecurityFlags() error {
+
+	if (flagTLSCert == "") != (flagTLSKey == "") {
+		return fmt.Errorf("web.tls-cert and web.tls-key must be set together")
+	}
+	if (flagBasicAuthUsername == "") != (flagBasicAuthPassword == "") {
+		return fmt.Errorf("web.basic-auth-username and web.basic-auth-password must be set together")
+	}
+	if flagBearerToken != "" && flagBearerTokenFile != "" {
+		return fmt.Errorf("web.bearer-token and web.bearer-token-file must not be set together")
+	}
+	if flagBearerTokenFile != "" {
+		token, err := ioutil.ReadFile(flagBearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading bearer token file: %v", err)
+		}
+		flagBearerToken = strings.TrimSpace(string(token))
+		if flagBearerToken == "" {
+			return fmt.Errorf("bearer token file %s is empty", flagBearerTokenFile)
+		}
+	}
+	_, err := newTLSConfig(flagTLSMinVersion, flagTLSCipherSuites)
+	return err
+}
+
+// listenAndServe serves via HTTPS if a certificate is configured
+func listenAndServe(server *http.Server) error {
+
+	if flagTLSCert != "" {
+		tlsConfig, err := newTLSConfig(flagTLSMinVersion, flagTLSCipherSuites)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+		return server.ListenAndServeTLS(flagTLSCert, flagTLSKey)
+	}
+	return server.ListenAndServe()
+}
+
+// protect requires basic auth or the bearer token for the handler, if configured
+func protect(handler http.Handler) http.Handler {
+
+	if flagBasicAuthUsername == "" && flagBearerToken == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			if flagBasicAuthUsername != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fritzbox_exporter"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fritzbox_exporter"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func authorized(r *http.Request) bool {
+
+	if flagBearerToken != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(flagBearerToken)) == 1 {
+			return true
+		}
+	}
+
+	if flagBasicAuthUsername != "" {
+		username, password, ok := r.BasicAuth()
+		return ok &&
+			subtle.ConstantTimeCompare([]byte(username), []byte(flagBasicAuthUsername)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(flagBasicAuthPassword)) == 1
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/namsral/flag"
+
+	"github.com/aexel90/fritzbox_exporter/collector"
+)
+
+const webhookTimeout = 10 * time.Second
+
+var flagWebhookRulesFile string
+
+// webhookRules is set by serve, if a rules file is configured
+var webhookRules *ruleSet
+
+// rulesFile holds the threshold rules evaluated after each collection
+type rulesFile struct {
+	Rules []*thresholdRule `json:"rules"`
+}
+
+// thresholdRule fires its webhook, when a sample of the metric crosses the threshold
+type thresholdRule struct {
+	Name   string `json:"name"`
+	Metric string `json:"metric"`
+	// Labels restricts the rule to samples with these label values (optional)
+	Labels   map[string]string `json:"labels,omitempty"`
+	Operator string            `json:"operator"`
+	Value    float64           `json:"value"`
+	Webhook  string            `json:"webhook"`
+}
+
+// webhookPayload is posted as JSON to the webhook of a rule
+type webhookPayload struct {
+	Rule      string            `json:"rule"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Operator  string            `json:"operator"`
+	Threshold float64           `json:"threshold"`
+	Time      time.Time         `json:"time"`
+}
+
+// ruleSet evaluates the rules and remembers which series currently match, so a webhook fires once per crossing
+type ruleSet struct {
+	rules  []*thresholdRule
+	client *http.Client
+
+	mutex    sync.Mutex
+	matching map[string]bool
+}
+
+func addWebhookFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&flagWebhookRulesFile, "webhooks.rules-file", "", "The JSON file with threshold rules (metric, labels, operator, value, webhook) evaluated after each collection, a crossing posts the sample to the webhook.")
+}
+
+// loadWebhookRules reads the rules file, if configured
+func loadWebhookRules() error {
+
+	if flagWebhookRulesFile == "" {
+		return nil
+	}
+
+	var file rulesFile
+	err := readAndParseFile(flagWebhookRulesFile, &file)
+	if err != nil {
+		return err
+	}
+	for i, rule := range file.Rules {
+		if rule.Metric == "" || rule.Webhook == "" {
+			return fmt.Errorf("rule #%d: metric and webhook required", i)
+		}
+		if _, ok := compareOperators[rule.Operator]; !ok {
+			return fmt.Errorf("rule #%d: unknown operator '%s'", i, rule.Operator)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s %s %v", rule.Metric, rule.Operator, rule.Value)
+		}
+	}
+
+	webhookRules = &ruleSet{rules: file.Rules, client: &http.Client{Timeout: webhookTimeout}, matching: map[string]bool{}}
+	fmt.Printf("loaded %d webhook rules from %s\n", len(file.Rules), flagWebhookRulesFile)
+	return nil
+}
+
+var compareOperators = map[string]func(a float64, b float64) bool{
+	">":  func(a float64, b float64) bool { return a > b },
+	">=": func(a float64, b float64) bool { return a >= b },
+	"<":  func(a float64, b float64) bool { return a < b },
+	"<=": func(a float64, b float64) bool { return a <= b },
+	"==": func(a float64, b float64) bool { return a == b },
+	"!=": func(a float64, b float64) bool { return a != b },
+}
+
+// evaluate checks the samples of a collection against the rules and fires the webhooks of new crossings
+func (rs *ruleSet) evaluate(samples []collector.Sample) {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	for _, rule := range rs.rules {
+		for _, sample := range samples {
+			if sample.Name != rule.Metric || !matchLabels(sample.Labels, rule.Labels) {
+				continue
+			}
+
+			key := rule.Name + "\xff" + seriesKey(sample.Labels)
+			match := compareOperators[rule.Operator](sample.Value, rule.Value)
+			if match && !rs.matching[key] {
+				go rs.fire(rule, sample)
+			}
+			rs.matching[key] = match
+		}
+	}
+}
+
+func (rs *ruleSet) fire(rule *thresholdRule, sample collector.Sample) {
+
+	payload := webhookPayload{Rule: rule.Name, Metric: sample.Name, Labels: sample.Labels, Value: sample.Value,
+		Operator: rule.Operator, Threshold: rule.Value, Time: sample.Time}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(payload)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return
+	}
+
+	resp, err := rs.client.Post(rule.Webhook, "application/json", &body)
+	if err != nil {
+		fmt.Printf("Error: webhook of rule '%s': %v\n", rule.Name, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Printf("Error: webhook of rule '%s': %s\n", rule.Name, resp.Status)
+	}
+}
+
+func matchLabels(labels map[string]string, required map[string]string) bool {
+
+	for name, value := range required {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesKey identifies the series of a sample by its sorted labels
+func seriesKey(labels map[string]string) string {
+
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}